@@ -11,8 +11,10 @@ import (
 	"KVDB/internal/platform/repository"
 	"KVDB/internal/platform/repository/lsm_tree"
 	"KVDB/internal/platform/server"
+	"KVDB/internal/platform/server/handler/admin"
 	"KVDB/internal/platform/server/handler/dbentry"
 	"KVDB/internal/platform/server/handler/dbinstance"
+	"KVDB/internal/platform/server/handler/transaction"
 	"flag"
 	"log"
 )
@@ -21,9 +23,21 @@ func Run() (bool, error) {
 	flag.Parse()
 
 	configuration := config.LoadConfig()
-	w, _ := lsm_tree.NewWal(configuration.WalDirectory)
-	mem := lsm_tree.NewMemtable(w)
-	repo := repository.NewLSMTreeRepository(mem)
+
+	if configuration.RestoreCheckpoint != "" {
+		info, err := lsm_tree.RestoreCheckpoint(configuration.RestoreCheckpoint, configuration.WalDirectory)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("Restored checkpoint %s up to sequence %d", configuration.RestoreCheckpoint, info.Sequence)
+	}
+
+	// Recovery must complete before any request or replica message is accepted
+	tree, err := lsm_tree.OpenLsmTree(configuration)
+	if err != nil {
+		return false, err
+	}
+	repo := repository.NewLSMTreeRepository(tree)
 	im := domain.NewDbInstanceManager()
 	tcam := domain.NewTransactionCommitAckManager(im)
 
@@ -48,7 +62,7 @@ func Run() (bool, error) {
 		}
 	case "rb":
 		tbc := publisher.NewZeroMQTransactionBroadcaster(im)
-		rbtm := strategy.NewRbTransactionManager(tbc, tcam, repo, im)
+		rbtm := strategy.NewRbTransactionManager(tbc, tcam, repo, im, tree)
 		transactionListener = listener.NewZeromqTransactionListener(listener.ZmqTransactionListenerDependencies{im, rbtm, rbtm, true})
 		tm = rbtm
 		go transactionListener.Listen()
@@ -67,17 +81,25 @@ func Run() (bool, error) {
 	//Starting required components
 	arSvc := service.NewInstanceAutoRegisterService(csClient, im, configuration)
 	arSvc.Execute()
-	err := gaiSvc.Execute()
+	err = gaiSvc.Execute()
 	if err != nil {
 		return false, err
 	}
 
-	delSvc := service.NewDeleteEntryService(repo)
-	saveSvc := service.NewSaveEntryService(tm)
+	delSvc := service.NewDeleteEntryService(repo, tm)
+	saveSvc := service.NewSaveEntryService(tm, tree)
 	getSvc := service.NewGetEntryService(repo)
-	dbEntryH := dbentry.NewDbEntryHandler(saveSvc, delSvc, getSvc)
+	scanSvc := service.NewScanEntriesService(repo)
+	txSvc := service.NewExecuteTransactionService(tm, repo, tree)
+	sessionSvc := service.NewTransactionSessionService(tm, repo, tree,
+		configuration.TxSessionIdleTimeout, configuration.TxSessionsPerClient)
+	defer sessionSvc.Close()
+	dbEntryH := dbentry.NewDbEntryHandler(saveSvc, delSvc, getSvc, scanSvc)
 	instanceH := dbinstance.NewDbInstanceHandler(uiSvc)
-	srv := server.NewServer(dbEntryH, instanceH, configuration)
+	adminH := admin.NewAdminHandler(tree)
+	txH := transaction.NewTransactionHandler(txSvc)
+	sessionH := transaction.NewSessionHandler(sessionSvc)
+	srv := server.NewServer(dbEntryH, instanceH, adminH, txH, sessionH, configuration)
 
 	err = srv.Run()
 	if err != nil {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"KVDB/internal/platform/repository/lsm_tree"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `Usage:
+  kvdb-fsck [--repair] <data directory>
+  kvdb-fsck dump <data directory | wal segment | sstable | value log file>
+
+Checks the data directory of a stopped node. With --repair, torn WAL and
+value log tails are truncated, unreadable tables are quarantined and tables
+the manifest lists but are missing are dropped from it. dump
+prints every record as a JSON line.
+`
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	repair := flag.Bool("repair", false, "Truncate torn tails and quarantine unreadable tables")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 2 && args[0] == "dump" {
+		if err := dump(args[1]); err != nil {
+			log.Fatalf("dump failed: %v", err)
+		}
+		return
+	}
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	report, err := lsm_tree.Fsck(args[0], *repair)
+	printReport(report)
+	if err != nil {
+		log.Fatalf("check failed: %v", err)
+	}
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+}
+
+func dump(path string) error {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	encoder := json.NewEncoder(out)
+	return lsm_tree.Dump(path, func(record lsm_tree.DumpRecord) error {
+		return encoder.Encode(record)
+	})
+}
+
+func printReport(report lsm_tree.FsckReport) {
+	fmt.Printf("Checked %d wal segments, %d sstables and %d value log files (%d records)\n",
+		report.WalSegments, report.Tables, report.ValueLogFiles, report.Records)
+	if len(report.Issues) == 0 {
+		fmt.Println("No issues found")
+		return
+	}
+	fmt.Printf("%d issues found:\n", len(report.Issues))
+	for _, issue := range report.Issues {
+		fmt.Printf("  %s: %s\n", issue.Path, issue.Problem)
+		if issue.Repair != "" {
+			fmt.Printf("    repaired: %s\n", issue.Repair)
+		}
+	}
+}
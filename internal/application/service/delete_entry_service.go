@@ -7,17 +7,25 @@ import (
 )
 
 type DeleteEntryService struct {
-	repository domain.DbEntryRepository
+	repository         domain.DbEntryRepository
+	transactionManager domain.TransactionExecutionStrategy
 }
 
-func NewDeleteEntryService(repository domain.DbEntryRepository) *DeleteEntryService {
+func NewDeleteEntryService(repository domain.DbEntryRepository,
+	transactionManager domain.TransactionExecutionStrategy) *DeleteEntryService {
 	return &DeleteEntryService{
-		repository: repository,
+		repository:         repository,
+		transactionManager: transactionManager,
 	}
 }
 
+// DeleteEntryCommand deletes Key from Namespace. A Condition, which can
+// only be on the version, makes the delete go through the execution
+// strategy and happen only if the stored entry still has that version.
 type DeleteEntryCommand struct {
-	Key string
+	Namespace string
+	Key       []byte
+	Condition *domain.Condition
 }
 
 type DeleteEntryResult struct {
@@ -26,10 +34,13 @@ type DeleteEntryResult struct {
 }
 
 func (s *DeleteEntryService) Execute(command DeleteEntryCommand) DeleteEntryResult {
-	entry, found := s.repository.Get(command.Key)
+	entry, found := s.repository.Get(namespaceOrDefault(command.Namespace), command.Key)
+	if command.Condition != nil {
+		return s.executeConditional(command, entry, found)
+	}
 	if !found {
 		return DeleteEntryResult{
-			Err: errors.New(fmt.Sprintf("Entry with Key: %s not found in database", command.Key)),
+			Err: errors.New(fmt.Sprintf("Entry with Key: %q not found in database", command.Key)),
 		}
 	}
 	entry.Delete()
@@ -39,3 +50,24 @@ func (s *DeleteEntryService) Execute(command DeleteEntryCommand) DeleteEntryResu
 		Entry: entry,
 	}
 }
+
+func (s *DeleteEntryService) executeConditional(command DeleteEntryCommand, entry domain.DbEntry,
+	found bool) DeleteEntryResult {
+	if command.Condition.If != domain.IfVersionEquals {
+		return DeleteEntryResult{
+			Err: fmt.Errorf("%w: deletes only take %s", domain.ErrInvalidCondition, domain.IfVersionEquals),
+		}
+	}
+	if !found {
+		// Nothing to delete has no version to match
+		return DeleteEntryResult{Err: domain.ErrConditionFailed}
+	}
+	entry.Delete()
+	transaction := domain.TransactionFromDeleteEntry(entry)
+	transaction.AddCondition(entry.Key(), *command.Condition)
+	res := <-s.transactionManager.Execute(transaction)
+	if err := res.Err(); err != nil {
+		return DeleteEntryResult{Err: err}
+	}
+	return DeleteEntryResult{Entry: entry}
+}
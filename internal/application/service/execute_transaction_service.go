@@ -0,0 +1,167 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+type ExecuteTransactionService struct {
+	transactionManager domain.TransactionExecutionStrategy
+	snapshots          domain.SnapshotProvider
+	namespaces         domain.NamespaceRegistry
+	now                func() time.Time
+}
+
+func NewExecuteTransactionService(
+	transactionManager domain.TransactionExecutionStrategy,
+	snapshots domain.SnapshotProvider,
+	namespaces domain.NamespaceRegistry) *ExecuteTransactionService {
+	return &ExecuteTransactionService{
+		transactionManager: transactionManager,
+		snapshots:          snapshots,
+		namespaces:         namespaces,
+		now:                time.Now,
+	}
+}
+
+// TransactionPut writes Value under Key, expiring like a SaveEntryCommand
+type TransactionPut struct {
+	Key       []byte
+	Value     []byte
+	TTL       time.Duration
+	ExpiresAt time.Time
+}
+
+// entryIn builds the entry put writes to namespace, taking the namespace's
+// default TTL when put sets no expiry
+func (put TransactionPut) entryIn(namespace domain.Namespace, now time.Time) domain.DbEntry {
+	entry := domain.NewDbEntryFromBytes(put.Key, put.Value, false)
+	entry.SetNamespace(namespace.Name)
+	ttl := put.TTL
+	if ttl <= 0 {
+		ttl = namespace.DefaultTTL
+	}
+	if !put.ExpiresAt.IsZero() {
+		entry.SetExpiresAt(put.ExpiresAt.UnixNano())
+	} else if ttl > 0 {
+		entry.SetExpiresAt(now.Add(ttl).UnixNano())
+	}
+	return entry
+}
+
+// ExecuteTransactionCommand reads, writes and deletes keys of Namespace, the
+// default one when empty, as a single transaction: either every put and
+// delete is applied or none is. A key appears at most once among the puts
+// and deletes.
+type ExecuteTransactionCommand struct {
+	Namespace string
+	Reads     [][]byte
+	Puts      []TransactionPut
+	Deletes   [][]byte
+}
+
+// TransactionRead is the value a read saw, all reads seeing the same point
+// in time
+type TransactionRead struct {
+	Key   []byte
+	Entry domain.DbEntry
+	Found bool
+}
+
+// ExecuteTransactionResult tells whether the transaction committed. Reads
+// follow the order of the command. Err is set when the command was rejected
+// before running. Reason tells why a transaction that ran did not commit:
+// domain.ErrConditionFailed when a condition failed, domain.ErrTransactionAborted
+// when it lost a conflict or read a stale version.
+type ExecuteTransactionResult struct {
+	TransactionId string
+	Reads         []TransactionRead
+	Committed     bool
+	Reason        error
+	Err           error
+}
+
+func (s *ExecuteTransactionService) Execute(command ExecuteTransactionCommand) ExecuteTransactionResult {
+	namespace, found := s.namespaces.Namespace(namespaceOrDefault(command.Namespace))
+	if !found {
+		return ExecuteTransactionResult{
+			Err: fmt.Errorf("%w: %s", domain.ErrNamespaceNotFound, command.Namespace),
+		}
+	}
+	transaction, err := s.transactionFrom(command, namespace)
+	if err != nil {
+		return ExecuteTransactionResult{Err: err}
+	}
+	result := ExecuteTransactionResult{
+		TransactionId: transaction.Id,
+		Reads:         s.read(&transaction, namespace.Name, command.Reads),
+	}
+
+	res := <-s.transactionManager.Execute(transaction)
+	result.Committed = res.Success
+	result.Reason = res.Err()
+	return result
+}
+
+func (s *ExecuteTransactionService) transactionFrom(command ExecuteTransactionCommand,
+	namespace domain.Namespace) (domain.Transaction, error) {
+	if len(command.Reads) == 0 && len(command.Puts) == 0 && len(command.Deletes) == 0 {
+		return domain.Transaction{}, fmt.Errorf("%w: no reads, puts or deletes", ErrInvalidTransaction)
+	}
+	transaction := domain.NewTransaction()
+	transaction.Namespace = namespace.Name
+	written := make(map[string]bool, len(command.Puts)+len(command.Deletes))
+	claim := func(key []byte) error {
+		if written[string(key)] {
+			return fmt.Errorf("%w: key %q is written twice", ErrInvalidTransaction, key)
+		}
+		written[string(key)] = true
+		return nil
+	}
+
+	now := s.now()
+	for _, put := range command.Puts {
+		if err := claim(put.Key); err != nil {
+			return domain.Transaction{}, err
+		}
+		transaction.AddWriteEntry(put.entryIn(namespace, now))
+	}
+	for _, key := range command.Deletes {
+		if err := claim(key); err != nil {
+			return domain.Transaction{}, err
+		}
+		entry := domain.NewDbEntryFromBytes(key, nil, true)
+		entry.SetNamespace(namespace.Name)
+		transaction.AddDeleteEntry(entry)
+	}
+	return transaction, nil
+}
+
+// read looks every key up in one snapshot and adds it to the read set, found
+// or not, so concurrent writers of those keys conflict with the transaction.
+func (s *ExecuteTransactionService) read(transaction *domain.Transaction, namespace string,
+	keys [][]byte) []TransactionRead {
+	if len(keys) == 0 {
+		return nil
+	}
+	snapshot := s.snapshots.Snapshot()
+	defer snapshot.Release()
+
+	now := s.now()
+	reads := make([]TransactionRead, 0, len(keys))
+	for _, key := range keys {
+		entry, found := snapshot.Get(namespace, string(key))
+		if !found {
+			entry = domain.AbsentEntry(namespace, key)
+		}
+		// The read set keeps the version as stored, expired or not
+		transaction.AddReadEntry(entry)
+		found = found && !entry.Tombstone() && !entry.Expired(now)
+		reads = append(reads, TransactionRead{Key: key, Entry: entry, Found: found})
+	}
+	return reads
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newExecuteService(repo *memoryRepo) (*ExecuteTransactionService, *sequencedStrategy) {
+	strategy := &sequencedStrategy{repo: repo}
+	return NewExecuteTransactionService(strategy, repo, namespaces{}), strategy
+}
+
+func TestExecuteTransactionService_CommitsEveryPutAndDelete(t *testing.T) {
+	repo := newMemoryRepo()
+	repo.write("carrito:3", "lleno")
+	s, strategy := newExecuteService(repo)
+
+	result := s.Execute(ExecuteTransactionCommand{
+		Puts: []TransactionPut{
+			{Key: []byte("pedido:1"), Value: []byte("nuevo")},
+			{Key: []byte("pedido:2"), Value: []byte("nuevo"), TTL: time.Hour},
+		},
+		Deletes: [][]byte{[]byte("carrito:3")},
+	})
+
+	assert.NoError(t, result.Err)
+	assert.True(t, result.Committed)
+	assert.NoError(t, result.Reason)
+	if len(strategy.executed) != 1 {
+		t.Fatalf("se esperaba una transacción ejecutada, hubo %d", len(strategy.executed))
+	}
+	assert.Equal(t, strategy.executed[0].Id, result.TransactionId)
+	assert.Equal(t, domain.DefaultNamespace, strategy.executed[0].Namespace, "sin namespace va al por defecto")
+
+	assert.Equal(t, "nuevo", repo.value("pedido:1"))
+	assert.Equal(t, "nuevo", repo.value("pedido:2"))
+	expiring, _ := repo.Get(domain.DefaultNamespace, []byte("pedido:2"))
+	assert.NotNil(t, expiring.Deadline(), "el TTL de la escritura se respeta")
+	deleted, _ := repo.Get(domain.DefaultNamespace, []byte("carrito:3"))
+	assert.True(t, deleted.Tombstone())
+}
+
+func TestExecuteTransactionService_AbortedTransactionTellsWhy(t *testing.T) {
+	repo := newMemoryRepo()
+	s, strategy := newExecuteService(repo)
+	strategy.reject = domain.ErrStaleRead
+
+	result := s.Execute(ExecuteTransactionCommand{
+		Reads: [][]byte{[]byte("stock:7")},
+		Puts:  []TransactionPut{{Key: []byte("pedido:1"), Value: []byte("nuevo")}},
+	})
+
+	assert.NoError(t, result.Err, "la transacción corrió")
+	assert.False(t, result.Committed)
+	assert.ErrorIs(t, result.Reason, domain.ErrTransactionAborted)
+	assert.ErrorIs(t, result.Reason, domain.ErrStaleRead)
+	assert.NotEmpty(t, result.TransactionId)
+	assert.Len(t, result.Reads, 1, "devuelve lo que leyó aunque no confirme")
+	assert.Empty(t, repo.value("pedido:1"), "ninguna escritura se aplica")
+
+	strategy.reject = domain.ErrConditionFailed
+	result = s.Execute(ExecuteTransactionCommand{
+		Puts: []TransactionPut{{Key: []byte("pedido:1"), Value: []byte("nuevo")}},
+	})
+	assert.False(t, result.Committed)
+	assert.ErrorIs(t, result.Reason, domain.ErrConditionFailed, "una condición fallida se distingue de un aborto")
+}
+
+func TestExecuteTransactionService_RejectsEmptyTransaction(t *testing.T) {
+	s, strategy := newExecuteService(newMemoryRepo())
+
+	result := s.Execute(ExecuteTransactionCommand{})
+
+	assert.ErrorIs(t, result.Err, ErrInvalidTransaction)
+	assert.False(t, result.Committed)
+	assert.Empty(t, strategy.executed, "no llega a la estrategia")
+}
+
+func TestExecuteTransactionService_RejectsKeyWrittenTwice(t *testing.T) {
+	s, strategy := newExecuteService(newMemoryRepo())
+
+	result := s.Execute(ExecuteTransactionCommand{
+		Puts: []TransactionPut{
+			{Key: []byte("k"), Value: []byte("1")},
+			{Key: []byte("k"), Value: []byte("2")},
+		},
+	})
+	assert.ErrorIs(t, result.Err, ErrInvalidTransaction, "dos escrituras de la misma clave")
+
+	result = s.Execute(ExecuteTransactionCommand{
+		Puts:    []TransactionPut{{Key: []byte("k"), Value: []byte("1")}},
+		Deletes: [][]byte{[]byte("k")},
+	})
+	assert.ErrorIs(t, result.Err, ErrInvalidTransaction, "escribir y borrar la misma clave")
+
+	result = s.Execute(ExecuteTransactionCommand{
+		Reads: [][]byte{[]byte("k")},
+		Puts:  []TransactionPut{{Key: []byte("k"), Value: []byte("1")}},
+	})
+	assert.NoError(t, result.Err, "leer y escribir la misma clave vale")
+	assert.Len(t, strategy.executed, 1)
+}
+
+func TestExecuteTransactionService_RejectsUnknownNamespace(t *testing.T) {
+	s, strategy := newExecuteService(newMemoryRepo())
+
+	result := s.Execute(ExecuteTransactionCommand{Namespace: "pedidos", Reads: [][]byte{[]byte("k")}})
+
+	assert.ErrorIs(t, result.Err, domain.ErrNamespaceNotFound)
+	assert.Empty(t, strategy.executed)
+}
+
+func TestExecuteTransactionService_ReadsFromOneSnapshot(t *testing.T) {
+	repo := newMemoryRepo()
+	stock := repo.write("stock:7", "3")
+	repo.write("reserva:7", "1")
+	repo.Delete(domain.DefaultNamespace, []byte("reserva:7"))
+	s, strategy := newExecuteService(repo)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	expired := domain.NewDbEntry("oferta:7", "10%", false)
+	expired.SetNamespace(domain.DefaultNamespace)
+	expired.SetExpiresAt(now.Add(-time.Second).UnixNano())
+	repo.Save(expired)
+
+	result := s.Execute(ExecuteTransactionCommand{
+		Reads: [][]byte{[]byte("stock:7"), []byte("falta:7"), []byte("reserva:7"), []byte("oferta:7")},
+	})
+
+	assert.NoError(t, result.Err)
+	assert.True(t, result.Committed)
+	if len(result.Reads) != 4 {
+		t.Fatalf("se esperaba una lectura por clave, hubo %d", len(result.Reads))
+	}
+	assert.Equal(t, "stock:7", string(result.Reads[0].Key))
+	assert.True(t, result.Reads[0].Found)
+	assert.Equal(t, "3", result.Reads[0].Entry.Value())
+	assert.False(t, result.Reads[1].Found, "la clave que no está")
+	assert.False(t, result.Reads[2].Found, "la clave borrada")
+	assert.False(t, result.Reads[3].Found, "la clave vencida")
+
+	if len(strategy.executed) != 1 {
+		t.Fatalf("se esperaba una transacción ejecutada, hubo %d", len(strategy.executed))
+	}
+	readSet := strategy.executed[0].ReadSet
+	assert.Len(t, readSet, 4, "también se registran las claves que no encontró")
+	read, missing, stale := readSet["stock:7"], readSet["falta:7"], readSet["oferta:7"]
+	assert.Equal(t, stock.Version(), read.Version(), "con la versión leída")
+	assert.True(t, missing.Tombstone(), "la ausente se registra como tal")
+	assert.Equal(t, "10%", stale.Value(), "la vencida se registra como está guardada")
+}
+
+func TestExecuteTransactionService_ReadsIgnoreLaterWrites(t *testing.T) {
+	repo := newMemoryRepo()
+	repo.write("a", "1")
+	repo.write("b", "1")
+	snapshots := &writingSnapshots{repo: repo}
+	s := NewExecuteTransactionService(&sequencedStrategy{repo: repo}, snapshots, namespaces{})
+
+	result := s.Execute(ExecuteTransactionCommand{Reads: [][]byte{[]byte("a"), []byte("b")}})
+
+	if len(result.Reads) != 2 {
+		t.Fatalf("se esperaban dos lecturas, hubo %d", len(result.Reads))
+	}
+	assert.Equal(t, "1", result.Reads[0].Entry.Value())
+	assert.Equal(t, "1", result.Reads[1].Entry.Value(), "ve el mismo momento que la primera lectura")
+	assert.Equal(t, "2", repo.value("b"))
+	assert.True(t, snapshots.taken.released, "el snapshot se libera")
+	assert.False(t, result.Committed, "lo que leyó ya cambió al confirmar")
+	assert.ErrorIs(t, result.Reason, domain.ErrStaleRead)
+}
+
+// writingSnapshots escribe b apenas se toma el snapshot, entre las lecturas
+// de la transacción
+type writingSnapshots struct {
+	repo  *memoryRepo
+	taken *memorySnapshot
+}
+
+func (w *writingSnapshots) Snapshot() domain.DbEntrySnapshot {
+	w.taken = w.repo.Snapshot().(*memorySnapshot)
+	w.repo.write("b", "2")
+	return w.taken
+}
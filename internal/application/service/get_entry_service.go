@@ -2,20 +2,25 @@ package service
 
 import (
 	"KVDB/internal/domain"
+	"time"
 )
 
 type GetEntryService struct {
 	repository domain.DbEntryRepository
+	now        func() time.Time
 }
 
 func NewGetEntryService(repository domain.DbEntryRepository) *GetEntryService {
 	return &GetEntryService{
 		repository: repository,
+		now:        time.Now,
 	}
 }
 
+// GetEntryQuery reads Key from Namespace, the default one when empty.
 type GetEntryQuery struct {
-	Key string
+	Namespace string
+	Key       []byte
 }
 
 type GetEntryResult struct {
@@ -24,11 +29,11 @@ type GetEntryResult struct {
 }
 
 func (s *GetEntryService) Execute(query GetEntryQuery) GetEntryResult {
-	entry, found := s.repository.Get(query.Key)
+	entry, found := s.repository.Get(namespaceOrDefault(query.Namespace), query.Key)
 	if !found {
 		return GetEntryResult{Found: false}
 	}
-	if entry.Tombstone() {
+	if entry.Tombstone() || entry.Expired(s.now()) {
 		return GetEntryResult{Found: false}
 	}
 	return GetEntryResult{
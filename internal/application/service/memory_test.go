@@ -0,0 +1,142 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"sync"
+)
+
+// memoryRepo guarda la última escritura de cada clave como el almacenamiento
+// de un nodo, y sirve de él snapshots
+type memoryRepo struct {
+	mu      sync.Mutex
+	entries map[string]domain.DbEntry
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{entries: make(map[string]domain.DbEntry)}
+}
+
+func (r *memoryRepo) Get(namespace string, key []byte) (domain.DbEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, found := r.entries[namespace+"/"+string(key)]
+	return entry, found
+}
+
+func (r *memoryRepo) Save(entry domain.DbEntry) domain.DbEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[entry.Namespace()+"/"+entry.Key()] = entry
+	return entry
+}
+
+func (r *memoryRepo) Delete(namespace string, key []byte) (*domain.DbEntry, bool) {
+	entry, found := r.Get(namespace, key)
+	if !found {
+		return nil, false
+	}
+	entry.Delete()
+	r.Save(entry)
+	return &entry, true
+}
+
+// value devuelve el valor guardado de key, vacío si no está
+func (r *memoryRepo) value(key string) string {
+	entry, _ := r.Get(domain.DefaultNamespace, []byte(key))
+	return entry.Value()
+}
+
+// write guarda key como si la hubiera escrito una transacción confirmada
+func (r *memoryRepo) write(key, value string) domain.DbEntry {
+	tx := domain.TransactionFromWriteEntry(domain.NewDbEntry(key, value, false))
+	tx.Stamp(domain.Clock.Now())
+	return r.Save(tx.WriteSet[key])
+}
+
+func (r *memoryRepo) Snapshot() domain.DbEntrySnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make(map[string]domain.DbEntry, len(r.entries))
+	for key, entry := range r.entries {
+		entries[key] = entry
+	}
+	return &memorySnapshot{entries: entries}
+}
+
+// memorySnapshot es una copia de las entradas, ajena a lo que se escribe
+// después
+type memorySnapshot struct {
+	entries  map[string]domain.DbEntry
+	released bool
+}
+
+func (s *memorySnapshot) Get(namespace, key string) (domain.DbEntry, bool) {
+	entry, found := s.entries[namespace+"/"+key]
+	return entry, found
+}
+
+func (s *memorySnapshot) Scan(namespace, start, end string, reverse bool) domain.DbEntryIterator {
+	return nil
+}
+
+func (s *memorySnapshot) Prefix(namespace, prefix string, reverse bool) domain.DbEntryIterator {
+	return nil
+}
+
+func (s *memorySnapshot) Release() {
+	s.released = true
+}
+
+// namespaces solo conoce el namespace por defecto
+type namespaces struct{}
+
+func (namespaces) CreateNamespace(namespace domain.Namespace) error { return nil }
+func (namespaces) DropNamespace(name string) error                  { return nil }
+func (namespaces) Namespaces() []domain.Namespace {
+	return []domain.Namespace{{Name: domain.DefaultNamespace}}
+}
+func (namespaces) Namespace(name string) (domain.Namespace, bool) {
+	if name != domain.DefaultNamespace {
+		return domain.Namespace{}, false
+	}
+	return domain.Namespace{Name: name}, true
+}
+
+// sequencedStrategy ejecuta cada transacción como un único nodo que la recibe
+// del secuenciador: valida sus lecturas contra repo y la aplica, salvo que
+// reject esté puesto, que la rechaza con ese motivo
+type sequencedStrategy struct {
+	repo     *memoryRepo
+	reject   error
+	executed []domain.Transaction
+}
+
+func (s *sequencedStrategy) Execute(t domain.Transaction) <-chan domain.TransactionResult {
+	t.Stamp(domain.Clock.Now())
+	s.executed = append(s.executed, t)
+	ch := make(chan domain.TransactionResult, 1)
+	defer close(ch)
+
+	err := s.reject
+	if err == nil {
+		err = (&domain.ConflictFinder{Versions: s.repo}).Validate(t)
+	}
+	if err != nil {
+		ch <- domain.FromRejection(t, err)
+		return ch
+	}
+	for _, entry := range t.WriteSet {
+		s.repo.Save(entry)
+	}
+	for _, entry := range t.DeleteSet {
+		s.repo.Delete(entry.Namespace(), entry.KeyBytes())
+	}
+	result := domain.FromTransaction(t)
+	result.MarkAsSuccessful()
+	ch <- result
+	return ch
+}
+
+func (s *sequencedStrategy) AddTransaction(transaction domain.Transaction) {}
+
+func (s *sequencedStrategy) AbortTransaction(id string) {}
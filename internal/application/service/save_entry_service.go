@@ -2,36 +2,92 @@ package service
 
 import (
 	"KVDB/internal/domain"
+	"fmt"
+	"time"
 )
 
 type SaveEntryService struct {
 	transactionManager domain.TransactionExecutionStrategy
+	namespaces         domain.NamespaceRegistry
+	now                func() time.Time
 }
 
 func NewSaveEntryService(
-	transactionManager domain.TransactionExecutionStrategy) *SaveEntryService {
+	transactionManager domain.TransactionExecutionStrategy,
+	namespaces domain.NamespaceRegistry) *SaveEntryService {
 	return &SaveEntryService{
 		transactionManager: transactionManager,
+		namespaces:         namespaces,
+		now:                time.Now,
 	}
 }
 
+// SaveEntryCommand writes Value under Key in Namespace, the default one when
+// empty. The entry expires at ExpiresAt when set, otherwise after TTL when
+// positive, otherwise after the namespace's default TTL, if it has one.
+// With a Condition the write only happens if the stored entry meets it.
 type SaveEntryCommand struct {
-	Key   string
-	Value string
+	Namespace string
+	Key       []byte
+	Value     []byte
+	TTL       time.Duration
+	ExpiresAt time.Time
+	Condition *domain.Condition
 }
 
+// SaveEntryResult holds the written entry, or an Err telling why it was not
+// written: domain.ErrConditionFailed when the condition did not hold,
+// domain.ErrInvalidCondition when the strategy takes no conditions,
+// domain.ErrTransactionAborted when conflict resolution discarded the write.
 type SaveEntryResult struct {
 	Entry domain.DbEntry
+	Err   error
 }
 
 func (s *SaveEntryService) Execute(command SaveEntryCommand) SaveEntryResult {
-	entry := domain.NewDbEntry(command.Key, command.Value, false)
-	resCh := s.transactionManager.Execute(domain.TransactionFromWriteEntry(entry))
+	namespace, found := s.namespaces.Namespace(namespaceOrDefault(command.Namespace))
+	if !found {
+		return SaveEntryResult{
+			Err: fmt.Errorf("%w: %s", domain.ErrNamespaceNotFound, command.Namespace),
+		}
+	}
+	entry := domain.NewDbEntryFromBytes(command.Key, command.Value, false)
+	entry.SetNamespace(namespace.Name)
+	ttl := command.TTL
+	if ttl <= 0 {
+		ttl = namespace.DefaultTTL
+	}
+	// The deadline is fixed here, once, and replicated as is so every
+	// replica expires the entry at the same moment.
+	if !command.ExpiresAt.IsZero() {
+		entry.SetExpiresAt(command.ExpiresAt.UnixNano())
+	} else if ttl > 0 {
+		entry.SetExpiresAt(s.now().Add(ttl).UnixNano())
+	}
+	transaction := domain.TransactionFromWriteEntry(entry)
+	if command.Condition != nil {
+		if err := command.Condition.Validate(); err != nil {
+			return SaveEntryResult{Err: err}
+		}
+		transaction.AddCondition(entry.Key(), *command.Condition)
+	}
+	resCh := s.transactionManager.Execute(transaction)
 	res := <-resCh
 
-	if !res.Success {
-		return SaveEntryResult{}
+	if err := res.Err(); err != nil {
+		return SaveEntryResult{Err: err}
+	}
+	// Stamped with the version it was written with
+	if written, ok := res.WriteSet[entry.Key()]; ok {
+		entry = written
 	}
 
 	return SaveEntryResult{Entry: entry}
 }
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return domain.DefaultNamespace
+	}
+	return namespace
+}
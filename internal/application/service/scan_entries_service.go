@@ -0,0 +1,134 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+
+	cursorVersion = 1
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ScanEntriesService struct {
+	scanner domain.DbEntryScanner
+	now     func() time.Time
+}
+
+func NewScanEntriesService(scanner domain.DbEntryScanner) *ScanEntriesService {
+	return &ScanEntriesService{
+		scanner: scanner,
+		now:     time.Now,
+	}
+}
+
+// ScanEntriesQuery selects the keys of Namespace with Start <= key < End that
+// begin with Prefix. Every filter is optional. Cursor continues a previous page and must
+// come with the same filters and direction.
+type ScanEntriesQuery struct {
+	Namespace string
+	Start     string
+	End       string
+	Prefix    string
+	Reverse   bool
+	Limit     int
+	Cursor    string
+}
+
+// ScanEntriesResult holds one page of entries. Cursor is empty on the last
+// page.
+type ScanEntriesResult struct {
+	Entries []domain.DbEntry
+	Cursor  string
+	Err     error
+}
+
+func (s *ScanEntriesService) Execute(query ScanEntriesQuery) ScanEntriesResult {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	limit = min(limit, MaxScanLimit)
+
+	start, end := query.Start, query.End
+	if query.Prefix != "" {
+		prefixStart, prefixEnd := domain.PrefixRange(query.Prefix)
+		start = max(start, prefixStart)
+		if end == "" || (prefixEnd != "" && prefixEnd < end) {
+			end = prefixEnd
+		}
+	}
+	if query.Cursor != "" {
+		lastKey, err := decodeCursor(query.Cursor, query.Reverse)
+		if err != nil {
+			return ScanEntriesResult{Err: err}
+		}
+		if query.Reverse {
+			end = lastKey
+		} else {
+			// smallest key sorting after lastKey
+			start = lastKey + "\x00"
+		}
+	}
+	if end != "" && start >= end {
+		return ScanEntriesResult{}
+	}
+
+	it := s.scanner.Scan(namespaceOrDefault(query.Namespace), start, end, query.Reverse)
+	defer it.Close()
+
+	now := s.now()
+	entries := make([]domain.DbEntry, 0, limit)
+	more := false
+	for {
+		entry, ok := it.Next()
+		if !ok {
+			break
+		}
+		if entry.Expired(now) {
+			continue
+		}
+		if len(entries) == limit {
+			more = true
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err := it.Err(); err != nil {
+		return ScanEntriesResult{Err: err}
+	}
+
+	result := ScanEntriesResult{Entries: entries}
+	if more {
+		result.Cursor = encodeCursor(entries[len(entries)-1].Key(), query.Reverse)
+	}
+	return result
+}
+
+// The cursor carries the last key returned and the direction it was
+// returned in. Clients must treat it as opaque.
+func encodeCursor(lastKey string, reverse bool) string {
+	direction := byte(0)
+	if reverse {
+		direction = 1
+	}
+	buf := append([]byte{cursorVersion, direction}, lastKey...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(cursor string, reverse bool) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) < 2 || buf[0] != cursorVersion {
+		return "", ErrInvalidCursor
+	}
+	if (buf[1] == 1) != reverse {
+		return "", ErrInvalidCursor
+	}
+	return string(buf[2:]), nil
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("transaction not found")
+	ErrTooManySessions = errors.New("too many open transactions")
+)
+
+// TransactionSessionService keeps interactive transactions open between
+// requests. Reads and writes are buffered into the session's transaction,
+// which only reaches the execution strategy on Commit. Sessions left idle
+// longer than the idle timeout are discarded.
+type TransactionSessionService struct {
+	transactionManager domain.TransactionExecutionStrategy
+	repository         domain.DbEntryRepository
+	namespaces         domain.NamespaceRegistry
+	idleTimeout        time.Duration
+	maxPerClient       int
+	now                func() time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]*transactionSession
+	perClient map[string]int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	expiryWg sync.WaitGroup
+}
+
+type transactionSession struct {
+	// mu serialises the requests of a session. It is taken before the
+	// service lock, which guards the other fields.
+	mu          sync.Mutex
+	transaction domain.Transaction
+	namespace   domain.Namespace
+	client      string
+	lastUsed    time.Time
+	done        bool
+}
+
+func NewTransactionSessionService(
+	transactionManager domain.TransactionExecutionStrategy,
+	repository domain.DbEntryRepository,
+	namespaces domain.NamespaceRegistry,
+	idleTimeout time.Duration,
+	maxPerClient int) *TransactionSessionService {
+	s := &TransactionSessionService{
+		transactionManager: transactionManager,
+		repository:         repository,
+		namespaces:         namespaces,
+		idleTimeout:        idleTimeout,
+		maxPerClient:       maxPerClient,
+		now:                time.Now,
+		sessions:           make(map[string]*transactionSession),
+		perClient:          make(map[string]int),
+		stop:               make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		s.expiryWg.Add(1)
+		go s.expireIdle()
+	}
+	return s
+}
+
+// Close stops discarding idle sessions in the background. Sessions still
+// expire when used or when another one begins.
+func (s *TransactionSessionService) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.expiryWg.Wait()
+}
+
+// Begin opens a transaction on namespace, the default one when empty, for
+// client and returns its id
+func (s *TransactionSessionService) Begin(client, namespace string) (string, error) {
+	settings, found := s.namespaces.Namespace(namespaceOrDefault(namespace))
+	if !found {
+		return "", fmt.Errorf("%w: %s", domain.ErrNamespaceNotFound, namespace)
+	}
+	transaction := domain.NewTransaction()
+	transaction.Namespace = settings.Name
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discardIdle()
+	if s.maxPerClient > 0 && s.perClient[client] >= s.maxPerClient {
+		return "", fmt.Errorf("%w: %s holds %d", ErrTooManySessions, client, s.perClient[client])
+	}
+	s.sessions[transaction.Id] = &transactionSession{
+		transaction: transaction,
+		namespace:   settings,
+		client:      client,
+		lastUsed:    s.now(),
+	}
+	s.perClient[client]++
+	return transaction.Id, nil
+}
+
+// Get reads key as the transaction sees it: its own writes and deletes
+// first, then the version it read before, then the stored one. The stored
+// version goes into the read set, so the commit is rejected if anyone
+// changed the key since.
+func (s *TransactionSessionService) Get(id string, key []byte) (domain.DbEntry, bool, error) {
+	session, err := s.acquire(id)
+	if err != nil {
+		return domain.DbEntry{}, false, err
+	}
+	defer session.mu.Unlock()
+
+	transaction := &session.transaction
+	if entry, written := transaction.WriteSet[string(key)]; written {
+		return entry, true, nil
+	}
+	if _, deleted := transaction.DeleteSet[string(key)]; deleted {
+		return domain.DbEntry{}, false, nil
+	}
+	entry, read := transaction.ReadSet[string(key)]
+	if !read {
+		var found bool
+		entry, found = s.repository.Get(session.namespace.Name, key)
+		if !found {
+			// Reading a missing key is recorded too, so its creation conflicts
+			entry = domain.AbsentEntry(session.namespace.Name, key)
+		}
+		transaction.AddReadEntry(entry)
+	}
+	if entry.Tombstone() || entry.Expired(s.now()) {
+		return domain.DbEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+// Put buffers a write of put.Key, replacing any earlier write or delete of
+// it in the transaction
+func (s *TransactionSessionService) Put(id string, put TransactionPut) (domain.DbEntry, error) {
+	session, err := s.acquire(id)
+	if err != nil {
+		return domain.DbEntry{}, err
+	}
+	defer session.mu.Unlock()
+
+	entry := put.entryIn(session.namespace, s.now())
+	delete(session.transaction.DeleteSet, entry.Key())
+	session.transaction.AddWriteEntry(entry)
+	return entry, nil
+}
+
+// Delete buffers a delete of key, replacing any earlier write of it in the
+// transaction
+func (s *TransactionSessionService) Delete(id string, key []byte) error {
+	session, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer session.mu.Unlock()
+
+	entry := domain.NewDbEntryFromBytes(key, nil, true)
+	entry.SetNamespace(session.namespace.Name)
+	delete(session.transaction.WriteSet, entry.Key())
+	session.transaction.AddDeleteEntry(entry)
+	return nil
+}
+
+// Commit hands the transaction to the execution strategy and closes the
+// session, whatever the outcome. It returns nil once the transaction
+// committed, domain.ErrConditionFailed or domain.ErrTransactionAborted when
+// it did not, and ErrSessionNotFound when there is no such session.
+func (s *TransactionSessionService) Commit(id string) error {
+	session, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer session.mu.Unlock()
+	s.close(id, session)
+
+	res := <-s.transactionManager.Execute(session.transaction)
+	return res.Err()
+}
+
+// Abort discards the transaction without applying any of its writes
+func (s *TransactionSessionService) Abort(id string) error {
+	session, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer session.mu.Unlock()
+	s.close(id, session)
+	return nil
+}
+
+// acquire returns the session locked, refreshing its idle deadline
+func (s *TransactionSessionService) acquire(id string) (*transactionSession, error) {
+	s.mu.Lock()
+	session, found := s.sessions[id]
+	if found && s.idle(session) {
+		s.remove(id, session)
+		found = false
+	}
+	s.mu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	session.mu.Lock()
+	s.mu.Lock()
+	// It may have been committed, aborted or expired while waiting
+	done := session.done
+	session.lastUsed = s.now()
+	s.mu.Unlock()
+	if done {
+		session.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return session, nil
+}
+
+func (s *TransactionSessionService) close(id string, session *transactionSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id, session)
+}
+
+// remove requires s.mu held
+func (s *TransactionSessionService) remove(id string, session *transactionSession) {
+	if s.sessions[id] != session {
+		return
+	}
+	session.done = true
+	delete(s.sessions, id)
+	s.perClient[session.client]--
+	if s.perClient[session.client] == 0 {
+		delete(s.perClient, session.client)
+	}
+}
+
+// idle requires s.mu held
+func (s *TransactionSessionService) idle(session *transactionSession) bool {
+	return s.idleTimeout > 0 && s.now().Sub(session.lastUsed) > s.idleTimeout
+}
+
+// discardIdle requires s.mu held
+func (s *TransactionSessionService) discardIdle() {
+	for id, session := range s.sessions {
+		if s.idle(session) {
+			s.remove(id, session)
+		}
+	}
+}
+
+func (s *TransactionSessionService) expireIdle() {
+	defer s.expiryWg.Done()
+	ticker := time.NewTicker(s.idleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.discardIdle()
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"KVDB/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newSessionService(repo *memoryRepo, idleTimeout time.Duration, maxPerClient int) (*TransactionSessionService, *sequencedStrategy) {
+	strategy := &sequencedStrategy{repo: repo}
+	return NewTransactionSessionService(strategy, repo, namespaces{}, idleTimeout, maxPerClient), strategy
+}
+
+func TestTransactionSessionService_DiscardsIdleSessions(t *testing.T) {
+	s, _ := newSessionService(newMemoryRepo(), time.Minute, 1)
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	id, err := s.Begin("10.0.0.1", "")
+	assert.NoError(t, err)
+	now = now.Add(30 * time.Second)
+	_, err = s.Put(id, TransactionPut{Key: []byte("k"), Value: []byte("v")})
+	assert.NoError(t, err, "usarla renueva el plazo")
+
+	now = now.Add(61 * time.Second)
+	_, _, err = s.Get(id, []byte("k"))
+	assert.ErrorIs(t, err, ErrSessionNotFound, "pasado el plazo sin usarla se descarta")
+	assert.ErrorIs(t, s.Commit(id), ErrSessionNotFound)
+
+	_, err = s.Begin("10.0.0.1", "")
+	assert.NoError(t, err, "la sesión descartada deja de contar para el límite del cliente")
+}
+
+func TestTransactionSessionService_CapsSessionsPerClient(t *testing.T) {
+	s, _ := newSessionService(newMemoryRepo(), 0, 2)
+
+	first, err := s.Begin("10.0.0.1", "")
+	assert.NoError(t, err)
+	second, err := s.Begin("10.0.0.1", "")
+	assert.NoError(t, err)
+	_, err = s.Begin("10.0.0.1", "")
+	assert.ErrorIs(t, err, ErrTooManySessions, "el cliente ya tiene abiertas las que le tocan")
+
+	_, err = s.Begin("10.0.0.2", "")
+	assert.NoError(t, err, "el límite es de cada cliente")
+
+	assert.NoError(t, s.Abort(first))
+	_, err = s.Begin("10.0.0.1", "")
+	assert.NoError(t, err, "abortar libera un lugar")
+
+	_, err = s.Put(second, TransactionPut{Key: []byte("k"), Value: []byte("v")})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Commit(second))
+	_, err = s.Begin("10.0.0.1", "")
+	assert.NoError(t, err, "confirmar libera un lugar")
+}
+
+func TestTransactionSessionService_ReadsSeeTheSessionsOwnWrites(t *testing.T) {
+	repo := newMemoryRepo()
+	repo.write("k", "guardado")
+	s, _ := newSessionService(repo, 0, 0)
+
+	id, err := s.Begin("10.0.0.1", "")
+	assert.NoError(t, err)
+
+	entry, found, err := s.Get(id, []byte("k"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "guardado", entry.Value())
+
+	_, err = s.Put(id, TransactionPut{Key: []byte("k"), Value: []byte("propio")})
+	assert.NoError(t, err)
+	entry, found, err = s.Get(id, []byte("k"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "propio", entry.Value(), "lee lo que escribió antes")
+
+	assert.NoError(t, s.Delete(id, []byte("k")))
+	_, found, err = s.Get(id, []byte("k"))
+	assert.NoError(t, err)
+	assert.False(t, found, "lo que borró ya no está")
+
+	_, found, _ = s.Get(id, []byte("nueva"))
+	assert.False(t, found)
+	_, err = s.Put(id, TransactionPut{Key: []byte("nueva"), Value: []byte("v")})
+	assert.NoError(t, err)
+	entry, found, _ = s.Get(id, []byte("nueva"))
+	assert.True(t, found, "lee la clave que creó")
+	assert.Equal(t, "v", entry.Value())
+
+	_, stored := repo.Get(domain.DefaultNamespace, []byte("nueva"))
+	assert.False(t, stored, "nada se aplica antes de confirmar")
+	assert.NoError(t, s.Commit(id))
+	assert.Equal(t, "v", repo.value("nueva"))
+	deleted, _ := repo.Get(domain.DefaultNamespace, []byte("k"))
+	assert.True(t, deleted.Tombstone(), "confirmar aplica el borrado")
+}
+
+func TestTransactionSessionService_CommitRejectsAStaleRead(t *testing.T) {
+	repo := newMemoryRepo()
+	repo.write("saldo", "100")
+	s, strategy := newSessionService(repo, 0, 0)
+
+	id, err := s.Begin("10.0.0.1", "")
+	assert.NoError(t, err)
+	_, _, err = s.Get(id, []byte("saldo"))
+	assert.NoError(t, err)
+
+	repo.write("saldo", "50")
+	entry, _, err := s.Get(id, []byte("saldo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "100", entry.Value(), "vuelve a leer la versión que leyó antes")
+
+	_, err = s.Put(id, TransactionPut{Key: []byte("retiro"), Value: []byte("100")})
+	assert.NoError(t, err)
+	err = s.Commit(id)
+	assert.ErrorIs(t, err, domain.ErrTransactionAborted, "otro escribió lo que leyó")
+	assert.ErrorIs(t, err, domain.ErrStaleRead)
+	assert.Len(t, strategy.executed, 1)
+
+	assert.Empty(t, repo.value("retiro"), "la transacción rechazada no escribe nada")
+	assert.Equal(t, "50", repo.value("saldo"))
+	assert.ErrorIs(t, s.Commit(id), ErrSessionNotFound, "la sesión se cierra igual")
+}
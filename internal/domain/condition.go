@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Conditions a write can be made on
+const (
+	IfAbsent        = "absent"
+	IfValueEquals   = "value-equals"
+	IfVersionEquals = "version-equals"
+)
+
+var (
+	ErrInvalidCondition = errors.New("invalid condition")
+	// ErrConditionFailed means the stored entry did not meet the condition
+	ErrConditionFailed = errors.New("condition failed")
+	// ErrStaleRead means a key read by the transaction changed before it
+	// committed
+	ErrStaleRead = errors.New("stale read")
+	// ErrTransactionAborted means conflict resolution discarded the
+	// transaction in favour of another
+	ErrTransactionAborted = errors.New("transaction aborted")
+)
+
+// Condition is checked against the stored entry of a key when the
+// transaction writing it commits. Versions are the timestamps of the
+// transactions that wrote the entries, the same on every node.
+type Condition struct {
+	If      string
+	Value   []byte
+	Version uint64
+}
+
+func (c Condition) Validate() error {
+	switch c.If {
+	case IfAbsent, IfValueEquals, IfVersionEquals:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown condition %q", ErrInvalidCondition, c.If)
+}
+
+// Holds tells whether the stored entry of the key meets the condition at now.
+// Deleted and expired entries count as absent.
+func (c Condition) Holds(current DbEntry, found bool, now time.Time) bool {
+	live := found && !current.Tombstone() && !current.Expired(now)
+	switch c.If {
+	case IfAbsent:
+		return !live
+	case IfValueEquals:
+		return live && current.Value() == string(c.Value)
+	case IfVersionEquals:
+		return live && current.Version() == c.Version
+	}
+	return false
+}
@@ -17,7 +17,7 @@ func (c *Conflict) AddTransaction(transaction Transaction) {
 func (c *Conflict) MostRecentTransaction() *Transaction {
 	var mostRecent *Transaction
 	for _, transaction := range c.transactions {
-		if mostRecent == nil || transaction.Timestamp > mostRecent.Timestamp {
+		if mostRecent == nil || mostRecent.Before(transaction) {
 			mostRecent = &transaction
 		}
 	}
@@ -27,7 +27,7 @@ func (c *Conflict) MostRecentTransaction() *Transaction {
 func (c *Conflict) OldestTransaction() *Transaction {
 	var oldest *Transaction
 	for _, transaction := range c.transactions {
-		if oldest == nil || transaction.Timestamp < oldest.Timestamp {
+		if oldest == nil || transaction.Before(*oldest) {
 			oldest = &transaction
 		}
 	}
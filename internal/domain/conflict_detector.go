@@ -2,9 +2,21 @@ package domain
 
 type ConflictDetector interface {
 	Check(CurrentTransactions map[string]Transaction, transaction Transaction) *Conflict
+	// Validate returns ErrStaleRead if a version in the read set of
+	// transaction is no longer the stored one, and ErrConditionFailed if one
+	// of its conditions does not hold
+	Validate(transaction Transaction) error
 }
 
+// ConflictFinder finds the transactions in flight that touch the same keys.
+// Versions, when set, is where Validate looks up the stored versions.
 type ConflictFinder struct {
+	Versions DbEntryReader
+}
+
+// DbEntryReader returns the newest version of a key, deleted or not
+type DbEntryReader interface {
+	Get(namespace string, key []byte) (DbEntry, bool)
 }
 
 func (cf *ConflictFinder) Check(CurrentTransactions map[string]Transaction, transaction Transaction) *Conflict {
@@ -23,3 +35,36 @@ func (cf *ConflictFinder) Check(CurrentTransactions map[string]Transaction, tran
 	}
 	return nil
 }
+
+// Validate rejects the transaction when a key it read was written or
+// deleted after the read, or created after being read as missing, even by a
+// transaction that is no longer in flight. It then checks the conditions on
+// the keys it writes against the stored entries, as of its timestamp.
+func (cf *ConflictFinder) Validate(transaction Transaction) error {
+	if cf.Versions == nil {
+		return nil
+	}
+	namespace := transaction.NamespaceName()
+	for _, read := range transaction.ReadSet {
+		current, found := cf.Versions.Get(namespace, read.KeyBytes())
+		if found && current.Tombstone() {
+			found = false
+		}
+		if found == read.Tombstone() {
+			return ErrStaleRead
+		}
+		if found && current.Version() != read.Version() {
+			return ErrStaleRead
+		}
+	}
+	// Every node judges expiry at the same instant, the one the transaction
+	// was stamped with, so all of them reach the same verdict
+	now := WallTime(transaction.Timestamp)
+	for key, condition := range transaction.Conditions {
+		current, found := cf.Versions.Get(namespace, []byte(key))
+		if !condition.Holds(current, found, now) {
+			return ErrConditionFailed
+		}
+	}
+	return nil
+}
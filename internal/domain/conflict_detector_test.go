@@ -3,6 +3,7 @@ package domain
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestConflictFinder_NoConflictWithDifferentKeys(t *testing.T) {
@@ -52,3 +53,204 @@ func TestConflictFinder_NoConflictWithSelf(t *testing.T) {
 	conflict := cf.Check(current, tx)
 	assert.Nil(t, conflict, "No debe haber conflicto consigo misma")
 }
+
+// versionedStore estampa una versión nueva en cada escritura, como la
+// transacción que la escribe
+type versionedStore struct {
+	entries map[string]DbEntry
+	version uint64
+}
+
+func newVersionedStore() *versionedStore {
+	return &versionedStore{entries: make(map[string]DbEntry)}
+}
+
+func (s *versionedStore) Get(namespace string, key []byte) (DbEntry, bool) {
+	entry, found := s.entries[string(key)]
+	return entry, found
+}
+
+func (s *versionedStore) write(key, value string) {
+	s.version++
+	entry := NewDbEntry(key, value, false)
+	entry.SetVersion(s.version)
+	s.entries[key] = entry
+}
+
+func (s *versionedStore) commit(tx Transaction) {
+	for key, entry := range tx.WriteSet {
+		s.write(key, entry.Value())
+	}
+}
+
+// read agrega al read set la versión leída, o su ausencia
+func (s *versionedStore) read(tx *Transaction, key string) {
+	entry, found := s.Get(DefaultNamespace, []byte(key))
+	if !found {
+		entry = AbsentEntry(DefaultNamespace, []byte(key))
+	}
+	tx.AddReadEntry(entry)
+}
+
+func TestConflictFinder_PreventsLostUpdate(t *testing.T) {
+	store := newVersionedStore()
+	store.write("saldo", "100")
+	cf := &ConflictFinder{Versions: store}
+
+	// Ambas leen el saldo y escriben saldo + 10
+	tx1 := NewTransaction()
+	tx2 := NewTransaction()
+	store.read(&tx1, "saldo")
+	store.read(&tx2, "saldo")
+	tx1.AddWriteEntry(NewDbEntry("saldo", "110", false))
+	tx2.AddWriteEntry(NewDbEntry("saldo", "110", false))
+
+	assert.NoError(t, cf.Validate(tx2))
+	store.commit(tx2)
+
+	// tx2 ya no está en vuelo: el chequeo por claves no la ve
+	assert.Nil(t, cf.Check(map[string]Transaction{tx1.Id: tx1}, tx1))
+	assert.ErrorIs(t, cf.Validate(tx1), ErrStaleRead, "tx1 leyó una versión que tx2 ya reemplazó")
+}
+
+func TestConflictFinder_PreventsWriteSkew(t *testing.T) {
+	store := newVersionedStore()
+	store.write("guardia:ana", "on")
+	store.write("guardia:bob", "on")
+	cf := &ConflictFinder{Versions: store}
+
+	// Cada una lee a los dos y se retira si el otro sigue de guardia
+	tx1 := NewTransaction()
+	tx2 := NewTransaction()
+	for _, tx := range []*Transaction{&tx1, &tx2} {
+		store.read(tx, "guardia:ana")
+		store.read(tx, "guardia:bob")
+	}
+	tx1.AddWriteEntry(NewDbEntry("guardia:ana", "off", false))
+	tx2.AddWriteEntry(NewDbEntry("guardia:bob", "off", false))
+
+	// En vuelo a la vez, el solapamiento de lecturas y escrituras ya alcanza
+	assert.NotNil(t, cf.Check(map[string]Transaction{tx1.Id: tx1}, tx2))
+
+	assert.NoError(t, cf.Validate(tx1))
+	store.commit(tx1)
+	assert.Nil(t, cf.Check(map[string]Transaction{}, tx2))
+	assert.ErrorIs(t, cf.Validate(tx2), ErrStaleRead, "tx2 decidió con una lectura de ana que ya no vale")
+}
+
+func TestConflictFinder_RejectsKeyCreatedAfterReadingItMissing(t *testing.T) {
+	store := newVersionedStore()
+	cf := &ConflictFinder{Versions: store}
+
+	tx := NewTransaction()
+	store.read(&tx, "usuario:nuevo")
+	tx.AddWriteEntry(NewDbEntry("usuario:nuevo", "ana", false))
+	assert.NoError(t, cf.Validate(tx))
+
+	store.write("usuario:nuevo", "bob")
+	assert.ErrorIs(t, cf.Validate(tx), ErrStaleRead, "la clave leída como ausente fue creada")
+}
+
+func TestConflictFinder_AcceptsCurrentReads(t *testing.T) {
+	store := newVersionedStore()
+	store.write("k", "v")
+	store.write("otra", "v")
+
+	tx := NewTransaction()
+	store.read(&tx, "k")
+	tx.AddWriteEntry(NewDbEntry("k", "v2", false))
+	store.write("otra", "v2")
+
+	assert.NoError(t, (&ConflictFinder{Versions: store}).Validate(tx), "escribir claves no leídas no invalida")
+	store.write("k", "v3")
+	assert.NoError(t, (&ConflictFinder{}).Validate(tx), "sin versiones no se valida nada")
+}
+
+func conditional(key, value string, condition Condition) Transaction {
+	tx := TransactionFromWriteEntry(NewDbEntry(key, value, false))
+	tx.AddCondition(key, condition)
+	return tx
+}
+
+func TestConflictFinder_ChecksConditions(t *testing.T) {
+	store := newVersionedStore()
+	cf := &ConflictFinder{Versions: store}
+
+	ifAbsent := conditional("lease", "nodo-1", Condition{If: IfAbsent})
+	assert.NoError(t, cf.Validate(ifAbsent))
+	store.commit(ifAbsent)
+	assert.ErrorIs(t, cf.Validate(conditional("lease", "nodo-2", Condition{If: IfAbsent})), ErrConditionFailed)
+
+	ifValue := conditional("lease", "nodo-2", Condition{If: IfValueEquals, Value: []byte("nodo-1")})
+	assert.NoError(t, cf.Validate(ifValue))
+	assert.ErrorIs(t, cf.Validate(conditional("lease", "x", Condition{If: IfValueEquals, Value: []byte("nodo-3")})), ErrConditionFailed)
+
+	stored := store.entries["lease"]
+	version := stored.Version()
+	assert.NoError(t, cf.Validate(conditional("lease", "x", Condition{If: IfVersionEquals, Version: version})))
+	store.write("lease", "nodo-1")
+	assert.ErrorIs(t, cf.Validate(conditional("lease", "x", Condition{If: IfVersionEquals, Version: version})), ErrConditionFailed,
+		"el mismo valor con otra versión no cumple")
+}
+
+func TestCondition_ExpiredEntriesAreAbsent(t *testing.T) {
+	now := time.Now()
+	lease := NewDbEntry("lease", "nodo-1", false)
+	lease.SetVersion(1)
+	lease.SetExpiresAt(now.Add(-time.Second).UnixNano())
+
+	assert.True(t, Condition{If: IfAbsent}.Holds(lease, true, now), "un lease vencido se puede tomar")
+	assert.False(t, Condition{If: IfVersionEquals, Version: 1}.Holds(lease, true, now))
+	assert.False(t, Condition{If: IfAbsent}.Holds(lease, true, now.Add(-time.Minute)))
+	assert.ErrorIs(t, Condition{If: "if-newer"}.Validate(), ErrInvalidCondition)
+}
+
+func TestConflictFinder_ConcurrentConditionalWritesConflict(t *testing.T) {
+	// Dos nodos ven la clave ausente a la vez: el solapamiento de escrituras
+	// hace que la resolución deje ganar a uno solo
+	cf := &ConflictFinder{Versions: newVersionedStore()}
+	tx1 := conditional("lease", "nodo-1", Condition{If: IfAbsent})
+	tx2 := conditional("lease", "nodo-2", Condition{If: IfAbsent})
+	assert.NoError(t, cf.Validate(tx1))
+	assert.NoError(t, cf.Validate(tx2))
+
+	conflict := cf.Check(map[string]Transaction{tx1.Id: tx1}, tx2)
+	assert.NotNil(t, conflict)
+	resolution := (&FWWConflictResolver{}).Resolve(*conflict)
+	assert.Len(t, resolution.CommitingTransactions, 1)
+}
+
+func TestTransactionResult_TellsConditionFromAbort(t *testing.T) {
+	result := FromTransaction(NewTransaction())
+	assert.ErrorIs(t, result.Err(), ErrTransactionAborted)
+	result.ConditionFailed = true
+	assert.ErrorIs(t, result.Err(), ErrConditionFailed)
+	result.MarkAsSuccessful()
+	assert.NoError(t, result.Err())
+}
+
+func TestTransactionResult_WrapsWhyItWasRejected(t *testing.T) {
+	stale := FromRejection(NewTransaction(), ErrStaleRead)
+	assert.ErrorIs(t, stale.Err(), ErrTransactionAborted)
+	assert.ErrorIs(t, stale.Err(), ErrStaleRead)
+
+	invalid := FromRejection(NewTransaction(), ErrInvalidCondition)
+	assert.ErrorIs(t, invalid.Err(), ErrInvalidCondition, "el cliente pidió algo que no se puede cumplir")
+	assert.False(t, invalid.ConditionFailed)
+}
+
+func TestConflictFinder_JudgesExpiryAtTheTransactionTimestamp(t *testing.T) {
+	store := newVersionedStore()
+	cf := &ConflictFinder{Versions: store}
+	store.write("lease", "nodo-1")
+	lease := store.entries["lease"]
+	deadline := time.Now().Add(time.Hour)
+	lease.SetExpiresAt(deadline.UnixNano())
+	store.entries["lease"] = lease
+
+	before := conditional("lease", "nodo-2", Condition{If: IfAbsent})
+	assert.ErrorIs(t, cf.Validate(before), ErrConditionFailed)
+	after := conditional("lease", "nodo-2", Condition{If: IfAbsent})
+	after.Timestamp = deadline.Add(time.Second).UnixNano()
+	assert.NoError(t, cf.Validate(after), "vale el instante de la transacción, no el reloj del nodo")
+}
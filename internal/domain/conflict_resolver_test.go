@@ -33,3 +33,40 @@ func TestLWWConflictResolver_Resolve(t *testing.T) {
 	assert.Len(t, resolution.AbortingTransactions, 1)
 	assert.Contains(t, resolution.AbortingTransactions, txOld.Id)
 }
+
+type fixedNamespaces map[string]Namespace
+
+func (n fixedNamespaces) CreateNamespace(namespace Namespace) error { return nil }
+func (n fixedNamespaces) DropNamespace(name string) error           { return nil }
+func (n fixedNamespaces) Namespaces() []Namespace                   { return nil }
+func (n fixedNamespaces) Namespace(name string) (Namespace, bool) {
+	namespace, found := n[name]
+	return namespace, found
+}
+
+func TestNamespaceConflictResolver_UsesTheNamespaceResolver(t *testing.T) {
+	resolver := &NamespaceConflictResolver{
+		Default:    &LWWConflictResolver{},
+		Namespaces: fixedNamespaces{"primero": {Name: "primero", ConflictResolver: FirstWriterWins}},
+	}
+	conflictIn := func(namespace string) (*Conflict, Transaction, Transaction) {
+		entry := NewDbEntry("k", "v", false)
+		entry.SetNamespace(namespace)
+		txOld := TransactionFromWriteEntry(entry)
+		txOld.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+		txNew := TransactionFromWriteEntry(entry)
+		txNew.Timestamp = time.Now().UnixNano()
+		conflict := NewConflict()
+		conflict.AddTransaction(txOld)
+		conflict.AddTransaction(txNew)
+		return conflict, txOld, txNew
+	}
+
+	conflict, txOld, _ := conflictIn("primero")
+	resolution := resolver.Resolve(*conflict)
+	assert.Contains(t, resolution.CommitingTransactions, txOld.Id, "el espacio pide que gane la primera")
+
+	conflict, _, txNew := conflictIn(DefaultNamespace)
+	resolution = resolver.Resolve(*conflict)
+	assert.Contains(t, resolution.CommitingTransactions, txNew.Id, "sin ajuste se usa el resolvedor por defecto")
+}
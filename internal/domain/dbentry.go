@@ -1,9 +1,31 @@
 package domain
 
+import "time"
+
+// DbEntry keys and values are arbitrary bytes. They are kept in strings,
+// which Go treats as immutable byte sequences, so entries can be shared
+// without copying; nothing assumes they hold UTF-8.
 type DbEntry struct {
 	key       string `json:"key,omitempty"`
 	value     string `json:"value,omitempty"`
 	tombstone bool   `json:"tombstone,omitempty"`
+	// seq orders the versions of a key; it is stamped by the storage engine
+	// when the entry is written. Zero means not stamped.
+	seq uint64
+	// version is the timestamp of the transaction that wrote the entry. It
+	// is replicated with the entry, so unlike seq it is the same on every
+	// node, and the storage engine never changes it. Zero means unversioned.
+	version uint64
+	// expiresAt is the deadline, in Unix nanoseconds, after which the entry
+	// reads as deleted. It is absolute so every replica expires it at once.
+	// Zero means it never expires.
+	expiresAt int64
+	// external entries hold, in place of their value, a pointer to where the
+	// storage engine keeps it.
+	external bool
+	// namespace is the keyspace the entry belongs to, empty for the default
+	// one.
+	namespace string
 }
 
 func NewDbEntry(key, value string, tombstone bool) DbEntry {
@@ -14,11 +36,29 @@ func NewDbEntry(key, value string, tombstone bool) DbEntry {
 	}
 }
 
+// AbsentEntry records that key was missing from namespace when read: a
+// tombstone with no version.
+func AbsentEntry(namespace string, key []byte) DbEntry {
+	entry := NewDbEntryFromBytes(key, nil, true)
+	entry.SetNamespace(namespace)
+	return entry
+}
+
+// NewDbEntryFromBytes copies key and value, the caller may reuse them.
+func NewDbEntryFromBytes(key, value []byte, tombstone bool) DbEntry {
+	return NewDbEntry(string(key), string(value), tombstone)
+}
+
 func (entry *DbEntry) Copy() DbEntry {
 	return DbEntry{
 		key:       entry.key,
 		value:     entry.value,
 		tombstone: entry.tombstone,
+		seq:       entry.seq,
+		version:   entry.version,
+		expiresAt: entry.expiresAt,
+		external:  entry.external,
+		namespace: entry.namespace,
 	}
 }
 
@@ -30,16 +70,132 @@ func (entry *DbEntry) Value() string {
 	return entry.value
 }
 
+func (entry *DbEntry) KeyBytes() []byte {
+	return []byte(entry.key)
+}
+
+func (entry *DbEntry) ValueBytes() []byte {
+	return []byte(entry.value)
+}
+
+// Namespace returns the namespace the entry belongs to.
+func (entry *DbEntry) Namespace() string {
+	if entry.namespace == "" {
+		return DefaultNamespace
+	}
+	return entry.namespace
+}
+
+// SetNamespace moves the entry to namespace, an empty name meaning the
+// default one.
+func (entry *DbEntry) SetNamespace(namespace string) {
+	if namespace == DefaultNamespace {
+		namespace = ""
+	}
+	entry.namespace = namespace
+}
+
 func (entry *DbEntry) Tombstone() bool {
 	return entry.tombstone
 }
 
+func (entry *DbEntry) Seq() uint64 {
+	return entry.seq
+}
+
+func (entry *DbEntry) SetSeq(seq uint64) {
+	entry.seq = seq
+}
+
+func (entry *DbEntry) Version() uint64 {
+	return entry.version
+}
+
+func (entry *DbEntry) SetVersion(version uint64) {
+	entry.version = version
+}
+
+func (entry *DbEntry) ExpiresAt() int64 {
+	return entry.expiresAt
+}
+
+func (entry *DbEntry) SetExpiresAt(deadline int64) {
+	entry.expiresAt = deadline
+}
+
+func (entry *DbEntry) External() bool {
+	return entry.external
+}
+
+// SetValue replaces the value, which is external when it is a pointer to
+// where the storage engine keeps the actual one.
+func (entry *DbEntry) SetValue(value string, external bool) {
+	entry.value = value
+	entry.external = external
+}
+
+// Deadline returns the expiry as a time, or nil when the entry never expires.
+func (entry *DbEntry) Deadline() *time.Time {
+	if entry.expiresAt == 0 {
+		return nil
+	}
+	deadline := time.Unix(0, entry.expiresAt).UTC()
+	return &deadline
+}
+
+// Expired tells whether the entry's deadline has passed at now.
+func (entry *DbEntry) Expired(now time.Time) bool {
+	return entry.expiresAt != 0 && now.UnixNano() >= entry.expiresAt
+}
+
 func (entry *DbEntry) Delete() {
 	entry.tombstone = true
+	entry.expiresAt = 0
 }
 
 type DbEntryRepository interface {
 	Save(entry DbEntry) DbEntry
-	Delete(key string) (*DbEntry, bool)
-	Get(key string) (DbEntry, bool)
+	Delete(namespace string, key []byte) (*DbEntry, bool)
+	Get(namespace string, key []byte) (DbEntry, bool)
+}
+
+// DbEntryIterator walks entries in key order, skipping deleted ones. Close
+// must be called once the caller is done with it.
+type DbEntryIterator interface {
+	Next() (DbEntry, bool)
+	Err() error
+	Close() error
+}
+
+// DbEntryScanner lists the entries of a namespace by key. Start is inclusive
+// and end exclusive; an empty bound leaves that side of the range open.
+type DbEntryScanner interface {
+	Scan(namespace, start, end string, reverse bool) DbEntryIterator
+	Prefix(namespace, prefix string, reverse bool) DbEntryIterator
+}
+
+// DbEntrySnapshot reads the entries as they were when it was taken, however
+// many writes follow. Release must be called once done so the storage can
+// discard the versions kept for it.
+type DbEntrySnapshot interface {
+	DbEntryScanner
+	Get(namespace, key string) (DbEntry, bool)
+	Release()
+}
+
+type SnapshotProvider interface {
+	Snapshot() DbEntrySnapshot
+}
+
+// PrefixRange returns the key range holding exactly the keys that start with
+// prefix. End is empty when no key sorts after every such key.
+func PrefixRange(prefix string) (start, end string) {
+	upper := []byte(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return prefix, string(upper[:i+1])
+		}
+	}
+	return prefix, ""
 }
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"sync"
+	"time"
+)
+
+// The low bits of an HLC timestamp hold its logical counter and the rest the
+// wall clock in Unix nanoseconds, so timestamps compare as plain int64s and
+// stay close to the physical time
+const (
+	logicalBits = 16
+	logicalMask = 1<<logicalBits - 1
+)
+
+// Clock stamps the transactions and acks of this node
+var Clock = NewHybridLogicalClock(time.Now)
+
+// HybridLogicalClock never goes backwards and is always ahead of every
+// timestamp it has observed, so an event caused by another is stamped after
+// it whatever the skew between the clocks of their nodes.
+type HybridLogicalClock struct {
+	physical func() time.Time
+	mu       sync.Mutex
+	last     int64
+}
+
+func NewHybridLogicalClock(physical func() time.Time) *HybridLogicalClock {
+	return &HybridLogicalClock{physical: physical}
+}
+
+// Now returns a timestamp for a local or send event
+func (c *HybridLogicalClock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.wall(), c.last+1)
+	return c.last
+}
+
+// Observe moves the clock past a timestamp received from another node and
+// returns the timestamp of the receive event
+func (c *HybridLogicalClock) Observe(remote int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.wall(), c.last+1, remote+1)
+	return c.last
+}
+
+func (c *HybridLogicalClock) wall() int64 {
+	return c.physical().UnixNano() &^ logicalMask
+}
+
+// WallTime is the physical part of an HLC timestamp
+func WallTime(timestamp int64) time.Time {
+	return time.Unix(0, timestamp&^logicalMask)
+}
+
+// Logical is the counter of an HLC timestamp, telling apart events within the
+// same wall time
+func Logical(timestamp int64) int64 {
+	return timestamp & logicalMask
+}
@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// skewedClock es el reloj físico de un nodo, adelantado o atrasado a mano
+type skewedClock struct {
+	now time.Time
+}
+
+func (c *skewedClock) Now() time.Time {
+	return c.now
+}
+
+func TestHybridLogicalClock_NeverGoesBackwards(t *testing.T) {
+	physical := &skewedClock{now: time.Unix(1_700_000_000, 0)}
+	clock := NewHybridLogicalClock(physical.Now)
+
+	first := clock.Now()
+	second := clock.Now()
+	physical.now = physical.now.Add(-time.Second) // NTP atrasa el reloj
+	third := clock.Now()
+
+	assert.Less(t, first, second)
+	assert.Less(t, second, third)
+	assert.Equal(t, WallTime(first), WallTime(third), "el tiempo físico no retrocede")
+	assert.Equal(t, int64(2), Logical(third))
+}
+
+func TestHybridLogicalClock_ObservingTheFutureAndCatchingUp(t *testing.T) {
+	physical := &skewedClock{now: time.Unix(1_700_000_000, 0)}
+	clock := NewHybridLogicalClock(physical.Now)
+	remote := NewHybridLogicalClock((&skewedClock{now: physical.now.Add(time.Minute)}).Now).Now()
+
+	received := clock.Observe(remote)
+	assert.Greater(t, received, remote)
+	assert.Equal(t, WallTime(remote), WallTime(received))
+	assert.Greater(t, clock.Now(), received)
+
+	physical.now = physical.now.Add(2 * time.Minute)
+	caughtUp := clock.Now()
+	assert.Equal(t, physical.now.UnixNano()&^logicalMask, WallTime(caughtUp).UnixNano())
+	assert.Equal(t, int64(0), Logical(caughtUp), "el contador vuelve a cero al avanzar el reloj físico")
+}
+
+func TestHybridLogicalClock_CausalOrderDespiteSkew(t *testing.T) {
+	// El nodo 1 va un minuto adelantado respecto del nodo 2
+	start := time.Unix(1_700_000_000, 0)
+	node1 := NewHybridLogicalClock((&skewedClock{now: start.Add(time.Minute)}).Now)
+	node2 := NewHybridLogicalClock((&skewedClock{now: start}).Now)
+
+	first := TransactionFromWriteEntry(NewDbEntry("k", "nodo-1", false))
+	first.InstanceId, first.Timestamp = 1, node1.Now()
+
+	// El nodo 2 escribe después de recibir la transacción del nodo 1
+	node2.Observe(first.Timestamp)
+	second := TransactionFromWriteEntry(NewDbEntry("k", "nodo-2", false))
+	second.InstanceId, second.Timestamp = 2, node2.Now()
+
+	conflict := NewConflict()
+	conflict.AddTransaction(first)
+	conflict.AddTransaction(second)
+
+	lww := (&LWWConflictResolver{}).Resolve(*conflict)
+	assert.Contains(t, lww.CommitingTransactions, second.Id, "gana la escritura posterior aunque su reloj vaya atrasado")
+	fww := (&FWWConflictResolver{}).Resolve(*conflict)
+	assert.Contains(t, fww.CommitingTransactions, first.Id)
+}
+
+func TestConflict_TiesAreBrokenByInstanceId(t *testing.T) {
+	timestamp := Clock.Now()
+	for i := 0; i < 20; i++ {
+		conflict := NewConflict()
+		txs := make(map[uint64]Transaction)
+		for _, instance := range []uint64{3, 1, 2} {
+			tx := TransactionFromWriteEntry(NewDbEntry("k", "v", false))
+			tx.InstanceId, tx.Timestamp = instance, timestamp
+			conflict.AddTransaction(tx)
+			txs[instance] = tx
+		}
+
+		assert.Equal(t, txs[3].Id, conflict.MostRecentTransaction().Id, "todos los nodos eligen igual")
+		assert.Equal(t, txs[1].Id, conflict.OldestTransaction().Id)
+	}
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultNamespace holds the entries written without naming one. It always
+// exists and cannot be dropped.
+const DefaultNamespace = "default"
+
+// Conflict resolvers a namespace can be configured with
+const (
+	LastWriterWins  = "lww"
+	FirstWriterWins = "fww"
+)
+
+const maxNamespaceName = 64
+
+var (
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceExists   = errors.New("namespace already exists")
+	ErrInvalidNamespace  = errors.New("invalid namespace")
+)
+
+// Namespace is an independent keyspace with its own settings. Its entries
+// are stored apart from the other namespaces, so dropping it never touches
+// them. Empty settings take the node defaults.
+type Namespace struct {
+	Name string `json:"name"`
+	// DefaultTTL applies to the writes that set no expiry of their own
+	DefaultTTL time.Duration `json:"default_ttl,omitempty"`
+	// Compression is the codec for the namespace's tables, "none" or "lz4"
+	Compression string `json:"compression,omitempty"`
+	// ConflictResolver picks the winner of conflicting transactions, "lww"
+	// or "fww". Under atomic broadcast the sequencer order picks it instead.
+	ConflictResolver string `json:"conflict_resolver,omitempty"`
+}
+
+// Validate checks the name and the settings the domain knows about. Names
+// are made of letters, digits, '-' and '_', so they are safe in paths and
+// URLs.
+func (n Namespace) Validate() error {
+	if n.Name == "" || len(n.Name) > maxNamespaceName {
+		return fmt.Errorf("%w: name must have between 1 and %d characters", ErrInvalidNamespace, maxNamespaceName)
+	}
+	for _, c := range n.Name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: name %q has character %q", ErrInvalidNamespace, n.Name, c)
+		}
+	}
+	if n.DefaultTTL < 0 {
+		return fmt.Errorf("%w: negative default ttl", ErrInvalidNamespace)
+	}
+	switch n.ConflictResolver {
+	case "", LastWriterWins, FirstWriterWins:
+	default:
+		return fmt.Errorf("%w: unknown conflict resolver %q", ErrInvalidNamespace, n.ConflictResolver)
+	}
+	return nil
+}
+
+type NamespaceRegistry interface {
+	CreateNamespace(namespace Namespace) error
+	// DropNamespace deletes the namespace together with its entries
+	DropNamespace(name string) error
+	Namespaces() []Namespace
+	Namespace(name string) (Namespace, bool)
+}
+
+// NamespaceConflictResolver resolves a conflict with the resolver its
+// namespace is configured with, or with Default when it names none.
+// Transactions of different namespaces never conflict, so every transaction
+// of a conflict shares the namespace.
+type NamespaceConflictResolver struct {
+	Default    ConflictResolver
+	Namespaces NamespaceRegistry
+}
+
+func (r *NamespaceConflictResolver) Resolve(conflict Conflict) ConflictResolution {
+	var namespace Namespace
+	for _, transaction := range conflict.Transactions() {
+		if r.Namespaces != nil {
+			namespace, _ = r.Namespaces.Namespace(transaction.NamespaceName())
+		}
+		break
+	}
+	switch namespace.ConflictResolver {
+	case LastWriterWins:
+		return (&LWWConflictResolver{}).Resolve(conflict)
+	case FirstWriterWins:
+		return (&FWWConflictResolver{}).Resolve(conflict)
+	}
+	return r.Default.Resolve(conflict)
+}
@@ -6,25 +6,32 @@ import (
 	"sync"
 )
 
+// AtomicTransactionManager hands every transaction to the sequencer, which
+// delivers it to every node, this one included, in the same order. Each
+// node validates and applies the transactions in that order, so all of them
+// reach the same verdict and conflicts need no resolution: of two
+// transactions that read what the other writes, the one delivered later
+// reads a stale version and is rejected everywhere.
 type AtomicTransactionManager struct {
-	subscribers         sync.Map // map[string]chan domain.TransactionResult
-	currentTransactions sync.Map // map[string]domain.Transaction
-	results             sync.Map // map[string]domain.TransactionResult
+	subscribers sync.Map // map[string]chan domain.TransactionResult
 
 	currentInstance *domain.DbInstance
 
 	conflictFinder         domain.ConflictDetector
-	resolver               domain.ConflictResolver
 	repository             domain.DbEntryRepository
 	transactionBroadcaster domain.TransactionBroadcaster
+	clock                  *domain.HybridLogicalClock
+	// mu keeps deliveries from interleaving, so they apply in sequencer order
+	mu sync.Mutex
 }
 
-func NewAtomicTransactionManager(im *domain.DbInstanceManager, repo domain.DbEntryRepository, tb domain.TransactionBroadcaster) *AtomicTransactionManager {
+func NewAtomicTransactionManager(im *domain.DbInstanceManager, repo domain.DbEntryRepository,
+	tb domain.TransactionBroadcaster) *AtomicTransactionManager {
 	a := &AtomicTransactionManager{
-		conflictFinder:         &domain.ConflictFinder{},
-		resolver:               &domain.FWWConflictResolver{},
+		conflictFinder:         &domain.ConflictFinder{Versions: repo},
 		repository:             repo,
 		transactionBroadcaster: tb,
+		clock:                  domain.Clock,
 	}
 	go a.subscribeToCurrentInstance(im)
 	return a
@@ -38,39 +45,20 @@ func (a *AtomicTransactionManager) subscribeToCurrentInstance(im *domain.DbInsta
 	}
 }
 
+// Execute answers once the sequencer delivers the transaction back to this
+// node, which is when it commits or is rejected.
 func (a *AtomicTransactionManager) Execute(t domain.Transaction) <-chan domain.TransactionResult {
 	t.InstanceId = a.currentInstance.Id
+	t.Stamp(a.clock.Now())
 
 	ch := make(chan domain.TransactionResult, 1)
-	a.currentTransactions.Store(t.Id, t)
 	a.subscribers.Store(t.Id, ch)
 
-	// Copiamos transacciones actuales en un map para conflicto
-	txs := make(map[string]domain.Transaction)
-	a.currentTransactions.Range(func(key, value any) bool {
-		txs[key.(string)] = value.(domain.Transaction)
-		return true
-	})
-	conflict := a.conflictFinder.Check(txs, t)
-
-	if conflict != nil {
-		if _, found := conflict.Transactions()[t.Id]; !found {
-			resolution := a.resolver.Resolve(*conflict)
-			if _, aborting := resolution.AbortingTransactions[t.Id]; aborting {
-				a.AbortTransaction(t.Id)
-				return ch
-			}
-		}
-	}
-
-	result := a.execute(t)
-	a.results.Store(t.Id, result)
-
 	if err := a.transactionBroadcaster.BroadcastTransaction(t); err != nil {
+		a.subscribers.Delete(t.Id)
 		ch <- domain.FromTransaction(t)
-		return ch
+		close(ch)
 	}
-
 	return ch
 }
 
@@ -79,76 +67,38 @@ func (a *AtomicTransactionManager) execute(transaction domain.Transaction) domai
 		a.repository.Save(entry)
 	}
 	for _, entry := range transaction.DeleteSet {
-		a.repository.Delete(entry.Key())
+		a.repository.Delete(entry.Namespace(), entry.KeyBytes())
 	}
 	result := domain.FromTransaction(transaction)
 	result.MarkAsSuccessful()
 	return result
 }
 
+// AddTransaction applies a transaction delivered by the sequencer, unless
+// its reads or conditions no longer hold after the ones delivered before.
 func (a *AtomicTransactionManager) AddTransaction(t domain.Transaction) {
-	if t.InstanceId == a.currentInstance.Id {
-		if sub, ok := a.subscribers.Load(t.Id); ok {
-			if res, ok := a.results.Load(t.Id); ok {
-				sub.(chan domain.TransactionResult) <- res.(domain.TransactionResult)
-			}
-			a.cleanTransaction(t)
-		}
-		return
+	a.clock.Observe(t.Timestamp)
+	a.mu.Lock()
+	var result domain.TransactionResult
+	if err := a.conflictFinder.Validate(t); err != nil {
+		result = domain.FromRejection(t, err)
+	} else {
+		result = a.execute(t)
 	}
+	a.mu.Unlock()
 
-	a.currentTransactions.Store(t.Id, t)
-
-	// Chequeamos conflicto con snapshot del mapa
-	txs := make(map[string]domain.Transaction)
-	a.currentTransactions.Range(func(key, value any) bool {
-		txs[key.(string)] = value.(domain.Transaction)
-		return true
-	})
-	conflict := a.conflictFinder.Check(txs, t)
-
-	if conflict != nil {
-		if _, found := conflict.Transactions()[t.Id]; !found {
-			resolution := a.resolver.Resolve(*conflict)
-			if _, aborting := resolution.AbortingTransactions[t.Id]; aborting {
-				a.AbortTransaction(t.Id)
-				return
-			}
-			for id := range resolution.AbortingTransactions {
-				a.AbortTransaction(id)
-			}
-		}
-	}
-
-	a.execute(t)
-	a.cleanTransaction(t)
-}
-
-func (a *AtomicTransactionManager) cleanTransaction(t domain.Transaction) {
-	if t.InstanceId == a.currentInstance.Id {
-		if sub, ok := a.subscribers.LoadAndDelete(t.Id); ok {
-			close(sub.(chan domain.TransactionResult))
-		}
+	if sub, ok := a.subscribers.LoadAndDelete(t.Id); ok {
+		sub.(chan domain.TransactionResult) <- result
+		close(sub.(chan domain.TransactionResult))
 	}
-	a.currentTransactions.Delete(t.Id)
-	a.results.Delete(t.Id)
 }
 
 func (a *AtomicTransactionManager) AbortTransaction(id string) {
-	if val, ok := a.currentTransactions.Load(id); ok {
-		t := val.(domain.Transaction)
-		if t.InstanceId == a.currentInstance.Id {
-			if sub, ok := a.subscribers.Load(id); ok {
-				sub.(chan domain.TransactionResult) <- domain.TransactionResult{
-					TransactionId: id,
-					Success:       false,
-				}
-			}
-		}
-	}
 	if sub, ok := a.subscribers.LoadAndDelete(id); ok {
+		sub.(chan domain.TransactionResult) <- domain.TransactionResult{
+			TransactionId: id,
+			Success:       false,
+		}
 		close(sub.(chan domain.TransactionResult))
 	}
-	a.currentTransactions.Delete(id)
-	a.results.Delete(id)
 }
@@ -0,0 +1,104 @@
+package strategy
+
+import (
+	"KVDB/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newAtomicNode(network *memoryNetwork, id uint64, repo *memoryRepo) *AtomicTransactionManager {
+	a := &AtomicTransactionManager{
+		currentInstance:        &domain.DbInstance{Id: id},
+		conflictFinder:         &domain.ConflictFinder{Versions: repo},
+		repository:             repo,
+		transactionBroadcaster: network.broadcaster(id),
+		clock:                  domain.NewHybridLogicalClock(time.Now),
+	}
+	network.join(id, a)
+	return a
+}
+
+func TestAtomicTransactionManager_SequencerOrderPreventsLostUpdate(t *testing.T) {
+	// El secuenciador entrega todo a todos, también al que lo envió
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newAtomicNode(network, 1, repo1)
+	node2 := newAtomicNode(network, 2, repo2)
+	v1 := committed("saldo", "100")
+	repo1.Save(v1)
+	repo2.Save(v1)
+
+	// Cada nodo lee la misma versión y escribe encima
+	tx1 := domain.NewTransaction()
+	repo1.read(&tx1, "saldo")
+	tx1.AddWriteEntry(domain.NewDbEntry("saldo", "150", false))
+	tx2 := domain.NewTransaction()
+	repo2.read(&tx2, "saldo")
+	tx2.AddWriteEntry(domain.NewDbEntry("saldo", "80", false))
+	result1 := node1.Execute(tx1)
+	result2 := node2.Execute(tx2)
+	assert.Equal(t, "100", repo1.value("saldo"), "nada se aplica antes de que el secuenciador lo ordene")
+	network.pump()
+
+	first, second := <-result1, <-result2
+	assert.True(t, first.Success)
+	assert.False(t, second.Success, "su lectura quedó vieja tras la primera")
+	assert.ErrorIs(t, second.Err(), domain.ErrTransactionAborted)
+	assert.Equal(t, "150", repo1.value("saldo"))
+	assert.Equal(t, "150", repo2.value("saldo"), "los dos nodos llegan al mismo estado")
+}
+
+func TestAtomicTransactionManager_OnlyOneConditionalWriteWins(t *testing.T) {
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newAtomicNode(network, 1, repo1)
+	node2 := newAtomicNode(network, 2, repo2)
+
+	// Los dos nodos intentan tomar el lease a la vez
+	lease := func(owner string) domain.Transaction {
+		tx := domain.TransactionFromWriteEntry(domain.NewDbEntry("lease", owner, false))
+		tx.AddCondition("lease", domain.Condition{If: domain.IfAbsent})
+		return tx
+	}
+	result1 := node1.Execute(lease("nodo-1"))
+	result2 := node2.Execute(lease("nodo-2"))
+	network.pump()
+
+	first, second := <-result1, <-result2
+	assert.True(t, first.Success, "el secuenciador lo entregó primero")
+	assert.False(t, second.Success)
+	assert.ErrorIs(t, second.Err(), domain.ErrConditionFailed)
+	assert.Equal(t, "nodo-1", repo1.value("lease"))
+	assert.Equal(t, "nodo-1", repo2.value("lease"), "la condición se evalúa en cada nodo")
+}
+
+func TestAtomicTransactionManager_ExpiryIsJudgedAtTheTransactionTimestamp(t *testing.T) {
+	// El lease vence justo ahora; el reloj del nodo 1 va un segundo
+	// adelantado y el del nodo 2 un segundo atrasado
+	deadline := time.Now()
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newAtomicNode(network, 1, repo1)
+	node1.clock = domain.NewHybridLogicalClock(func() time.Time { return deadline.Add(time.Second) })
+	node2 := newAtomicNode(network, 2, repo2)
+	node2.clock = domain.NewHybridLogicalClock(func() time.Time { return deadline.Add(-time.Second) })
+	lease := committed("lease", "nodo-0")
+	lease.SetExpiresAt(deadline.UnixNano())
+	repo1.Save(lease)
+	repo2.Save(lease)
+
+	take := func(owner string) domain.Transaction {
+		tx := domain.TransactionFromWriteEntry(domain.NewDbEntry("lease", owner, false))
+		tx.AddCondition("lease", domain.Condition{If: domain.IfAbsent})
+		return tx
+	}
+	result2 := node2.Execute(take("nodo-2"))
+	result1 := node1.Execute(take("nodo-1"))
+	network.pump()
+
+	assert.False(t, (<-result2).Success, "para el nodo 2 el lease todavía no venció")
+	assert.True(t, (<-result1).Success, "para el nodo 1 ya venció")
+	assert.Equal(t, "nodo-1", repo1.value("lease"))
+	assert.Equal(t, "nodo-1", repo2.value("lease"), "las dos réplicas llegan al mismo veredicto")
+}
@@ -0,0 +1,184 @@
+package strategy
+
+import (
+	"KVDB/internal/domain"
+	"KVDB/internal/platform/messaging/zeromq/message"
+	"sort"
+	"sync"
+)
+
+// memoryRepo guarda la última escritura de cada clave, con su versión, como el
+// almacenamiento de un nodo
+type memoryRepo struct {
+	mu      sync.Mutex
+	entries map[string]domain.DbEntry
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{entries: make(map[string]domain.DbEntry)}
+}
+
+func (r *memoryRepo) Get(namespace string, key []byte) (domain.DbEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, found := r.entries[namespace+"/"+string(key)]
+	return entry, found
+}
+
+func (r *memoryRepo) Save(entry domain.DbEntry) domain.DbEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[entry.Namespace()+"/"+entry.Key()] = entry
+	return entry
+}
+
+func (r *memoryRepo) Delete(namespace string, key []byte) (*domain.DbEntry, bool) {
+	entry, found := r.Get(namespace, key)
+	if !found {
+		return nil, false
+	}
+	entry.Delete()
+	r.Save(entry)
+	return &entry, true
+}
+
+// value devuelve el valor guardado de key, vacío si no está
+func (r *memoryRepo) value(key string) string {
+	entry, _ := r.Get(domain.DefaultNamespace, []byte(key))
+	return entry.Value()
+}
+
+// read agrega al read set lo que el nodo tiene guardado de key
+func (r *memoryRepo) read(tx *domain.Transaction, key string) {
+	entry, found := r.Get(domain.DefaultNamespace, []byte(key))
+	if !found {
+		entry = domain.AbsentEntry(domain.DefaultNamespace, []byte(key))
+	}
+	tx.AddReadEntry(entry)
+}
+
+// memoryNetwork encola los mensajes y los entrega en orden cuando el test
+// llama a pump, a todos los nodos en el mismo orden como el secuenciador. Con
+// loopback el que envía también los recibe, como con AutoSubscribe.
+type memoryNetwork struct {
+	mu       sync.Mutex
+	nodes    map[uint64]domain.TransactionExecutionStrategy
+	pending  []func()
+	loopback bool
+	// instances son todos los nodos, los que cuentan los acks
+	instances *domain.DbInstanceManager
+}
+
+func newMemoryNetwork(loopback bool, ids ...uint64) *memoryNetwork {
+	replicas := make([]domain.DbInstance, 0, len(ids))
+	for _, id := range ids {
+		replicas = append(replicas, domain.DbInstance{Id: id})
+	}
+	return &memoryNetwork{
+		nodes:     make(map[uint64]domain.TransactionExecutionStrategy),
+		loopback:  loopback,
+		instances: &domain.DbInstanceManager{Replicas: &replicas},
+	}
+}
+
+func (n *memoryNetwork) join(id uint64, node domain.TransactionExecutionStrategy) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[id] = node
+}
+
+// queued dice cuántos mensajes esperan ser entregados
+func (n *memoryNetwork) queued() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+// pump entrega los mensajes pendientes, también los que se envían mientras
+// tanto, hasta que no queda ninguno
+func (n *memoryNetwork) pump() {
+	for {
+		n.mu.Lock()
+		if len(n.pending) == 0 {
+			n.mu.Unlock()
+			return
+		}
+		deliver := n.pending[0]
+		n.pending = n.pending[1:]
+		n.mu.Unlock()
+		deliver()
+	}
+}
+
+func (n *memoryNetwork) send(from uint64, deliver func(node domain.TransactionExecutionStrategy)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ids := make([]uint64, 0, len(n.nodes))
+	for id := range n.nodes {
+		if id != from || n.loopback {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		node := n.nodes[id]
+		n.pending = append(n.pending, func() { deliver(node) })
+	}
+}
+
+func (n *memoryNetwork) broadcaster(from uint64) domain.TransactionBroadcaster {
+	return &memoryBroadcaster{network: n, from: from}
+}
+
+// memoryBroadcaster pasa cada mensaje por su formato en la red, así los
+// nodos solo ven lo que viaja
+type memoryBroadcaster struct {
+	network *memoryNetwork
+	from    uint64
+}
+
+func overTheWire(tx domain.Transaction) domain.Transaction {
+	m := message.TransactionMessageFrom(tx)
+	return m.ToTransaction()
+}
+
+func (b *memoryBroadcaster) BroadcastTransaction(tx domain.Transaction) error {
+	tx = overTheWire(tx)
+	b.network.send(b.from, func(node domain.TransactionExecutionStrategy) { node.AddTransaction(tx) })
+	return nil
+}
+
+func (b *memoryBroadcaster) BroadcastAbort(tx domain.Transaction) error {
+	b.network.send(b.from, func(node domain.TransactionExecutionStrategy) { node.AbortTransaction(tx.Id) })
+	return nil
+}
+
+func (b *memoryBroadcaster) BroadcastCommitInit(tx domain.Transaction) error {
+	tx = overTheWire(tx)
+	b.network.send(b.from, func(node domain.TransactionExecutionStrategy) {
+		node.(domain.ReliableBroadcastTransactionManager).InitCommit(tx)
+	})
+	return nil
+}
+
+// BroadcastCommitConfirmation no se escucha: cada nodo confirma al recibir
+// todos los acks
+func (b *memoryBroadcaster) BroadcastCommitConfirmation(tx domain.Transaction) error {
+	return nil
+}
+
+func (b *memoryBroadcaster) BroadcastAck(ack domain.TransactionCommitAck) error {
+	m := message.AckMessageFromCommitAck(ack)
+	ack = m.ToCommitAck()
+	b.network.send(b.from, func(node domain.TransactionExecutionStrategy) {
+		node.(domain.ReliableBroadcastTransactionManager).AddCommitAck(ack)
+	})
+	return nil
+}
+
+// committed es una entrada escrita por una transacción ya confirmada
+func committed(key, value string) domain.DbEntry {
+	tx := domain.TransactionFromWriteEntry(domain.NewDbEntry(key, value, false))
+	tx.Stamp(domain.Clock.Now())
+	return tx.WriteSet[key]
+}
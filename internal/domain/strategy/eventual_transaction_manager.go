@@ -2,13 +2,22 @@ package strategy
 
 import (
 	"KVDB/internal/domain"
+	"fmt"
 	"sync"
 )
 
+// EventualTransactionManager applies transactions locally and then sends
+// them to the other nodes, which apply them as they arrive. Reads are only
+// validated on the node that served them, against its own store: two nodes
+// may both commit a transaction over the same version of a key, and each
+// ends with the write it received last. For the same reason conditional
+// writes are rejected: no node could make them hold everywhere.
 type EventualTransactionManager struct {
 	currentTransactions map[string]domain.Transaction
 	repository          domain.DbEntryRepository
 	broadcaster         domain.TransactionBroadcaster
+	conflictDetector    domain.ConflictDetector
+	clock               *domain.HybridLogicalClock
 	mu                  sync.Mutex
 }
 
@@ -18,13 +27,29 @@ func NewEventualTransactionManager(repository domain.DbEntryRepository,
 		currentTransactions: make(map[string]domain.Transaction),
 		repository:          repository,
 		broadcaster:         broadcaster,
+		conflictDetector:    &domain.ConflictFinder{Versions: repository},
+		clock:               domain.Clock,
 	}
 }
 
 func (e *EventualTransactionManager) Execute(transaction domain.Transaction) <-chan domain.TransactionResult {
 	ch := make(chan domain.TransactionResult, 1)
+	if len(transaction.Conditions) > 0 {
+		err := fmt.Errorf("%w: eventual consistency takes no conditional writes", domain.ErrInvalidCondition)
+		ch <- domain.FromRejection(transaction, err)
+		close(ch)
+		return ch
+	}
+	transaction.Stamp(e.clock.Now())
 
 	e.mu.Lock()
+	// Validating under the lock keeps local writes from slipping in between
+	if err := e.conflictDetector.Validate(transaction); err != nil {
+		e.mu.Unlock()
+		ch <- domain.FromRejection(transaction, err)
+		close(ch)
+		return ch
+	}
 	ch <- e.execute(transaction)
 	e.mu.Unlock()
 
@@ -45,7 +70,7 @@ func (e *EventualTransactionManager) execute(transaction domain.Transaction) dom
 		e.repository.Save(entry)
 	}
 	for _, entry := range transaction.DeleteSet {
-		e.repository.Delete(entry.Key())
+		e.repository.Delete(entry.Namespace(), entry.KeyBytes())
 	}
 	result := domain.FromTransaction(transaction)
 	result.MarkAsSuccessful()
@@ -53,6 +78,7 @@ func (e *EventualTransactionManager) execute(transaction domain.Transaction) dom
 }
 
 func (e *EventualTransactionManager) AddTransaction(transaction domain.Transaction) {
+	e.clock.Observe(transaction.Timestamp)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.execute(transaction)
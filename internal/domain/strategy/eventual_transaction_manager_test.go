@@ -0,0 +1,62 @@
+package strategy
+
+import (
+	"KVDB/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newEventualNode(network *memoryNetwork, id uint64, repo *memoryRepo) *EventualTransactionManager {
+	e := &EventualTransactionManager{
+		currentTransactions: make(map[string]domain.Transaction),
+		repository:          repo,
+		broadcaster:         network.broadcaster(id),
+		conflictDetector:    &domain.ConflictFinder{Versions: repo},
+		clock:               domain.NewHybridLogicalClock(time.Now),
+	}
+	network.join(id, e)
+	return e
+}
+
+func TestEventualTransactionManager_ValidatesReadsOnlyWhereTheyWereServed(t *testing.T) {
+	network := newMemoryNetwork(false, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newEventualNode(network, 1, repo1)
+	node2 := newEventualNode(network, 2, repo2)
+	v1 := committed("saldo", "100")
+	repo1.Save(v1)
+	repo2.Save(v1)
+
+	tx1 := domain.NewTransaction()
+	repo1.read(&tx1, "saldo")
+	tx1.AddWriteEntry(domain.NewDbEntry("saldo", "150", false))
+	tx2 := domain.NewTransaction()
+	repo2.read(&tx2, "saldo")
+	tx2.AddWriteEntry(domain.NewDbEntry("saldo", "80", false))
+	first, second := <-node1.Execute(tx1), <-node2.Execute(tx2)
+	assert.Eventually(t, func() bool { return network.queued() == 2 }, time.Second, time.Millisecond)
+	network.pump()
+
+	// Limitación conocida: las dos confirman y cada nodo termina con la
+	// escritura que recibió último
+	assert.True(t, first.Success)
+	assert.True(t, second.Success)
+	assert.Equal(t, "80", repo1.value("saldo"))
+	assert.Equal(t, "150", repo2.value("saldo"))
+}
+
+func TestEventualTransactionManager_RejectsConditionalWrites(t *testing.T) {
+	network := newMemoryNetwork(false, 1, 2)
+	repo := newMemoryRepo()
+	node := newEventualNode(network, 1, repo)
+
+	tx := domain.TransactionFromWriteEntry(domain.NewDbEntry("lease", "nodo-1", false))
+	tx.AddCondition("lease", domain.Condition{If: domain.IfAbsent})
+	result := <-node.Execute(tx)
+
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Err(), domain.ErrInvalidCondition, "ninguna réplica podría hacerla cumplir")
+	assert.Empty(t, repo.value("lease"))
+	assert.Zero(t, network.queued())
+}
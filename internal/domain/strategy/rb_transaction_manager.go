@@ -2,6 +2,7 @@ package strategy
 
 import (
 	"KVDB/internal/domain"
+	"errors"
 	"sync"
 )
 
@@ -15,20 +16,26 @@ type RbTransactionManager struct {
 	conflictDetector       domain.ConflictDetector
 	conflictResolver       domain.ConflictResolver
 	dbEntryRepository      domain.DbEntryRepository
+	clock                  *domain.HybridLogicalClock
 	mu                     sync.RWMutex
 }
 
 func NewRbTransactionManager(tb domain.TransactionBroadcaster, cam *domain.TransactionCommitAckManager,
-	repository domain.DbEntryRepository, im *domain.DbInstanceManager) *RbTransactionManager {
+	repository domain.DbEntryRepository, im *domain.DbInstanceManager,
+	namespaces domain.NamespaceRegistry) *RbTransactionManager {
 	tm := &RbTransactionManager{
 		CurrentTransactions:    make(map[string]domain.Transaction),
 		transactionBroadcaster: tb,
 		commitAckManager:       cam,
-		conflictDetector:       &domain.ConflictFinder{},
-		conflictResolver:       &domain.LWWConflictResolver{},
-		dbEntryRepository:      repository,
-		instanceManager:        im,
-		subscribers:            make(map[string]chan domain.TransactionResult),
+		conflictDetector:       &domain.ConflictFinder{Versions: repository},
+		conflictResolver: &domain.NamespaceConflictResolver{
+			Default:    &domain.LWWConflictResolver{},
+			Namespaces: namespaces,
+		},
+		dbEntryRepository: repository,
+		instanceManager:   im,
+		subscribers:       make(map[string]chan domain.TransactionResult),
+		clock:             domain.Clock,
 	}
 	tm.setCurrentInstance()
 	return tm
@@ -45,6 +52,7 @@ func (tm *RbTransactionManager) setCurrentInstance() {
 func (tm *RbTransactionManager) Execute(t domain.Transaction) <-chan domain.TransactionResult {
 	tm.mu.Lock()
 	t.InstanceId = tm.currentInstance.Id
+	t.Stamp(tm.clock.Now())
 	tm.CurrentTransactions[t.Id] = t
 	ch := make(chan domain.TransactionResult, 1)
 	tm.subscribers[t.Id] = ch
@@ -62,21 +70,35 @@ func (tm *RbTransactionManager) Execute(t domain.Transaction) <-chan domain.Tran
 }
 
 func (tm *RbTransactionManager) AddTransaction(transaction domain.Transaction) {
+	tm.clock.Observe(transaction.Timestamp)
+	if transaction.InstanceId == tm.currentInstance.Id {
+		// The echo of one of its own, which may already be settled
+		return
+	}
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
-	transaction.InstanceId = tm.currentInstance.Id
+	// The instance it started on is kept, conflicts being settled by it
 	tm.CurrentTransactions[transaction.Id] = transaction
 }
 
 func (tm *RbTransactionManager) StartTransactionAbortion(transaction domain.Transaction) {
+	tm.abort(transaction, domain.FromTransaction(transaction))
+}
+
+// abort answers the subscriber of transaction with result and tells the
+// other nodes to discard it
+func (tm *RbTransactionManager) abort(transaction domain.Transaction, result domain.TransactionResult) {
 	var ch chan domain.TransactionResult
 	tm.mu.Lock()
 	ch = tm.subscribers[transaction.Id]
 	delete(tm.subscribers, transaction.Id)
 	tm.mu.Unlock()
 
-	ch <- domain.FromTransaction(transaction)
-	close(ch)
+	// Only the node it started on has a subscriber
+	if ch != nil {
+		ch <- result
+		close(ch)
+	}
 
 	err := tm.transactionBroadcaster.BroadcastAbort(transaction)
 	if err != nil {
@@ -86,40 +108,67 @@ func (tm *RbTransactionManager) StartTransactionAbortion(transaction domain.Tran
 
 func (tm *RbTransactionManager) AbortTransaction(transactionId string) {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-	_, exists := tm.CurrentTransactions[transactionId]
+	transaction, exists := tm.CurrentTransactions[transactionId]
 	if !exists {
+		tm.mu.Unlock()
 		return
 	}
 	delete(tm.CurrentTransactions, transactionId)
 	tm.commitAckManager.Remove(transactionId)
+	// Set when another node aborted it before this one heard why
+	ch := tm.subscribers[transactionId]
+	delete(tm.subscribers, transactionId)
+	tm.mu.Unlock()
+
+	if ch != nil {
+		ch <- domain.FromTransaction(transaction)
+		close(ch)
+	}
 }
 
 func (tm *RbTransactionManager) InitCommit(transaction domain.Transaction) {
+	tm.clock.Observe(transaction.Timestamp)
+	if transaction.InstanceId == tm.currentInstance.Id {
+		// Voted on when it started the commit
+		return
+	}
 	tm.mu.RLock()
 	if _, exists := tm.CurrentTransactions[transaction.Id]; !exists {
+		tm.mu.RUnlock()
 		return
 	}
 	conflict := tm.conflictDetector.Check(tm.CurrentTransactions, transaction)
 	tm.mu.RUnlock()
 
+	// Versions are the same on every node, so a node that already applied a
+	// write the transaction did not read, or that breaks one of its
+	// conditions, vetoes it
+	if err := tm.conflictDetector.Validate(transaction); err != nil {
+		ack := tm.newAck(transaction.Id, transaction.InstanceId, false)
+		ack.ConditionFailed = errors.Is(err, domain.ErrConditionFailed)
+		// Sent before aborting here, so the origin hears the veto first
+		tm.transactionBroadcaster.BroadcastAck(ack)
+		tm.AddCommitAck(ack)
+		return
+	}
+
 	if conflict != nil {
 		resolution := tm.conflictResolver.Resolve(*conflict)
 		for _, abortingTransaction := range resolution.AbortingTransactions {
-			ack := domain.NewTransactionCommitAck(abortingTransaction.Id, tm.currentInstance.Id, transaction.InstanceId, false)
+			ack := tm.newAck(abortingTransaction.Id, transaction.InstanceId, false)
 			tm.AddCommitAck(ack)
 			tm.transactionBroadcaster.BroadcastAck(ack)
 		}
 
 		for _, committingTransaction := range resolution.CommitingTransactions {
-			ack := domain.NewTransactionCommitAck(committingTransaction.Id, tm.currentInstance.Id, transaction.InstanceId, true)
+			ack := tm.newAck(committingTransaction.Id, transaction.InstanceId, true)
 			tm.AddCommitAck(ack)
 			tm.transactionBroadcaster.BroadcastAck(ack)
 		}
 		return
 	}
 
-	ack := domain.NewTransactionCommitAck(transaction.Id, tm.currentInstance.Id, transaction.InstanceId, true)
+	ack := tm.newAck(transaction.Id, transaction.InstanceId, true)
 	//tm.AddCommitAck(ack)
 	err := tm.transactionBroadcaster.BroadcastAck(ack)
 	if err != nil {
@@ -137,16 +186,22 @@ func (tm *RbTransactionManager) StartInitCommit(transaction domain.Transaction)
 	conflict := tm.conflictDetector.Check(tm.CurrentTransactions, transaction)
 	tm.mu.RUnlock()
 
+	if err := tm.conflictDetector.Validate(transaction); err != nil {
+		tm.abort(transaction, domain.FromRejection(transaction, err))
+		tm.AbortTransaction(transaction.Id)
+		return
+	}
+
 	if conflict != nil {
 		resolution := tm.conflictResolver.Resolve(*conflict)
 		for _, abortingTransaction := range resolution.AbortingTransactions {
-			ack := domain.NewTransactionCommitAck(abortingTransaction.Id, tm.currentInstance.Id, transaction.InstanceId, false)
+			ack := tm.newAck(abortingTransaction.Id, transaction.InstanceId, false)
 			//tm.commitAckManager.Add(ack)
 			tm.transactionBroadcaster.BroadcastAck(ack)
 		}
 
 		for _, committingTransaction := range resolution.CommitingTransactions {
-			ack := domain.NewTransactionCommitAck(committingTransaction.Id, tm.currentInstance.Id, transaction.InstanceId, true)
+			ack := tm.newAck(committingTransaction.Id, transaction.InstanceId, true)
 			//tm.commitAckManager.Add(ack)
 			tm.transactionBroadcaster.BroadcastAck(ack)
 		}
@@ -157,7 +212,7 @@ func (tm *RbTransactionManager) StartInitCommit(transaction domain.Transaction)
 		return
 	}
 
-	ack := domain.NewTransactionCommitAck(transaction.Id, tm.currentInstance.Id, transaction.InstanceId, true)
+	ack := tm.newAck(transaction.Id, transaction.InstanceId, true)
 	//tm.commitAckManager.Add(ack)
 	err = tm.transactionBroadcaster.BroadcastAck(ack)
 	if err != nil {
@@ -166,6 +221,15 @@ func (tm *RbTransactionManager) StartInitCommit(transaction domain.Transaction)
 }
 
 func (tm *RbTransactionManager) ConfirmCommit(transaction domain.Transaction) {
+	// The writes are applied before the transaction leaves the ones in
+	// flight, so a transaction validated in between still conflicts with it
+	for _, entry := range transaction.WriteSet {
+		tm.dbEntryRepository.Save(entry)
+	}
+	for _, entry := range transaction.DeleteSet {
+		tm.dbEntryRepository.Delete(entry.Namespace(), entry.KeyBytes())
+	}
+
 	var ch chan domain.TransactionResult
 	tm.mu.Lock()
 	delete(tm.CurrentTransactions, transaction.Id)
@@ -175,13 +239,6 @@ func (tm *RbTransactionManager) ConfirmCommit(transaction domain.Transaction) {
 		delete(tm.subscribers, transaction.Id)
 	}
 	tm.mu.Unlock()
-
-	for _, entry := range transaction.WriteSet {
-		tm.dbEntryRepository.Save(entry)
-	}
-	for _, entry := range transaction.DeleteSet {
-		tm.dbEntryRepository.Delete(entry.Key())
-	}
 	if ch != nil {
 		result := domain.FromTransaction(transaction)
 		result.MarkAsSuccessful()
@@ -191,6 +248,7 @@ func (tm *RbTransactionManager) ConfirmCommit(transaction domain.Transaction) {
 }
 
 func (tm *RbTransactionManager) AddCommitAck(ack domain.TransactionCommitAck) {
+	tm.clock.Observe(ack.Timestamp)
 	tm.mu.RLock()
 	transaction, exists := tm.CurrentTransactions[ack.TransactionId]
 	tm.mu.RUnlock()
@@ -199,7 +257,9 @@ func (tm *RbTransactionManager) AddCommitAck(ack domain.TransactionCommitAck) {
 	}
 
 	if !ack.Valid {
-		tm.StartTransactionAbortion(transaction)
+		result := domain.FromTransaction(transaction)
+		result.ConditionFailed = ack.ConditionFailed
+		tm.abort(transaction, result)
 		tm.AbortTransaction(transaction.Id)
 		return
 	}
@@ -211,3 +271,10 @@ func (tm *RbTransactionManager) AddCommitAck(ack domain.TransactionCommitAck) {
 
 	tm.ConfirmCommit(transaction)
 }
+
+// newAck is an ack of this node stamped with its clock
+func (tm *RbTransactionManager) newAck(transactionId string, receiver uint64, valid bool) domain.TransactionCommitAck {
+	ack := domain.NewTransactionCommitAck(transactionId, tm.currentInstance.Id, receiver, valid)
+	ack.Timestamp = tm.clock.Now()
+	return ack
+}
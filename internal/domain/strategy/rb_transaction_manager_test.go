@@ -4,6 +4,7 @@ import (
 	"KVDB/internal/domain"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 type mockBroadcaster struct {
@@ -14,9 +15,9 @@ type mockBroadcaster struct {
 	broadcastedAcks          []domain.TransactionCommitAck
 }
 
-func (m *mockBroadcaster) BroadcastAck(transaction domain.TransactionCommitAck) error {
-	//TODO implement me
-	panic("implement me")
+func (m *mockBroadcaster) BroadcastAck(ack domain.TransactionCommitAck) error {
+	m.broadcastedAcks = append(m.broadcastedAcks, ack)
+	return nil
 }
 
 func (m *mockBroadcaster) BroadcastTransaction(tx domain.Transaction) error {
@@ -70,9 +71,9 @@ type mockRepo struct {
 	deleted []string
 }
 
-func (m *mockRepo) Get(key string) (domain.DbEntry, bool) {
+func (m *mockRepo) Get(namespace string, key []byte) (domain.DbEntry, bool) {
 	for _, entry := range m.saved {
-		if entry.Key() == key {
+		if entry.Namespace() == namespace && entry.Key() == string(key) {
 			return entry, true
 		}
 	}
@@ -83,8 +84,8 @@ func (m *mockRepo) Save(entry domain.DbEntry) domain.DbEntry {
 	m.saved = append(m.saved, entry)
 	return entry
 }
-func (m *mockRepo) Delete(key string) (*domain.DbEntry, bool) {
-	m.deleted = append(m.deleted, key)
+func (m *mockRepo) Delete(namespace string, key []byte) (*domain.DbEntry, bool) {
+	m.deleted = append(m.deleted, string(key))
 	return nil, true
 }
 
@@ -97,6 +98,7 @@ func createTransactionManager(b *mockBroadcaster, cam *mockCommitAckManager, rep
 		commitAckManager:       cam,
 		dbEntryRepository:      repo,
 		currentInstance:        instance,
+		clock:                  domain.NewHybridLogicalClock(time.Now),
 	}
 	return tm
 }
@@ -152,11 +154,18 @@ func Test_GivenConflict_WhenCommitInit_thenCommitNewestAndAbortOldest(t *testing
 
 	tm.InitCommit(tx)
 
-	assert.Len(t, ackSender.sentAcks, 2)
-	assert.Equal(t, tx.Id, ackSender.sentAcks[0].TransactionId)
-	assert.Equal(t, false, ackSender.sentAcks[0].Valid)
-	assert.Equal(t, tx2.Id, ackSender.sentAcks[1].TransactionId)
-	assert.Equal(t, true, ackSender.sentAcks[1].Valid)
+	assert.Len(t, b.broadcastedAcks, 2)
+	assert.Equal(t, tx.Id, b.broadcastedAcks[0].TransactionId)
+	assert.Equal(t, false, b.broadcastedAcks[0].Valid)
+	assert.Equal(t, tx2.Id, b.broadcastedAcks[1].TransactionId)
+	assert.Equal(t, true, b.broadcastedAcks[1].Valid)
+	// El propio ack negativo ya la aborta en este nodo
+	assert.Len(t, b.broadcastedAborts, 1)
+	assert.Equal(t, tx.Id, b.broadcastedAborts[0].Id)
+	assert.NotContains(t, tm.CurrentTransactions, tx.Id)
+	assert.Contains(t, tm.CurrentTransactions, tx2.Id)
+	assert.Len(t, cam.addedAcks, 1, "solo cuenta el ack positivo de la más nueva")
+	assert.Equal(t, tx2.Id, cam.addedAcks[0].TransactionId)
 }
 
 func Test_GivenReceivedPositiveAck_WhenNotReceivedAckFromAllInstances_AddCommitAck(t *testing.T) {
@@ -179,7 +188,7 @@ func Test_GivenReceivedPositiveAck_WhenNotReceivedAckFromAllInstances_AddCommitA
 	assert.Len(t, cam.addedAcks, 1)
 }
 
-func Test_GivenPositiveAck_WhenReceiveAckFromAllInstances_thenStartCommitConfirmation(t *testing.T) {
+func Test_GivenPositiveAck_WhenReceiveAckFromAllInstances_thenConfirmCommit(t *testing.T) {
 	b := &mockBroadcaster{}
 	cam := &mockCommitAckManager{ackedByAllInstances: true, hasOnlyPositiveAcks: true}
 	repo := &mockRepo{}
@@ -193,10 +202,13 @@ func Test_GivenPositiveAck_WhenReceiveAckFromAllInstances_thenStartCommitConfirm
 
 	tm.AddCommitAck(ack)
 	assert.Len(t, b.broadcastedAborts, 0)
-	assert.Len(t, b.broadcastedConfirmations, 1)
-	assert.Equal(t, tx.Id, b.broadcastedConfirmations[0].Id)
+	// Cada nodo confirma al recibir todos los acks, sin esperar a nadie
+	assert.Len(t, b.broadcastedConfirmations, 0)
 	assert.Len(t, cam.addedAcks, 1)
 	assert.Equal(t, tx.Id, cam.addedAcks[0].TransactionId)
+	assert.Contains(t, repo.saved, tx.WriteSet["k"])
+	assert.NotContains(t, tm.CurrentTransactions, tx.Id)
+	assert.Contains(t, cam.removedTransactionIds, tx.Id)
 }
 
 func Test_GivenNegativeAck_WhenReceiveAck_thenStartAbortion(t *testing.T) {
@@ -216,3 +228,187 @@ func Test_GivenNegativeAck_WhenReceiveAck_thenStartAbortion(t *testing.T) {
 	assert.Equal(t, tx.Id, b.broadcastedAborts[0].Id)
 	assert.Len(t, cam.addedAcks, 0)
 }
+
+func Test_GivenStaleRead_WhenExecute_thenAbortWithoutAcks(t *testing.T) {
+	b := &mockBroadcaster{}
+	cam := &mockCommitAckManager{}
+	repo := &mockRepo{}
+	instance := &domain.DbInstance{Id: 1}
+	tm := createTransactionManager(b, cam, repo, instance, &mockCommitAckSender{})
+	tm.conflictDetector = &domain.ConflictFinder{Versions: repo}
+	tm.subscribers = make(map[string]chan domain.TransactionResult)
+
+	read := domain.NewDbEntry("k", "v1", false)
+	read.SetVersion(1)
+	stored := domain.NewDbEntry("k", "v2", false)
+	stored.SetVersion(2)
+	repo.Save(stored)
+
+	tx := domain.NewTransaction()
+	tx.AddReadEntry(read)
+	tx.AddWriteEntry(domain.NewDbEntry("k", "v3", false))
+	result := <-tm.Execute(tx)
+
+	assert.False(t, result.Success, "la lectura ya no es la versión guardada")
+	assert.Len(t, b.broadcastedAborts, 1)
+	assert.Len(t, b.broadcastedCommitInits, 0)
+	assert.NotContains(t, tm.CurrentTransactions, tx.Id)
+	assert.Len(t, repo.saved, 1, "no se aplica ninguna escritura")
+}
+
+func Test_GivenSkewedClocks_WhenReceiveTransaction_thenLaterTransactionsAreStampedAfterIt(t *testing.T) {
+	// El nodo 1 va un minuto adelantado respecto del nodo 2
+	b1, b2 := &mockBroadcaster{}, &mockBroadcaster{}
+	node1 := createTransactionManager(b1, &mockCommitAckManager{}, &mockRepo{}, &domain.DbInstance{Id: 1}, &mockCommitAckSender{})
+	node1.clock = domain.NewHybridLogicalClock(func() time.Time { return time.Now().Add(time.Minute) })
+	node1.subscribers = make(map[string]chan domain.TransactionResult)
+	node2 := createTransactionManager(b2, &mockCommitAckManager{}, &mockRepo{}, &domain.DbInstance{Id: 2}, &mockCommitAckSender{})
+	node2.subscribers = make(map[string]chan domain.TransactionResult)
+
+	node1.Execute(domain.TransactionFromWriteEntry(domain.NewDbEntry("a", "v1", false)))
+	first := b1.broadcastedTxs[0]
+	node2.AddTransaction(first)
+	node2.Execute(domain.TransactionFromWriteEntry(domain.NewDbEntry("b", "v2", false)))
+	second := b2.broadcastedTxs[0]
+
+	assert.Greater(t, second.Timestamp, first.Timestamp, "cada nodo sella con su propio reloj")
+	assert.Less(t, time.Until(domain.WallTime(second.Timestamp)), 2*time.Minute)
+}
+
+func Test_GivenRemoteTimestampAhead_WhenConflict_thenLargestHlcWins(t *testing.T) {
+	// El reloj del nodo 1 va un minuto adelantado; el del nodo 2 marca la hora
+	b1, b2 := &mockBroadcaster{}, &mockBroadcaster{}
+	node1 := createTransactionManager(b1, &mockCommitAckManager{}, &mockRepo{}, &domain.DbInstance{Id: 1}, &mockCommitAckSender{})
+	node1.clock = domain.NewHybridLogicalClock(func() time.Time { return time.Now().Add(time.Minute) })
+	node1.subscribers = make(map[string]chan domain.TransactionResult)
+	node2 := createTransactionManager(b2, &mockCommitAckManager{}, &mockRepo{}, &domain.DbInstance{Id: 2}, &mockCommitAckSender{})
+	node2.subscribers = make(map[string]chan domain.TransactionResult)
+
+	node1.Execute(domain.TransactionFromWriteEntry(domain.NewDbEntry("k", "nodo-1", false)))
+	remote := b1.broadcastedTxs[0]
+	node2.AddTransaction(remote)
+	node2.Execute(domain.TransactionFromWriteEntry(domain.NewDbEntry("k", "nodo-2", false)))
+	local := b2.broadcastedTxs[0]
+
+	assert.Greater(t, local.Timestamp, remote.Timestamp, "después de observarla, sella por delante de la remota")
+	assert.Greater(t, time.Until(domain.WallTime(local.Timestamp)), 30*time.Second,
+		"aunque su reloj vaya atrasado")
+
+	// Resuelto por HLC y no por el reloj de cada nodo, gana la sellada después
+	assert.Len(t, b2.broadcastedAcks, 2)
+	for _, ack := range b2.broadcastedAcks {
+		assert.Equal(t, ack.TransactionId == local.Id, ack.Valid, ack.TransactionId)
+	}
+	conflict := domain.NewConflict()
+	conflict.AddTransaction(remote)
+	conflict.AddTransaction(local)
+	resolution := (&domain.LWWConflictResolver{}).Resolve(*conflict)
+	assert.Contains(t, resolution.CommitingTransactions, local.Id)
+	assert.Contains(t, resolution.AbortingTransactions, remote.Id)
+}
+
+func newRbNode(network *memoryNetwork, id uint64, repo *memoryRepo) *RbTransactionManager {
+	tm := &RbTransactionManager{
+		CurrentTransactions:    make(map[string]domain.Transaction),
+		subscribers:            make(map[string]chan domain.TransactionResult),
+		transactionBroadcaster: network.broadcaster(id),
+		commitAckManager:       domain.NewTransactionCommitAckManager(network.instances),
+		conflictDetector:       &domain.ConflictFinder{Versions: repo},
+		conflictResolver:       &domain.LWWConflictResolver{},
+		dbEntryRepository:      repo,
+		currentInstance:        &domain.DbInstance{Id: id},
+		clock:                  domain.NewHybridLogicalClock(time.Now),
+	}
+	network.join(id, tm)
+	return tm
+}
+
+func Test_GivenSameVersions_WhenReadModifyWrite_thenCommitOnEveryNode(t *testing.T) {
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	newRbNode(network, 1, repo1)
+	node2 := newRbNode(network, 2, repo2)
+	v1 := committed("k", "v1")
+	repo1.Save(v1)
+	repo2.Save(v1)
+
+	tx := domain.NewTransaction()
+	repo2.read(&tx, "k")
+	tx.AddWriteEntry(domain.NewDbEntry("k", "v2", false))
+	result := node2.Execute(tx)
+	network.pump()
+
+	assert.True(t, (<-result).Success, "la versión leída es la misma en los dos nodos")
+	assert.Equal(t, "v2", repo1.value("k"))
+	assert.Equal(t, "v2", repo2.value("k"))
+	stored1, _ := repo1.Get(domain.DefaultNamespace, []byte("k"))
+	stored2, _ := repo2.Get(domain.DefaultNamespace, []byte("k"))
+	assert.Equal(t, stored1.Version(), stored2.Version())
+}
+
+func Test_GivenReplicaAheadOfOrigin_WhenStaleRead_thenReplicaVetoesCommit(t *testing.T) {
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newRbNode(network, 1, repo1)
+	node2 := newRbNode(network, 2, repo2)
+	// Los dos guardaron v1, y el nodo 1 ya aplicó v2 que al nodo 2 no le llegó
+	v1 := committed("k", "v1")
+	repo1.Save(v1)
+	repo2.Save(v1)
+	repo1.Save(committed("k", "v2"))
+
+	tx := domain.NewTransaction()
+	repo2.read(&tx, "k")
+	tx.AddWriteEntry(domain.NewDbEntry("k", "v3", false))
+	result := node2.Execute(tx)
+	network.pump()
+
+	assert.False(t, (<-result).Success, "el nodo 1 ya guarda otra versión de k")
+	assert.Equal(t, "v2", repo1.value("k"))
+	assert.Equal(t, "v1", repo2.value("k"), "ningún nodo aplica la escritura")
+	assert.Empty(t, node1.CurrentTransactions)
+	assert.Empty(t, node2.CurrentTransactions)
+}
+
+func Test_GivenConcurrentPutIfAbsent_WhenCommit_thenOnlyOneWins(t *testing.T) {
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	node1 := newRbNode(network, 1, repo1)
+	node2 := newRbNode(network, 2, repo2)
+
+	lease := func(owner string) domain.Transaction {
+		tx := domain.TransactionFromWriteEntry(domain.NewDbEntry("lease", owner, false))
+		tx.AddCondition("lease", domain.Condition{If: domain.IfAbsent})
+		return tx
+	}
+	result1 := node1.Execute(lease("nodo-1"))
+	result2 := node2.Execute(lease("nodo-2"))
+	network.pump()
+
+	first, second := <-result1, <-result2
+	assert.NotEqual(t, first.Success, second.Success, "exactamente una toma el lease")
+	assert.NotEmpty(t, repo1.value("lease"))
+	assert.Equal(t, repo1.value("lease"), repo2.value("lease"))
+	assert.Empty(t, node1.CurrentTransactions)
+	assert.Empty(t, node2.CurrentTransactions)
+}
+
+func Test_GivenReplicaHoldsKey_WhenPutIfAbsent_thenReportConditionFailed(t *testing.T) {
+	network := newMemoryNetwork(true, 1, 2)
+	repo1, repo2 := newMemoryRepo(), newMemoryRepo()
+	newRbNode(network, 1, repo1)
+	node2 := newRbNode(network, 2, repo2)
+	// El nodo 1 ya aplicó un lease que al nodo 2 todavía no le llegó
+	repo1.Save(committed("lease", "nodo-1"))
+
+	tx := domain.TransactionFromWriteEntry(domain.NewDbEntry("lease", "nodo-2", false))
+	tx.AddCondition("lease", domain.Condition{If: domain.IfAbsent})
+	result := node2.Execute(tx)
+	network.pump()
+
+	res := <-result
+	assert.False(t, res.Success)
+	assert.ErrorIs(t, res.Err(), domain.ErrConditionFailed, "el nodo 1 vetó por la condición")
+	assert.Equal(t, "nodo-1", repo1.value("lease"))
+	assert.Empty(t, repo2.value("lease"))
+}
@@ -2,16 +2,24 @@ package domain
 
 import (
 	"github.com/google/uuid"
-	"time"
 )
 
 type Transaction struct {
-	Id         string
-	ReadSet    map[string]DbEntry
-	WriteSet   map[string]DbEntry
-	DeleteSet  map[string]DbEntry
+	Id string
+	// ReadSet holds the entries as read, with the version observed; keys
+	// read while missing hold an AbsentEntry
+	ReadSet   map[string]DbEntry
+	WriteSet  map[string]DbEntry
+	DeleteSet map[string]DbEntry
+	// Timestamp is an HLC timestamp of the node the transaction started on
 	Timestamp  int64
 	InstanceId uint64
+	// Namespace holds every key of the transaction, empty for the default
+	// one
+	Namespace string
+	// Conditions the stored entries of the keys written must meet for the
+	// transaction to commit
+	Conditions map[string]Condition
 }
 
 func NewTransaction() Transaction {
@@ -20,7 +28,7 @@ func NewTransaction() Transaction {
 		ReadSet:   make(map[string]DbEntry),
 		WriteSet:  make(map[string]DbEntry),
 		DeleteSet: make(map[string]DbEntry),
-		Timestamp: time.Now().UnixNano(),
+		Timestamp: Clock.Now(),
 	}
 }
 
@@ -36,14 +44,54 @@ func (t *Transaction) AddDeleteEntry(entry DbEntry) {
 	t.DeleteSet[entry.Key()] = entry.Copy()
 }
 
+// NamespaceName returns the namespace of the transaction, naming the default
+// one.
+func (t *Transaction) NamespaceName() string {
+	if t.Namespace == "" {
+		return DefaultNamespace
+	}
+	return t.Namespace
+}
+
+// Stamp sets the timestamp of the transaction, which also becomes the
+// version of every entry it writes
+func (t *Transaction) Stamp(timestamp int64) {
+	t.Timestamp = timestamp
+	for key, entry := range t.WriteSet {
+		entry.SetVersion(uint64(timestamp))
+		t.WriteSet[key] = entry
+	}
+}
+
+// AddCondition makes the commit depend on the stored entry of key
+func (t *Transaction) AddCondition(key string, condition Condition) {
+	if t.Conditions == nil {
+		t.Conditions = make(map[string]Condition)
+	}
+	t.Conditions[key] = condition
+}
+
+// Before orders transactions by timestamp, breaking ties by the instance they
+// started on and then by id, so every node picks the same winner
+func (t *Transaction) Before(other Transaction) bool {
+	if t.Timestamp != other.Timestamp {
+		return t.Timestamp < other.Timestamp
+	}
+	if t.InstanceId != other.InstanceId {
+		return t.InstanceId < other.InstanceId
+	}
+	return t.Id < other.Id
+}
+
 func TransactionFromReadEntry(entry DbEntry) Transaction {
 	transaction := NewTransaction()
+	transaction.Namespace = entry.Namespace()
 	transaction.AddReadEntry(entry)
 	return transaction
 }
 
 func (t *Transaction) ConflictsWith(other Transaction) bool {
-	if t.IsEmpty() || other.IsEmpty() {
+	if t.IsEmpty() || other.IsEmpty() || t.NamespaceName() != other.NamespaceName() {
 		return false
 	}
 
@@ -79,12 +127,14 @@ func (t *Transaction) IsWriteOrDelete() bool {
 
 func TransactionFromWriteEntry(entry DbEntry) Transaction {
 	transaction := NewTransaction()
+	transaction.Namespace = entry.Namespace()
 	transaction.AddWriteEntry(entry)
 	return transaction
 }
 
 func TransactionFromDeleteEntry(entry DbEntry) Transaction {
 	transaction := NewTransaction()
+	transaction.Namespace = entry.Namespace()
 	transaction.AddDeleteEntry(entry)
 	return transaction
 }
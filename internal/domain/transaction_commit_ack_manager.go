@@ -2,7 +2,6 @@ package domain
 
 import (
 	"sync"
-	"time"
 )
 
 type CommitAckManager interface {
@@ -93,6 +92,9 @@ type TransactionCommitAck struct {
 	ReceiverInstanceId uint64 `json:"receiver_instance_id,omitempty"`
 	Timestamp          int64  `json:"timestamp,omitempty"`
 	Valid              bool   `json:"valid,omitempty"`
+	// ConditionFailed tells a veto over a condition apart from one over a
+	// conflict or a stale read
+	ConditionFailed bool `json:"condition_failed,omitempty"`
 }
 
 func NewTransactionCommitAck(transactionId string, senderInstanceId uint64, receiverInstanceId uint64, valid bool) TransactionCommitAck {
@@ -100,7 +102,7 @@ func NewTransactionCommitAck(transactionId string, senderInstanceId uint64, rece
 		TransactionId:      transactionId,
 		SenderInstanceId:   senderInstanceId,
 		ReceiverInstanceId: receiverInstanceId,
-		Timestamp:          time.Now().UnixNano(),
+		Timestamp:          Clock.Now(),
 		Valid:              valid,
 	}
 }
@@ -117,8 +119,13 @@ func (t *TransactionCommitAckHolder) Acks() []TransactionCommitAck {
 	return t.acks
 }
 
+// CountAcks returns how many instances acked, however many times each did
 func (t *TransactionCommitAckHolder) CountAcks() int {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	return len(t.acks)
+	senders := make(map[uint64]bool, len(t.acks))
+	for _, ack := range t.acks {
+		senders[ack.SenderInstanceId] = true
+	}
+	return len(senders)
 }
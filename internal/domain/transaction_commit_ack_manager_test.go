@@ -50,3 +50,16 @@ func TestTransactionCommitAckHolder_AddAndCount(t *testing.T) {
 	holder.Add(NewTransactionCommitAck("tx4", 1, 1, true))
 	assert.Equal(t, 1, holder.CountAcks())
 }
+
+func TestTransactionCommitAckManager_CountsEachInstanceOnce(t *testing.T) {
+	im := &DbInstanceManager{Replicas: &[]DbInstance{{Id: 1}, {Id: 2}}}
+	mgr := NewTransactionCommitAckManager(im)
+
+	// El origen recibe su propio ack además del que registró
+	mgr.Add(NewTransactionCommitAck("tx5", 1, 1, true))
+	mgr.Add(NewTransactionCommitAck("tx5", 1, 1, true))
+	assert.False(t, mgr.AckedByAllInstances("tx5"), "falta el voto del nodo 2")
+
+	mgr.Add(NewTransactionCommitAck("tx5", 2, 1, true))
+	assert.True(t, mgr.AckedByAllInstances("tx5"))
+}
@@ -1,11 +1,21 @@
 package domain
 
+import (
+	"errors"
+	"fmt"
+)
+
 type TransactionResult struct {
 	TransactionId string
 	ReadSet       map[string]DbEntry
 	WriteSet      map[string]DbEntry
 	DeleteSet     map[string]DbEntry
 	Success       bool
+	// ConditionFailed tells a failed condition apart from an abort by
+	// conflict resolution
+	ConditionFailed bool
+	// Rejection is why validation rejected the transaction, if it did
+	Rejection error
 }
 
 func FromTransaction(t Transaction) TransactionResult {
@@ -18,6 +28,30 @@ func FromTransaction(t Transaction) TransactionResult {
 	}
 }
 
+// FromRejection is the result of a transaction that failed validation with
+// err
+func FromRejection(t Transaction, err error) TransactionResult {
+	result := FromTransaction(t)
+	result.ConditionFailed = errors.Is(err, ErrConditionFailed)
+	result.Rejection = err
+	return result
+}
+
 func (t *TransactionResult) MarkAsSuccessful() {
 	t.Success = true
 }
+
+// Err returns nil for a committed transaction, ErrConditionFailed when a
+// condition did not hold, and ErrTransactionAborted otherwise, wrapping the
+// reason validation rejected it for, if any.
+func (t TransactionResult) Err() error {
+	switch {
+	case t.Success:
+		return nil
+	case t.ConditionFailed:
+		return ErrConditionFailed
+	case t.Rejection != nil:
+		return fmt.Errorf("%w: %w", ErrTransactionAborted, t.Rejection)
+	}
+	return ErrTransactionAborted
+}
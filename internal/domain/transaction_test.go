@@ -59,3 +59,32 @@ func TestTransaction_Timestamp(t *testing.T) {
 	now := time.Now().UnixNano()
 	assert.LessOrEqual(t, tx.Timestamp, now)
 }
+
+func TestConflictsWith_OnlyInTheSameNamespace(t *testing.T) {
+	entry := NewDbEntry("k", "v", false)
+	tx1 := TransactionFromWriteEntry(entry)
+	entry.SetNamespace("otro")
+	tx2 := TransactionFromWriteEntry(entry)
+	assert.False(t, tx1.ConflictsWith(tx2), "la misma clave en otro espacio no es conflicto")
+
+	tx3 := NewTransaction()
+	tx3.AddWriteEntry(NewDbEntry("k", "v2", false))
+	assert.True(t, tx1.ConflictsWith(tx3), "vacío equivale al espacio por defecto")
+}
+
+func TestTransaction_StampVersionsTheWrites(t *testing.T) {
+	tx := NewTransaction()
+	tx.AddWriteEntry(NewDbEntry("a", "v", false))
+	tx.AddWriteEntry(NewDbEntry("b", "v", false))
+	tx.AddReadEntry(NewDbEntry("c", "v", false))
+	timestamp := Clock.Now()
+
+	tx.Stamp(timestamp)
+
+	assert.Equal(t, timestamp, tx.Timestamp)
+	for _, entry := range tx.WriteSet {
+		assert.Equal(t, uint64(timestamp), entry.Version(), "cada nodo guarda la misma versión")
+	}
+	read := tx.ReadSet["c"]
+	assert.Zero(t, read.Version(), "las lecturas conservan la versión observada")
+}
@@ -1,18 +1,136 @@
 package zmq
 
+import (
+	"KVDB/internal/application/service"
+	"KVDB/internal/domain"
+	"time"
+)
+
+// ApiRequest is the first frame of a request. Binary keys and values, which
+// JSON strings cannot carry, are sent as a multipart message instead: the
+// key in the second frame and the value in the third, replacing Key and
+// Value. The reply is then multipart too, see ApiResponse.
 type ApiRequest struct {
 	Action string `json:"action,omitempty"`
 	Key    string `json:"key,omitempty"`
 	Value  string `json:"value,omitempty"`
+	// Namespace of the key, the default one when empty
+	Namespace string `json:"namespace,omitempty"`
+
+	// Binary is set for multipart requests
+	Binary   bool   `json:"-"`
+	RawKey   []byte `json:"-"`
+	RawValue []byte `json:"-"`
+
+	// SAVE: expiry as a TTL in seconds or as a deadline, which wins
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// SAVE and DELETE: only write if the stored entry meets it; DELETE only
+	// takes "version-equals"
+	Condition *ConditionRequest `json:"condition,omitempty"`
+
+	// SCAN
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Reverse bool   `json:"reverse,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	Cursor  string `json:"cursor,omitempty"`
+
+	// TX: keys read, written and deleted as one transaction. Its keys and
+	// values are base64, so they take any bytes without extra frames.
+	Reads   [][]byte     `json:"reads,omitempty"`
+	Puts    []PutRequest `json:"puts,omitempty"`
+	Deletes [][]byte     `json:"deletes,omitempty"`
+}
+
+// ConditionRequest is one of {"if": "absent"}, {"if": "value-equals",
+// "value": "..."} or {"if": "version-equals", "version": 42}
+type ConditionRequest struct {
+	If      string `json:"if"`
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+func (c *ConditionRequest) toCondition() *domain.Condition {
+	if c == nil {
+		return nil
+	}
+	return &domain.Condition{If: c.If, Value: []byte(c.Value), Version: c.Version}
+}
+
+type PutRequest struct {
+	Key       []byte     `json:"key"`
+	Value     []byte     `json:"value"`
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (r *ApiRequest) transactionCommand() service.ExecuteTransactionCommand {
+	command := service.ExecuteTransactionCommand{
+		Namespace: r.Namespace,
+		Reads:     r.Reads,
+		Deletes:   r.Deletes,
+	}
+	for _, put := range r.Puts {
+		p := service.TransactionPut{
+			Key:   put.Key,
+			Value: put.Value,
+			TTL:   time.Duration(put.TTL) * time.Second,
+		}
+		if put.ExpiresAt != nil {
+			p.ExpiresAt = *put.ExpiresAt
+		}
+		command.Puts = append(command.Puts, p)
+	}
+	return command
+}
+
+func (r *ApiRequest) key() []byte {
+	if r.Binary {
+		return r.RawKey
+	}
+	return []byte(r.Key)
 }
 
+func (r *ApiRequest) value() []byte {
+	if r.Binary {
+		return r.RawValue
+	}
+	return []byte(r.Value)
+}
+
+// ApiResponse is the first frame of a reply. Replies to multipart requests
+// leave the keys and values of their entries out of the JSON and send them
+// in the frames that follow, key then value for each entry: Entry first,
+// then every one of Entries. A TX reply sets Success when the transaction
+// committed, and otherwise puts in Error why: rejected without running, a
+// failed condition or an abort. A failed SAVE or DELETE puts in Error whether
+// its condition failed or it was aborted.
 type ApiResponse struct {
-	Entry   EntryResponse `json:"entry"`
-	Success bool          `json:"success,omitempty"`
+	Entry   EntryResponse   `json:"entry"`
+	Entries []EntryResponse `json:"entries,omitempty"`
+	Cursor  string          `json:"cursor,omitempty"`
+	Success bool            `json:"success,omitempty"`
+
+	TransactionId string         `json:"transaction_id,omitempty"`
+	Reads         []ReadResponse `json:"reads,omitempty"`
+	Error         string         `json:"error,omitempty"`
 }
 
 type EntryResponse struct {
-	Key       string `json:"key,omitempty"`
-	Value     string `json:"value,omitempty"`
-	Tombstone bool   `json:"tombstone,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	Tombstone bool       `json:"tombstone,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Version   uint64     `json:"version,omitempty"`
+}
+
+// ReadResponse carries the key and value base64, like the TX request
+type ReadResponse struct {
+	Key       []byte     `json:"key"`
+	Value     []byte     `json:"value,omitempty"`
+	Found     bool       `json:"found"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
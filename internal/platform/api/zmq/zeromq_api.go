@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"time"
 
 	"github.com/go-zeromq/zmq4"
 	json "github.com/json-iterator/go"
@@ -33,16 +34,21 @@ type Services struct {
 	get    *service.GetEntryService
 	set    *service.SaveEntryService
 	delete *service.DeleteEntryService
+	scan   *service.ScanEntriesService
+	tx     *service.ExecuteTransactionService
 }
 
 const (
 	SAVE   = "SAVE"
 	GET    = "GET"
 	DELETE = "DELETE"
+	SCAN   = "SCAN"
+	TX     = "TX"
 )
 
 func NewZmqApi(get *service.GetEntryService, set *service.SaveEntryService,
-	delete *service.DeleteEntryService, conf config.Config) *HighPerformanceZmqApi {
+	delete *service.DeleteEntryService, scan *service.ScanEntriesService,
+	tx *service.ExecuteTransactionService, conf config.Config) *HighPerformanceZmqApi {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -64,6 +70,8 @@ func NewZmqApi(get *service.GetEntryService, set *service.SaveEntryService,
 			get:    get,
 			set:    set,
 			delete: delete,
+			scan:   scan,
+			tx:     tx,
 		},
 		ctx:        ctx,
 		cancel:     cancel,
@@ -122,11 +130,18 @@ func (z *HighPerformanceZmqApi) socketListener(socketID int, socket zmq4.Socket)
 
 			// Parsear request
 			var req ApiRequest
-			if err := json.Unmarshal(msg.Bytes(), &req); err != nil {
+			if err := json.Unmarshal(msg.Frames[0], &req); err != nil {
 				log.Printf("Socket %d unmarshal error: %v", socketID, err)
 				z.sendErrorResponse(socket)
 				continue
 			}
+			if len(msg.Frames) > 1 {
+				req.Binary = true
+				req.RawKey = msg.Frames[1]
+				if len(msg.Frames) > 2 {
+					req.RawValue = msg.Frames[2]
+				}
+			}
 
 			// Crear job para worker pool
 			respChan := make(chan ApiResponse, 1)
@@ -140,7 +155,7 @@ func (z *HighPerformanceZmqApi) socketListener(socketID int, socket zmq4.Socket)
 			case z.workerPool <- job:
 				// Job enviado al pool, esperar respuesta
 				response := <-respChan
-				responseMsg := z.marshal(response)
+				responseMsg := z.marshal(response, req.Binary)
 				if err := socket.Send(responseMsg); err != nil {
 					log.Printf("Socket %d send error: %v", socketID, err)
 				}
@@ -149,7 +164,7 @@ func (z *HighPerformanceZmqApi) socketListener(socketID int, socket zmq4.Socket)
 			default:
 				// Pool lleno, procesar directamente
 				response := z.processRequest(&req)
-				responseMsg := z.marshal(response)
+				responseMsg := z.marshal(response, req.Binary)
 				if err := socket.Send(responseMsg); err != nil {
 					log.Printf("Socket %d send error: %v", socketID, err)
 				}
@@ -187,42 +202,123 @@ func (z *HighPerformanceZmqApi) workerRoutine(id int) {
 func (z *HighPerformanceZmqApi) processRequest(req *ApiRequest) ApiResponse {
 	switch req.Action {
 	case SAVE:
-		result := z.services.set.Execute(service.SaveEntryCommand{
-			Key:   req.Key,
-			Value: req.Value,
-		})
+		command := service.SaveEntryCommand{
+			Namespace: req.Namespace,
+			Key:       req.key(),
+			Value:     req.value(),
+			TTL:       time.Duration(req.TTL) * time.Second,
+			Condition: req.Condition.toCondition(),
+		}
+		if req.ExpiresAt != nil {
+			command.ExpiresAt = *req.ExpiresAt
+		}
+		result := z.services.set.Execute(command)
+		if result.Err != nil {
+			return ApiResponse{Success: false, Error: result.Err.Error()}
+		}
 		return ApiResponse{
 			Entry: EntryResponse{
+				Namespace: result.Entry.Namespace(),
 				Key:       result.Entry.Key(),
 				Value:     result.Entry.Value(),
 				Tombstone: result.Entry.Tombstone(),
+				ExpiresAt: result.Entry.Deadline(),
+				Version:   result.Entry.Version(),
 			},
 			Success: true,
 		}
 
 	case GET:
-		result := z.services.get.Execute(service.GetEntryQuery{Key: req.Key})
+		result := z.services.get.Execute(service.GetEntryQuery{Namespace: req.Namespace, Key: req.key()})
 		return ApiResponse{
 			Entry: EntryResponse{
+				Namespace: result.Entry.Namespace(),
 				Key:       result.Entry.Key(),
 				Value:     result.Entry.Value(),
 				Tombstone: result.Entry.Tombstone(),
+				ExpiresAt: result.Entry.Deadline(),
+				Version:   result.Entry.Version(),
 			},
 			Success: result.Found,
 		}
 
 	case DELETE:
 		// Corregido: usar req.Key, no req.Value
-		result := z.services.delete.Execute(service.DeleteEntryCommand{Key: req.Key})
+		result := z.services.delete.Execute(service.DeleteEntryCommand{
+			Namespace: req.Namespace,
+			Key:       req.key(),
+			Condition: req.Condition.toCondition(),
+		})
+		if req.Condition != nil && result.Err != nil {
+			return ApiResponse{Success: false, Error: result.Err.Error()}
+		}
 		return ApiResponse{
 			Entry: EntryResponse{
+				Namespace: result.Entry.Namespace(),
 				Key:       result.Entry.Key(),
 				Value:     result.Entry.Value(),
 				Tombstone: result.Entry.Tombstone(),
+				ExpiresAt: result.Entry.Deadline(),
+				Version:   result.Entry.Version(),
 			},
 			Success: result.Err == nil,
 		}
 
+	case SCAN:
+		result := z.services.scan.Execute(service.ScanEntriesQuery{
+			Namespace: req.Namespace,
+			Start:     req.Start,
+			End:       req.End,
+			Prefix:    req.Prefix,
+			Reverse:   req.Reverse,
+			Limit:     req.Limit,
+			Cursor:    req.Cursor,
+		})
+		entries := make([]EntryResponse, 0, len(result.Entries))
+		for _, entry := range result.Entries {
+			entries = append(entries, EntryResponse{
+				Namespace: entry.Namespace(),
+				Key:       entry.Key(),
+				Value:     entry.Value(),
+				Tombstone: entry.Tombstone(),
+				ExpiresAt: entry.Deadline(),
+				Version:   entry.Version(),
+			})
+		}
+		return ApiResponse{
+			Entries: entries,
+			Cursor:  result.Cursor,
+			Success: result.Err == nil,
+		}
+
+	case TX:
+		// Keys and values of a transaction travel base64 in the JSON frame
+		if req.Binary {
+			return ApiResponse{Error: "TX takes its keys and values base64 in the JSON frame"}
+		}
+		result := z.services.tx.Execute(req.transactionCommand())
+		if result.Err != nil {
+			return ApiResponse{Error: result.Err.Error()}
+		}
+		reads := make([]ReadResponse, 0, len(result.Reads))
+		for _, read := range result.Reads {
+			response := ReadResponse{Key: read.Key, Found: read.Found}
+			if read.Found {
+				response.Value = read.Entry.ValueBytes()
+				response.ExpiresAt = read.Entry.Deadline()
+			}
+			reads = append(reads, response)
+		}
+		response := ApiResponse{
+			TransactionId: result.TransactionId,
+			Reads:         reads,
+			Success:       result.Committed,
+		}
+		if result.Reason != nil {
+			response.Error = result.Reason.Error()
+		}
+		return response
+
 	default:
 		log.Printf("Unknown action: %s", req.Action)
 		return ApiResponse{Success: false}
@@ -233,20 +329,37 @@ func (z *HighPerformanceZmqApi) sendErrorResponse(socket zmq4.Socket) {
 	errorResponse := ApiResponse{
 		Success: false,
 	}
-	errorMsg := z.marshal(errorResponse)
+	errorMsg := z.marshal(errorResponse, false)
 	if err := socket.Send(errorMsg); err != nil {
 		log.Printf("Error sending error response: %v", err)
 	}
 }
 
-func (z *HighPerformanceZmqApi) marshal(response ApiResponse) zmq4.Msg {
+func (z *HighPerformanceZmqApi) marshal(response ApiResponse, binary bool) zmq4.Msg {
+	var frames [][]byte
+	if binary {
+		// Keys and values travel raw in the frames after the JSON one
+		frames = append(frames, nil, []byte(response.Entry.Key), []byte(response.Entry.Value))
+		response.Entry.Key, response.Entry.Value = "", ""
+		entries := make([]EntryResponse, len(response.Entries))
+		for i, entry := range response.Entries {
+			frames = append(frames, []byte(entry.Key), []byte(entry.Value))
+			entry.Key, entry.Value = "", ""
+			entries[i] = entry
+		}
+		response.Entries = entries
+	}
 	payload, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling response: %v", err)
 		// Fallback a respuesta de error simple
-		payload = []byte(`{"success":false}`)
+		return zmq4.NewMsg([]byte(`{"success":false}`))
+	}
+	if !binary {
+		return zmq4.NewMsg(payload)
 	}
-	return zmq4.NewMsg(payload)
+	frames[0] = payload
+	return zmq4.NewMsgFrom(frames...)
 }
 
 func (z *HighPerformanceZmqApi) Close() error {
@@ -4,12 +4,33 @@ import (
 	"flag"
 	"github.com/joho/godotenv"
 	"os"
+	"strconv"
+	"time"
 )
 
 const (
 	ReliableBroadcastAlgorithm = "rb"
 	EventualAlgorithm          = "ev"
 	AtomicBoAlgorithm          = "at"
+
+	defaultMemtableSizeThreshold = 4 * 1024 * 1024
+	defaultCompactionPolicy      = "leveled"
+	defaultCompactionRate        = 32 * 1024 * 1024
+	defaultBloomFalsePositive    = 0.01
+	defaultBlockCacheSize        = 64 * 1024 * 1024
+	defaultMaxOpenTables         = 512
+	defaultSSTCompression        = "lz4"
+	defaultValueLogThreshold     = 4 * 1024
+	defaultValueLogFileSize      = 64 * 1024 * 1024
+	defaultValueLogGCSecs        = 600
+	defaultValueLogDiscardRatio  = 0.5
+	defaultWalSyncMode           = "group"
+	defaultWalSyncIntervalMs     = 100
+	defaultWalGroupCommitMs      = 1
+	defaultWalMaxSegmentSize     = 64 * 1024 * 1024
+	defaultWalMaxSegmentAgeSecs  = 3600
+	defaultTxSessionIdleSecs     = 30
+	defaultTxSessionsPerClient   = 16
 )
 
 var portCmd = flag.Int("port", 3000, "HTTP server port")
@@ -26,6 +47,41 @@ type Config struct {
 	SequencerPubPort  int
 	DeploymentMode    string
 	Algorithm         string
+
+	MemtableSizeThreshold    int
+	CompactionPolicy         string
+	CompactionBytesPerSecond int64
+	BloomFalsePositiveRate   float64
+	// BlockCacheSize is the byte budget for decoded SSTable blocks and
+	// MaxOpenTables caps the open SSTable files; zero disables either cache
+	BlockCacheSize int64
+	MaxOpenTables  int
+	// SSTCompression is the codec for new SSTable blocks, "none" or "lz4"
+	SSTCompression string
+	// Values of at least ValueLogThreshold bytes are kept in the value log,
+	// the tree only holding a pointer to them; zero disables it
+	ValueLogThreshold    int
+	ValueLogFileSize     int64
+	ValueLogGCInterval   time.Duration
+	ValueLogDiscardRatio float64
+
+	// WalSyncMode is one of "always", "group" or "periodic"
+	WalSyncMode          string
+	WalSyncInterval      time.Duration
+	WalGroupCommitWindow time.Duration
+	WalMaxSegmentSize    int64
+	WalMaxSegmentAge     time.Duration
+	// WalArchiveDirectory keeps retired WAL segments when set
+	WalArchiveDirectory string
+
+	// RestoreCheckpoint is a checkpoint to boot from. The WAL directory must
+	// not hold any data yet.
+	RestoreCheckpoint string
+
+	// Interactive transactions left idle for TxSessionIdleTimeout are
+	// discarded, and a client holds at most TxSessionsPerClient at once
+	TxSessionIdleTimeout time.Duration
+	TxSessionsPerClient  int
 }
 
 func LoadConfig() Config {
@@ -40,5 +96,52 @@ func LoadConfig() Config {
 		ConfigServerUrl:   os.Getenv("CONFIG_SERVER_URL"),
 		DeploymentMode:    os.Getenv("DEPLOYMENT_MODE"),
 		Algorithm:         *algorithmCmd,
+
+		MemtableSizeThreshold:    getEnvInt("MEMTABLE_SIZE_THRESHOLD", defaultMemtableSizeThreshold),
+		CompactionPolicy:         getEnvString("COMPACTION_POLICY", defaultCompactionPolicy),
+		CompactionBytesPerSecond: int64(getEnvInt("COMPACTION_BYTES_PER_SECOND", defaultCompactionRate)),
+		BloomFalsePositiveRate:   getEnvFloat("BLOOM_FALSE_POSITIVE_RATE", defaultBloomFalsePositive),
+		BlockCacheSize:           int64(getEnvInt("BLOCK_CACHE_SIZE", defaultBlockCacheSize)),
+		MaxOpenTables:            getEnvInt("MAX_OPEN_TABLES", defaultMaxOpenTables),
+		SSTCompression:           getEnvString("SST_COMPRESSION", defaultSSTCompression),
+		ValueLogThreshold:        getEnvInt("VALUE_LOG_THRESHOLD", defaultValueLogThreshold),
+		ValueLogFileSize:         int64(getEnvInt("VALUE_LOG_FILE_SIZE", defaultValueLogFileSize)),
+		ValueLogGCInterval:       time.Duration(getEnvInt("VALUE_LOG_GC_INTERVAL_SECONDS", defaultValueLogGCSecs)) * time.Second,
+		ValueLogDiscardRatio:     getEnvFloat("VALUE_LOG_DISCARD_RATIO", defaultValueLogDiscardRatio),
+
+		WalSyncMode:          getEnvString("WAL_SYNC_MODE", defaultWalSyncMode),
+		WalSyncInterval:      time.Duration(getEnvInt("WAL_SYNC_INTERVAL_MS", defaultWalSyncIntervalMs)) * time.Millisecond,
+		WalGroupCommitWindow: time.Duration(getEnvInt("WAL_GROUP_COMMIT_WINDOW_MS", defaultWalGroupCommitMs)) * time.Millisecond,
+		WalMaxSegmentSize:    int64(getEnvInt("WAL_MAX_SEGMENT_SIZE", defaultWalMaxSegmentSize)),
+		WalMaxSegmentAge:     time.Duration(getEnvInt("WAL_MAX_SEGMENT_AGE_SECONDS", defaultWalMaxSegmentAgeSecs)) * time.Second,
+		WalArchiveDirectory:  os.Getenv("WAL_ARCHIVE_DIRECTORY"),
+
+		RestoreCheckpoint: os.Getenv("RESTORE_CHECKPOINT"),
+
+		TxSessionIdleTimeout: time.Duration(getEnvInt("TX_SESSION_IDLE_TIMEOUT_SECONDS", defaultTxSessionIdleSecs)) * time.Second,
+		TxSessionsPerClient:  getEnvInt("TX_SESSIONS_PER_CLIENT", defaultTxSessionsPerClient),
+	}
+}
+
+func getEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvFloat(name string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvString(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
 	}
+	return defaultValue
 }
@@ -8,6 +8,7 @@ type AckMessage struct {
 	ReceiverInstanceId uint64 `json:"receiver_instance_id,omitempty"`
 	Timestamp          int64  `json:"timestamp,omitempty"`
 	Valid              bool   `json:"valid,omitempty"`
+	ConditionFailed    bool   `json:"condition_failed,omitempty"`
 }
 
 func AckMessageFromCommitAck(ack domain.TransactionCommitAck) AckMessage {
@@ -17,6 +18,7 @@ func AckMessageFromCommitAck(ack domain.TransactionCommitAck) AckMessage {
 		ReceiverInstanceId: ack.ReceiverInstanceId,
 		Timestamp:          ack.Timestamp,
 		Valid:              ack.Valid,
+		ConditionFailed:    ack.ConditionFailed,
 	}
 }
 
@@ -27,5 +29,6 @@ func (a *AckMessage) ToCommitAck() domain.TransactionCommitAck {
 		ReceiverInstanceId: a.ReceiverInstanceId,
 		Timestamp:          a.Timestamp,
 		Valid:              a.Valid,
+		ConditionFailed:    a.ConditionFailed,
 	}
 }
@@ -2,30 +2,54 @@ package message
 
 import "KVDB/internal/domain"
 
+// TransactionMessage carries the sets of a transaction as lists: keys may
+// hold any bytes, and JSON object keys are strings that would mangle them.
 type TransactionMessage struct {
-	Id         string                    `json:"id"`
-	ReadSet    map[string]DbEntryMessage `json:"read_set"`
-	WriteSet   map[string]DbEntryMessage `json:"write_set"`
-	DeleteSet  map[string]DbEntryMessage `json:"delete_set"`
-	Timestamp  int64                     `json:"timestamp"`
-	InstanceId uint64                    `json:"instance_id"`
+	Id         string           `json:"id"`
+	ReadSet    []DbEntryMessage `json:"read_set"`
+	WriteSet   []DbEntryMessage `json:"write_set"`
+	DeleteSet  []DbEntryMessage `json:"delete_set"`
+	Timestamp  int64            `json:"timestamp"`
+	InstanceId uint64           `json:"instance_id"`
+	// Namespace holds every key of the transaction, empty for the default one
+	Namespace string `json:"namespace,omitempty"`
+	// Conditions are checked by every node where the commit order is decided
+	Conditions []ConditionMessage `json:"conditions,omitempty"`
 	Topic      string
 }
 
+// DbEntryMessage keeps keys and values as bytes, which JSON carries in
+// base64 and so round-trips exactly.
 type DbEntryMessage struct {
-	Key       string `json:"key,omitempty"`
-	Value     string `json:"value,omitempty"`
+	Key       []byte `json:"key,omitempty"`
+	Value     []byte `json:"value,omitempty"`
 	Tombstone bool   `json:"tombstone,omitempty"`
+	// ExpiresAt is the absolute deadline in Unix nanoseconds, so replicas
+	// never derive it from their own clocks
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// Version is the version written, or observed by a read
+	Version uint64 `json:"version,omitempty"`
+}
+
+// ConditionMessage is a condition on the stored entry of Key
+type ConditionMessage struct {
+	Key     []byte `json:"key"`
+	If      string `json:"if"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
 }
 
 func FromDbEntry(e domain.DbEntry) DbEntryMessage {
 	return DbEntryMessage{
-		e.Key(), e.Value(), e.Tombstone(),
+		e.KeyBytes(), e.ValueBytes(), e.Tombstone(), e.ExpiresAt(), e.Version(),
 	}
 }
 
 func (m DbEntryMessage) ToDbEntry() domain.DbEntry {
-	return domain.NewDbEntry(m.Key, m.Value, m.Tombstone)
+	entry := domain.NewDbEntryFromBytes(m.Key, m.Value, m.Tombstone)
+	entry.SetExpiresAt(m.ExpiresAt)
+	entry.SetVersion(m.Version)
+	return entry
 }
 
 func TransactionMessageFrom(transaction domain.Transaction) TransactionMessage {
@@ -36,21 +60,47 @@ func TransactionMessageFrom(transaction domain.Transaction) TransactionMessage {
 		DeleteSet:  mapFromDbEntrySet(transaction.DeleteSet),
 		Timestamp:  transaction.Timestamp,
 		InstanceId: transaction.InstanceId,
+		Namespace:  transaction.Namespace,
+		Conditions: mapFromConditions(transaction.Conditions),
+	}
+}
+
+func mapFromConditions(conditions map[string]domain.Condition) []ConditionMessage {
+	if len(conditions) == 0 {
+		return nil
+	}
+	result := make([]ConditionMessage, 0, len(conditions))
+	for key, c := range conditions {
+		result = append(result, ConditionMessage{[]byte(key), c.If, c.Value, c.Version})
 	}
+	return result
+}
+
+func mapToConditions(conditions []ConditionMessage) map[string]domain.Condition {
+	if len(conditions) == 0 {
+		return nil
+	}
+	result := make(map[string]domain.Condition, len(conditions))
+	for _, m := range conditions {
+		result[string(m.Key)] = domain.Condition{If: m.If, Value: m.Value, Version: m.Version}
+	}
+	return result
 }
 
-func mapFromDbEntrySet(set map[string]domain.DbEntry) map[string]DbEntryMessage {
-	result := make(map[string]DbEntryMessage)
-	for k, e := range set {
-		result[k] = FromDbEntry(e)
+func mapFromDbEntrySet(set map[string]domain.DbEntry) []DbEntryMessage {
+	result := make([]DbEntryMessage, 0, len(set))
+	for _, e := range set {
+		result = append(result, FromDbEntry(e))
 	}
 	return result
 }
 
-func mapToDbEntrySet(set map[string]DbEntryMessage) map[string]domain.DbEntry {
-	result := make(map[string]domain.DbEntry)
-	for k, e := range set {
-		result[k] = e.ToDbEntry()
+func mapToDbEntrySet(set []DbEntryMessage, namespace string) map[string]domain.DbEntry {
+	result := make(map[string]domain.DbEntry, len(set))
+	for _, m := range set {
+		entry := m.ToDbEntry()
+		entry.SetNamespace(namespace)
+		result[string(m.Key)] = entry
 	}
 	return result
 }
@@ -58,10 +108,12 @@ func mapToDbEntrySet(set map[string]DbEntryMessage) map[string]domain.DbEntry {
 func (t *TransactionMessage) ToTransaction() domain.Transaction {
 	return domain.Transaction{
 		Id:         t.Id,
-		ReadSet:    mapToDbEntrySet(t.ReadSet),
-		WriteSet:   mapToDbEntrySet(t.WriteSet),
-		DeleteSet:  mapToDbEntrySet(t.DeleteSet),
+		ReadSet:    mapToDbEntrySet(t.ReadSet, t.Namespace),
+		WriteSet:   mapToDbEntrySet(t.WriteSet, t.Namespace),
+		DeleteSet:  mapToDbEntrySet(t.DeleteSet, t.Namespace),
 		Timestamp:  t.Timestamp,
 		InstanceId: t.InstanceId,
+		Namespace:  t.Namespace,
+		Conditions: mapToConditions(t.Conditions),
 	}
 }
@@ -0,0 +1,99 @@
+package lsm_tree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+const defaultFalsePositiveRate = 0.01
+
+// BloomFilter answers whether a key may be in a table. A negative answer is
+// always right, so the table does not need to be read at all.
+type BloomFilter struct {
+	bits   []byte
+	hashes uint32
+}
+
+// NewBloomFilter sizes a filter for n keys with the given false positive rate.
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = defaultFalsePositiveRate
+	}
+	n = max(n, 1)
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return &BloomFilter{
+		bits:   make([]byte, (int(m)+7)/8),
+		hashes: uint32(max(k, 1)),
+	}
+}
+
+// locations derives every bit position from two hashes (Kirsch-Mitzenmacher).
+func (f *BloomFilter) locations(key string, fn func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	nbits := uint64(len(f.bits) * 8)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		fn((h1 + i*h2) % nbits)
+	}
+}
+
+func (f *BloomFilter) Add(key string) {
+	f.locations(key, func(bit uint64) {
+		f.bits[bit/8] |= 1 << (bit % 8)
+	})
+}
+
+func (f *BloomFilter) MayContain(key string) bool {
+	if len(f.bits) == 0 {
+		return true
+	}
+	contains := true
+	f.locations(key, func(bit uint64) {
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			contains = false
+		}
+	})
+	return contains
+}
+
+func (f *BloomFilter) encode() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, f.hashes)
+	return append(buf, f.bits...)
+}
+
+func decodeBloomFilter(buf []byte) (*BloomFilter, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("%w: filter block size %d", ErrCorruptedBlock, len(buf))
+	}
+	return &BloomFilter{
+		hashes: binary.LittleEndian.Uint32(buf),
+		bits:   buf[4:],
+	}, nil
+}
+
+// BloomFilterStats tells how often SSTable filters spared a table read.
+type BloomFilterStats struct {
+	Checks         int64 `json:"checks"`
+	SkippedReads   int64 `json:"skipped_reads"`
+	FalsePositives int64 `json:"false_positives"`
+}
+
+type filterCounters struct {
+	checks         atomic.Int64
+	skipped        atomic.Int64
+	falsePositives atomic.Int64
+}
+
+func (t *LsmTree) BloomFilterStats() BloomFilterStats {
+	return BloomFilterStats{
+		Checks:         t.filterStats.checks.Load(),
+		SkippedReads:   t.filterStats.skipped.Load(),
+		FalsePositives: t.filterStats.falsePositives.Load(),
+	}
+}
@@ -0,0 +1,37 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter_NoFalseNegatives(t *testing.T) {
+	filter := NewBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	decoded, err := decodeBloomFilter(filter.encode())
+	assert.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, decoded.MayContain(fmt.Sprintf("key-%d", i)))
+	}
+}
+
+func TestBloomFilter_FalsePositiveRate(t *testing.T) {
+	filter := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.MayContain(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+	// margen amplio sobre el 1% configurado
+	assert.Less(t, falsePositives, 300)
+}
@@ -0,0 +1,271 @@
+package lsm_tree
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const blockCacheShards = 16
+
+// CacheStats tells how well a cache serves reads. Size and Capacity are in
+// bytes for the block cache and in open files for the table cache.
+type CacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Entries  int64 `json:"entries"`
+	Size     int64 `json:"size"`
+	Capacity int64 `json:"capacity"`
+}
+
+// CachesStats groups the stats of the block and table caches.
+type CachesStats struct {
+	BlockCache CacheStats `json:"block_cache"`
+	TableCache CacheStats `json:"table_cache"`
+}
+
+// blockKey identifies a data block by the table it belongs to and its offset.
+type blockKey struct {
+	table  uint64
+	offset uint64
+}
+
+// BlockCache keeps decoded data blocks within a byte budget, charged by the
+// encoded size of each block. It is split into shards, each with its own
+// lock and LRU list, so concurrent readers rarely contend.
+type BlockCache struct {
+	shards   [blockCacheShards]blockCacheShard
+	capacity int64
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+type blockCacheShard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      list.List // front is the most recently used
+	blocks   map[blockKey]*list.Element
+}
+
+type cachedBlock struct {
+	key   blockKey
+	block DataBlock
+	size  int64
+}
+
+// NewBlockCache returns a cache holding up to capacity bytes of blocks, or
+// nil, which caches nothing, when capacity is not positive.
+func NewBlockCache(capacity int64) *BlockCache {
+	if capacity <= 0 {
+		return nil
+	}
+	c := &BlockCache{capacity: capacity}
+	for i := range c.shards {
+		c.shards[i].capacity = max(capacity/blockCacheShards, 1)
+		c.shards[i].blocks = make(map[blockKey]*list.Element)
+	}
+	return c
+}
+
+func (c *BlockCache) shard(key blockKey) *blockCacheShard {
+	h := key.table*0x9e3779b97f4a7c15 ^ key.offset
+	return &c.shards[h%blockCacheShards]
+}
+
+// get returns a cached block. Blocks are shared between readers and must not
+// be modified.
+func (c *BlockCache) get(key blockKey) (DataBlock, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	elem, ok := s.blocks[key]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	s.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return DataBlock{}, false
+	}
+	c.hits.Add(1)
+	return elem.Value.(*cachedBlock).block, true
+}
+
+func (c *BlockCache) put(key blockKey, block DataBlock, size int64) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > s.capacity {
+		return
+	}
+	if _, ok := s.blocks[key]; ok {
+		return
+	}
+	s.blocks[key] = s.lru.PushFront(&cachedBlock{key: key, block: block, size: size})
+	s.size += size
+	for s.size > s.capacity {
+		oldest := s.lru.Back()
+		evicted := s.lru.Remove(oldest).(*cachedBlock)
+		delete(s.blocks, evicted.key)
+		s.size -= evicted.size
+	}
+}
+
+func (c *BlockCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	stats := CacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Capacity: c.capacity,
+	}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		stats.Entries += int64(len(s.blocks))
+		stats.Size += s.size
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// TableCache bounds how many SSTable files are open at once. Handles are
+// opened on demand and the least recently used idle one is closed once the
+// limit is reached. Handles in use are never closed, so the limit can be
+// exceeded briefly under heavy concurrency.
+type TableCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      list.List // idle and busy handles, front is the most recent
+	handles  map[string]*list.Element
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+type tableHandle struct {
+	path string
+	fd   *os.File
+	refs int
+	// evicted handles are closed as soon as their last user lets go
+	evicted bool
+}
+
+// NewTableCache returns a cache keeping up to capacity files open, or nil,
+// which leaves every table with its own descriptor, when capacity is not
+// positive.
+func NewTableCache(capacity int) *TableCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &TableCache{
+		capacity: capacity,
+		handles:  make(map[string]*list.Element),
+	}
+}
+
+// acquire returns an open handle for path, which must be given back with
+// release.
+func (c *TableCache) acquire(path string) (*tableHandle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.handles[path]; ok {
+		c.hits.Add(1)
+		c.lru.MoveToFront(elem)
+		h := elem.Value.(*tableHandle)
+		h.refs++
+		return h, nil
+	}
+	c.misses.Add(1)
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &tableHandle{path: path, fd: fd, refs: 1}
+	c.handles[path] = c.lru.PushFront(h)
+	c.shrink()
+	return h, nil
+}
+
+func (c *TableCache) release(h *tableHandle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h.refs--
+	if h.refs == 0 && h.evicted {
+		h.fd.Close()
+	}
+}
+
+// evict closes the handle of a table that is going away.
+func (c *TableCache) evict(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.handles[path]; ok {
+		c.remove(elem)
+	}
+}
+
+// shrink closes idle handles, oldest first, while over capacity. Must be
+// called with mu held.
+func (c *TableCache) shrink() {
+	for elem := c.lru.Back(); elem != nil && len(c.handles) > c.capacity; {
+		prev := elem.Prev()
+		if elem.Value.(*tableHandle).refs == 0 {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+func (c *TableCache) remove(elem *list.Element) {
+	h := c.lru.Remove(elem).(*tableHandle)
+	delete(c.handles, h.path)
+	h.evicted = true
+	if h.refs == 0 {
+		h.fd.Close()
+	}
+}
+
+// Close closes every handle. Handles still in use are closed on release.
+func (c *TableCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Front())
+	}
+}
+
+func (c *TableCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  int64(len(c.handles)),
+		Size:     int64(len(c.handles)),
+		Capacity: int64(c.capacity),
+	}
+}
+
+// readAt reads from the table file through the cache.
+func (c *TableCache) readAt(path string, buf []byte, offset int64) error {
+	h, err := c.acquire(path)
+	if err != nil {
+		return fmt.Errorf("opening sstable %s: %w", path, err)
+	}
+	defer c.release(h)
+	_, err = h.fd.ReadAt(buf, offset)
+	return err
+}
+
+func (t *LsmTree) CacheStats() CachesStats {
+	return CachesStats{
+		BlockCache: t.readerOptions.BlockCache.Stats(),
+		TableCache: t.readerOptions.TableCache.Stats(),
+	}
+}
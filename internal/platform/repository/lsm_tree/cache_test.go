@@ -0,0 +1,94 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewBlockCache(blockCacheShards * 100)
+	// misma tabla y desplazamientos que caen en el mismo shard
+	keys := make([]blockKey, 0, 3)
+	for offset := uint64(0); len(keys) < 3; offset++ {
+		key := blockKey{table: 1, offset: offset}
+		if cache.shard(key) == cache.shard(blockKey{table: 1, offset: 0}) {
+			keys = append(keys, key)
+		}
+	}
+
+	cache.put(keys[0], DataBlock{}, 40)
+	cache.put(keys[1], DataBlock{}, 40)
+	_, ok := cache.get(keys[0])
+	assert.True(t, ok)
+	cache.put(keys[2], DataBlock{}, 40)
+
+	_, ok = cache.get(keys[1])
+	assert.False(t, ok, "el bloque menos usado debe salir al superar el presupuesto")
+	_, ok = cache.get(keys[0])
+	assert.True(t, ok)
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(80), stats.Size)
+}
+
+func TestTableCache_BoundsOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewTableCache(2)
+	t.Cleanup(cache.Close)
+	opts := ReaderOptions{TableCache: cache}
+
+	var tables []*SSTableReader
+	for i := 0; i < 4; i++ {
+		file := path.Join(dir, fmt.Sprintf("%d.sst", i))
+		_, err := NewSSTableWriter(SSTableOptions{}).Write(file, 0, []DbEntry{NewDbEntry(fmt.Sprintf("k%d", i), "v", false)})
+		assert.NoError(t, err)
+		table, err := OpenSSTableWith(file, opts)
+		assert.NoError(t, err)
+		tables = append(tables, table)
+	}
+	for i, table := range tables {
+		got, found, err := table.Get(fmt.Sprintf("k%d", i))
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "v", got.Value())
+		assert.LessOrEqual(t, cache.Stats().Entries, int64(2), "no debe haber más archivos abiertos que el límite")
+	}
+
+	for _, table := range tables {
+		assert.NoError(t, table.Close())
+	}
+	assert.Equal(t, int64(0), cache.Stats().Entries, "cerrar una tabla libera su descriptor")
+}
+
+func TestLsmTree_BlockCacheServesHotKeys(t *testing.T) {
+	tree := openTree(t, config.Config{
+		WalDirectory:          t.TempDir(),
+		MemtableSizeThreshold: 1024,
+		BlockCacheSize:        1024 * 1024,
+		MaxOpenTables:         4,
+	})
+	for i := 0; i < 100; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "some value", false))
+	}
+	waitForFlush(t, tree)
+
+	tree.mu.RLock()
+	key := defaultLevels(tree)[0][len(defaultLevels(tree)[0])-1].FirstKey()
+	tree.mu.RUnlock()
+	for i := 0; i < 10; i++ {
+		_, found := tree.Get(key)
+		assert.True(t, found)
+	}
+
+	stats := tree.CacheStats()
+	assert.GreaterOrEqual(t, stats.BlockCache.Hits, int64(9), "las lecturas repetidas deben salir de la caché")
+	assert.Greater(t, stats.TableCache.Hits+stats.TableCache.Misses, int64(0))
+	assert.LessOrEqual(t, stats.TableCache.Entries, int64(4))
+}
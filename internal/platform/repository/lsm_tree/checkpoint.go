@@ -0,0 +1,343 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const checkpointManifest = "checkpoint.json"
+
+var (
+	ErrDirectoryNotEmpty = errors.New("directory is not empty")
+	ErrNotACheckpoint    = errors.New("not a checkpoint")
+)
+
+// CheckpointInfo describes a checkpoint. It is stored in the checkpoint as
+// its manifest.
+type CheckpointInfo struct {
+	// Sequence is the sequence number of the last write the checkpoint holds
+	Sequence  uint64    `json:"sequence"`
+	CreatedAt time.Time `json:"created_at"`
+	Tables    []string  `json:"tables"`
+	ValueLogs []string  `json:"value_logs"`
+	// Namespaces holds the settings of the namespaces other than the
+	// default one, and TableNamespaces the namespace of each of their tables
+	Namespaces      []Namespace       `json:"namespaces,omitempty"`
+	TableNamespaces map[string]string `json:"table_namespaces,omitempty"`
+}
+
+// Checkpoint writes a consistent copy of the tree to dir, which must be
+// empty or not exist. The active memtable is flushed first, so the copy only
+// holds SSTables and value log files, hard-linked when dir is on the same
+// filesystem. Writes are served meanwhile; those arriving once the flush has
+// started may be left out.
+func (t *LsmTree) Checkpoint(dir string) (CheckpointInfo, error) {
+	if err := makeEmptyDir(dir); err != nil {
+		return CheckpointInfo{}, err
+	}
+	// No value log file may be deleted while it is being copied
+	t.gcMu.Lock()
+	defer t.gcMu.Unlock()
+
+	if err := t.flushActive(); err != nil {
+		return CheckpointInfo{}, err
+	}
+	info := CheckpointInfo{CreatedAt: time.Now().UTC()}
+	if err := t.linkTables(dir, &info); err != nil {
+		return CheckpointInfo{}, err
+	}
+	logs, err := t.values.checkpoint(dir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	info.ValueLogs = logs
+	if err := writeCheckpointManifest(dir, info); err != nil {
+		return CheckpointInfo{}, err
+	}
+	return info, nil
+}
+
+// flushActive freezes the active memtable and waits until it, and every
+// memtable frozen before it, is in an SSTable. It gives up on the first
+// flush that fails.
+func (t *LsmTree) flushActive() error {
+	t.mu.Lock()
+	if t.active.Size() > 0 {
+		if t.freeze(t.active) == nil {
+			t.mu.Unlock()
+			return errors.New("could not freeze the active memtable")
+		}
+	}
+	var newest *Memtable
+	if len(t.immutables) > 0 {
+		newest = t.immutables[0]
+	}
+	failed := t.flushFailed
+	t.mu.Unlock()
+
+	if newest == nil {
+		return nil
+	}
+	t.wakeFlusher()
+	// Memtables are flushed in the order they were frozen
+	select {
+	case <-newest.flushed:
+		return nil
+	case <-failed:
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		for i := len(t.immutables) - 1; i >= 0; i-- {
+			if err := t.immutables[i].flushErr; err != nil {
+				return err
+			}
+		}
+		return errors.New("flush failed")
+	}
+}
+
+// linkTables links every live table into dir. The read lock is held until
+// they are all linked, as compactions delete their inputs once installed.
+func (t *LsmTree) linkTables(dir string, info *CheckpointInfo) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, ns := range t.sortedNamespaces() {
+		namespace := storedNamespace(ns.settings.Name)
+		if namespace != "" {
+			info.Namespaces = append(info.Namespaces, ns.settings)
+		}
+		for _, tables := range ns.levels {
+			for _, table := range tables {
+				name := path.Base(table.Path())
+				if err := linkOrCopy(table.Path(), path.Join(dir, name)); err != nil {
+					return err
+				}
+				info.Tables = append(info.Tables, name)
+				info.Sequence = max(info.Sequence, table.MaxSeq())
+				if namespace != "" {
+					if info.TableNamespaces == nil {
+						info.TableNamespaces = make(map[string]string)
+					}
+					info.TableNamespaces[name] = namespace
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkpoint links the sealed files into dir and copies what the active one
+// holds so far, returning the names of the files.
+func (v *ValueLog) checkpoint(dir string) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	v.mu.RLock()
+	active, activeNumber, activeSize := v.active, v.activeNumber, v.activeSize
+	v.mu.RUnlock()
+
+	var names []string
+	for _, number := range v.sealed() {
+		if active != nil && number == activeNumber {
+			// Sealed since, but only what was written before is needed
+			continue
+		}
+		name := path.Base(vlogPath(v.dir, number))
+		if err := linkOrCopy(vlogPath(v.dir, number), path.Join(dir, name)); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if active == nil {
+		return names, nil
+	}
+	// Still appended to, only the records written so far are copied
+	name := path.Base(vlogPath(v.dir, activeNumber))
+	src, err := os.Open(vlogPath(v.dir, activeNumber))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	if err := copyFile(io.NewSectionReader(src, 0, activeSize), path.Join(dir, name)); err != nil {
+		return nil, err
+	}
+	return append(names, name), nil
+}
+
+// RestoreCheckpoint puts the checkpoint found in dir in dataDir, which must
+// not hold any data yet, so a tree opened on dataDir starts from it.
+func RestoreCheckpoint(dir, dataDir string) (CheckpointInfo, error) {
+	info, err := ReadCheckpoint(dir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return CheckpointInfo{}, err
+	}
+	segments, err := ListWalSegments(dataDir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	tables, err := ListSSTables(dataDir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	logs, err := listValueLogFiles(dataDir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	if len(segments)+len(tables)+len(logs) > 0 {
+		return CheckpointInfo{}, fmt.Errorf("restoring into %s: %w", dataDir, ErrDirectoryNotEmpty)
+	}
+
+	for _, name := range append(info.Tables, info.ValueLogs...) {
+		if err := linkOrCopy(path.Join(dir, name), path.Join(dataDir, name)); err != nil {
+			return CheckpointInfo{}, err
+		}
+	}
+	// A manifest left by a node that never stored anything would not list
+	// the restored tables, they would be removed on boot
+	if err := restoreManifest(dataDir, info); err != nil {
+		return CheckpointInfo{}, err
+	}
+	return info, nil
+}
+
+// restoreManifest writes a manifest holding the namespaces and tables of a
+// restored checkpoint, each table at the level its header records.
+func restoreManifest(dataDir string, info CheckpointInfo) error {
+	_, number, err := readManifest(dataDir)
+	if err != nil {
+		return err
+	}
+	state := newVersionState()
+	for _, settings := range info.Namespaces {
+		state.namespaces[settings.Name] = namespaceInfo{settings: settings}
+	}
+	for _, name := range info.Tables {
+		table, err := OpenSSTable(path.Join(dataDir, name))
+		if err != nil {
+			return err
+		}
+		state.tables[name] = table.Level()
+		table.Close()
+		if namespace, ok := info.TableNamespaces[name]; ok {
+			state.tableNamespaces[name] = namespace
+		}
+	}
+	fd, _, err := writeManifest(dataDir, number+1, state)
+	if err != nil {
+		return err
+	}
+	fd.Close()
+	return removeOldManifests(dataDir, number+1)
+}
+
+// ReadCheckpoint reads the manifest of the checkpoint in dir and checks that
+// every file it lists is there.
+func ReadCheckpoint(dir string) (CheckpointInfo, error) {
+	buf, err := os.ReadFile(path.Join(dir, checkpointManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return CheckpointInfo{}, fmt.Errorf("%s: %w, %s is missing", dir, ErrNotACheckpoint, checkpointManifest)
+	}
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	var info CheckpointInfo
+	if err := json.Unmarshal(buf, &info); err != nil {
+		return CheckpointInfo{}, fmt.Errorf("%s: %w: %v", dir, ErrNotACheckpoint, err)
+	}
+	for _, name := range append(info.Tables, info.ValueLogs...) {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			return CheckpointInfo{}, fmt.Errorf("%s: %w: %v", dir, ErrNotACheckpoint, err)
+		}
+	}
+	return info, nil
+}
+
+// writeCheckpointManifest is written last, through a rename, so a checkpoint
+// cut short has no manifest and cannot be restored.
+func writeCheckpointManifest(dir string, info CheckpointInfo) error {
+	buf, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, checkpointManifest+".tmp")
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(buf); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(dir, checkpointManifest)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func makeEmptyDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("checkpoint into %s: %w", dir, ErrDirectoryNotEmpty)
+	}
+	return nil
+}
+
+// linkOrCopy hard-links src to dst, copying it when they are on different
+// filesystems. Only files that are no longer written to may be linked.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	fd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return copyFile(fd, dst)
+}
+
+func copyFile(src io.Reader, dst string) error {
+	fd, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, src); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
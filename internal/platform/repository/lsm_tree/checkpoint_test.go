@@ -0,0 +1,73 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, MemtableSizeThreshold: 4096, ValueLogThreshold: 100})
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%02d", i), fmt.Sprintf("value-%d", i), false))
+	}
+	tree.Set(NewDbEntry("grande", bigValue(1), false))
+	tree.Set(NewDbEntry("key-00", "", true))
+	waitForFlush(t, tree)
+
+	target := path.Join(t.TempDir(), "checkpoint")
+	info, err := tree.Checkpoint(target)
+	assert.NoError(t, err)
+	assert.Equal(t, tree.sequence.Load(), info.Sequence, "el checkpoint debe registrar la última escritura")
+	assert.NotEmpty(t, info.Tables)
+	assert.NotEmpty(t, info.ValueLogs)
+
+	// Lo escrito después no forma parte del checkpoint
+	tree.Set(NewDbEntry("key-01", "después", false))
+	tree.Set(NewDbEntry("nueva", "después", false))
+
+	_, err = tree.Checkpoint(target)
+	assert.ErrorIs(t, err, ErrDirectoryNotEmpty)
+
+	restoredDir := t.TempDir()
+	restoredInfo, err := RestoreCheckpoint(target, restoredDir)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Sequence, restoredInfo.Sequence)
+	// El checkpoint se restaura solo, sin el directorio original
+	assert.NoError(t, tree.Close())
+	assert.NoError(t, os.RemoveAll(dir))
+
+	restored := openTree(t, config.Config{WalDirectory: restoredDir})
+	assert.Equal(t, info.Sequence, restored.sequence.Load())
+	for i := 1; i < 50; i++ {
+		got, found := restored.Get(fmt.Sprintf("key-%02d", i))
+		assert.True(t, found)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), got.Value())
+	}
+	_, found := restored.Get("key-00")
+	assert.False(t, found, "los borrados también se restauran")
+	_, found = restored.Get("nueva")
+	assert.False(t, found)
+	got, _ := restored.Get("grande")
+	assert.Equal(t, bigValue(1), got.Value())
+
+	_, err = RestoreCheckpoint(target, restoredDir)
+	assert.ErrorIs(t, err, ErrDirectoryNotEmpty, "no se restaura sobre datos existentes")
+}
+
+func TestRestoreCheckpoint_RejectsIncompleteCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	_, err := RestoreCheckpoint(dir, t.TempDir())
+	assert.ErrorIs(t, err, ErrNotACheckpoint)
+
+	assert.NoError(t, writeCheckpointManifest(dir, CheckpointInfo{Tables: []string{"sst-0000000001.sst"}}))
+	_, err = RestoreCheckpoint(dir, t.TempDir())
+	assert.ErrorIs(t, err, ErrNotACheckpoint, "faltan archivos del manifiesto")
+}
@@ -0,0 +1,577 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SizeTieredCompaction = "size-tiered"
+	LeveledCompaction    = "leveled"
+
+	compactionTrigger        = 4
+	compactionTargetFileSize = 2 * 1024 * 1024
+	leveledBaseLevelSize     = 10 * 1024 * 1024
+	leveledLevelMultiplier   = 10
+)
+
+// compaction describes a merge picked by a CompactionPolicy within a
+// namespace. Inputs are ordered newest first, so the first version seen of a
+// key wins.
+type compaction struct {
+	ns          *namespace
+	inputs      []*SSTableReader
+	outputLevel int
+}
+
+type CompactionPolicy interface {
+	// Pick returns the next compaction to run, or nil if none is needed.
+	Pick(levels [][]*SSTableReader) *compaction
+	// Overlapping reports whether tables of a level may share keys, in
+	// which case they are kept newest first instead of sorted by key.
+	Overlapping(level int) bool
+}
+
+func NewCompactionPolicy(name string) (CompactionPolicy, error) {
+	switch name {
+	case SizeTieredCompaction:
+		return &SizeTieredPolicy{threshold: compactionTrigger}, nil
+	case LeveledCompaction:
+		return &LeveledPolicy{
+			l0Trigger:     compactionTrigger,
+			baseLevelSize: leveledBaseLevelSize,
+			multiplier:    leveledLevelMultiplier,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown compaction policy: %s", name)
+}
+
+// SizeTieredPolicy uses each level as a tier of similar sized tables. Once a
+// tier holds enough tables they are merged into one bigger table placed in
+// the next tier.
+type SizeTieredPolicy struct {
+	threshold int
+}
+
+func (p *SizeTieredPolicy) Pick(levels [][]*SSTableReader) *compaction {
+	for level := 0; level < len(levels)-1; level++ {
+		if len(levels[level]) >= p.threshold {
+			return &compaction{
+				inputs:      append([]*SSTableReader(nil), levels[level]...),
+				outputLevel: level + 1,
+			}
+		}
+	}
+	return nil
+}
+
+func (p *SizeTieredPolicy) Overlapping(level int) bool {
+	return true
+}
+
+// LeveledPolicy keeps every level below 0 sorted and non overlapping, each
+// one multiplier times bigger than the previous. The level that exceeds its
+// budget the most is merged into the tables it overlaps in the next level.
+type LeveledPolicy struct {
+	l0Trigger     int
+	baseLevelSize int64
+	multiplier    int64
+	// next table to compact for each level, so work rotates over the range
+	cursors [maxLevels]int
+}
+
+func (p *LeveledPolicy) Pick(levels [][]*SSTableReader) *compaction {
+	best, bestScore := -1, 1.0
+	for level := 0; level < len(levels)-1; level++ {
+		if score := p.score(levels, level); score >= bestScore {
+			best, bestScore = level, score
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	var inputs []*SSTableReader
+	if best == 0 {
+		inputs = append(inputs, levels[0]...)
+	} else {
+		i := p.cursors[best] % len(levels[best])
+		p.cursors[best] = i + 1
+		inputs = append(inputs, levels[best][i])
+	}
+	first, last := keyRange(inputs)
+	for _, table := range levels[best+1] {
+		if table.FirstKey() <= last && table.LastKey() >= first {
+			inputs = append(inputs, table)
+		}
+	}
+	return &compaction{inputs: inputs, outputLevel: best + 1}
+}
+
+func (p *LeveledPolicy) score(levels [][]*SSTableReader, level int) float64 {
+	if level == 0 {
+		return float64(len(levels[0])) / float64(p.l0Trigger)
+	}
+	budget := p.baseLevelSize
+	for i := 1; i < level; i++ {
+		budget *= p.multiplier
+	}
+	return float64(levelSize(levels[level])) / float64(budget)
+}
+
+func (p *LeveledPolicy) Overlapping(level int) bool {
+	return level == 0
+}
+
+func keyRange(tables []*SSTableReader) (string, string) {
+	first, last := tables[0].FirstKey(), tables[0].LastKey()
+	for _, table := range tables[1:] {
+		first = min(first, table.FirstKey())
+		last = max(last, table.LastKey())
+	}
+	return first, last
+}
+
+func levelSize(tables []*SSTableReader) int64 {
+	var size int64
+	for _, table := range tables {
+		size += table.Size()
+	}
+	return size
+}
+
+// CompactionStats is a snapshot of the work done by the compactor and of the
+// current shape of the tree.
+type CompactionStats struct {
+	Policy        string  `json:"policy"`
+	Compactions   int64   `json:"compactions"`
+	BytesRead     int64   `json:"bytes_read"`
+	BytesWritten  int64   `json:"bytes_written"`
+	FilesPerLevel []int   `json:"files_per_level"`
+	BytesPerLevel []int64 `json:"bytes_per_level"`
+}
+
+type compactionCounters struct {
+	compactions  atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+// CompactionStats sums the levels of every namespace.
+func (t *LsmTree) CompactionStats() CompactionStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := CompactionStats{
+		Policy:        t.policyName,
+		Compactions:   t.counters.compactions.Load(),
+		BytesRead:     t.counters.bytesRead.Load(),
+		BytesWritten:  t.counters.bytesWritten.Load(),
+		FilesPerLevel: make([]int, maxLevels),
+		BytesPerLevel: make([]int64, maxLevels),
+	}
+	for _, ns := range t.namespaces {
+		for level, tables := range ns.levels {
+			stats.FilesPerLevel[level] += len(tables)
+			stats.BytesPerLevel[level] += levelSize(tables)
+		}
+	}
+	return stats
+}
+
+// rateLimiter throttles compaction I/O to a number of bytes per second so
+// it does not take the disk away from foreground writes.
+type rateLimiter struct {
+	bytesPerSec int64
+	start       time.Time
+	total       int64
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+func (r *rateLimiter) wait(n int64) {
+	if r.bytesPerSec <= 0 {
+		return
+	}
+	r.total += n
+	expected := time.Duration(float64(r.total) / float64(r.bytesPerSec) * float64(time.Second))
+	if elapsed := time.Since(r.start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+func (t *LsmTree) startCompactor() {
+	t.compactCh = make(chan struct{}, 1)
+	t.compactWg.Add(1)
+	go func() {
+		defer t.compactWg.Done()
+		for range t.compactCh {
+			for {
+				t.mu.RLock()
+				c := t.pick()
+				t.mu.RUnlock()
+				if c == nil {
+					break
+				}
+				if err := t.compact(c); err != nil {
+					t.logger.Printf("compaction into level %d failed: %v", c.outputLevel, err)
+					break
+				}
+			}
+		}
+	}()
+}
+
+// pick returns the next compaction to run over the namespaces, taken in name
+// order. Rewrites only come once no policy has anything else to do. Must be
+// called with mu held.
+func (t *LsmTree) pick() *compaction {
+	namespaces := t.sortedNamespaces()
+	for _, ns := range namespaces {
+		if c := ns.policy.Pick(ns.levels); c != nil {
+			c.ns = ns
+			return c
+		}
+	}
+	for _, ns := range namespaces {
+		if c := ns.pickRewrite(); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// pickRewrite returns a compaction that rewrites, on its own level, a table
+// written with another codec than the namespace's, once the policy has
+// nothing else to do. Only levels whose tables do not overlap qualify, as
+// elsewhere the position of a table follows from its age. Must be called
+// with the tree's mu held.
+func (ns *namespace) pickRewrite() *compaction {
+	for level := 1; level < len(ns.levels); level++ {
+		if ns.policy.Overlapping(level) {
+			continue
+		}
+		for _, table := range ns.levels[level] {
+			if table.Codec() != ns.writer.codec {
+				return &compaction{ns: ns, inputs: []*SSTableReader{table}, outputLevel: level}
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleCompaction wakes the compactor up without blocking the caller.
+func (t *LsmTree) scheduleCompaction() {
+	if t.compactCh == nil {
+		return
+	}
+	select {
+	case t.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+func (t *LsmTree) compact(c *compaction) error {
+	c.ns.compactMu.Lock()
+	defer c.ns.compactMu.Unlock()
+	t.mu.RLock()
+	dropped := c.ns.dropped
+	t.mu.RUnlock()
+	if dropped {
+		return nil
+	}
+	limiter := newRateLimiter(t.compactionRate)
+	merged := newMergeIterator(c.inputs)
+	canDrop := t.tombstoneDropper(c)
+	snapshots := t.snapshots.sequences()
+	now := t.now()
+
+	var outputs []*SSTableReader
+	var pending []DbEntry
+	pendingSize := 0
+	writeOutput := func() error {
+		path := sstPath(t.dir, formatVersion(t.nextFileNumber()))
+		if _, err := c.ns.writer.Write(path, c.outputLevel, pending); err != nil {
+			return err
+		}
+		table, err := t.openTable(path)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, table)
+		t.counters.bytesWritten.Add(table.Size())
+		limiter.wait(table.Size())
+		pending, pendingSize = nil, 0
+		return nil
+	}
+
+	var versions []DbEntry
+	emit := func() error {
+		kept := retainVersions(versions, snapshots)
+		for i, entry := range kept {
+			if !entry.Tombstone() && entry.Expired(now) {
+				// Expired for good, it only matters as a deletion now
+				kept[i] = NewDbEntry(entry.Key(), "", true)
+				kept[i].SetSeq(entry.Seq())
+				kept[i].SetNamespace(entry.Namespace())
+			}
+		}
+		// A tombstone is only needed while something older could show up
+		// behind it, for the latest reads as well as for snapshots.
+		for len(kept) > 0 && kept[len(kept)-1].Tombstone() && canDrop(kept[0].Key()) {
+			kept = kept[:len(kept)-1]
+		}
+		for _, entry := range kept {
+			pending = append(pending, entry)
+			pendingSize += encodedEntrySize(entry)
+		}
+		versions = versions[:0]
+		// The versions of a key stay in one table, so lookups find them
+		// all in the same place.
+		if pendingSize >= compactionTargetFileSize {
+			return writeOutput()
+		}
+		return nil
+	}
+
+	for {
+		entry, ok := merged.Next()
+		if !ok {
+			break
+		}
+		if len(versions) > 0 && entry.Key() != versions[0].Key() {
+			if err := emit(); err != nil {
+				return t.discard(outputs, err)
+			}
+		}
+		versions = append(versions, entry)
+	}
+	if len(versions) > 0 {
+		if err := emit(); err != nil {
+			return t.discard(outputs, err)
+		}
+	}
+	if err := merged.Err(); err != nil {
+		return t.discard(outputs, err)
+	}
+	if len(pending) > 0 {
+		if err := writeOutput(); err != nil {
+			return t.discard(outputs, err)
+		}
+	}
+	var read int64
+	for _, input := range c.inputs {
+		read += input.Size()
+	}
+	t.counters.bytesRead.Add(read)
+	limiter.wait(read)
+
+	edit := versionEdit{nextFile: t.fileNumber.Load()}
+	namespace := storedNamespace(c.ns.settings.Name)
+	for _, output := range outputs {
+		edit.added = append(edit.added, tableFile{level: c.outputLevel, name: output.Name(), namespace: namespace})
+	}
+	for _, input := range c.inputs {
+		edit.removed = append(edit.removed, input.Name())
+	}
+	if err := t.logAndInstall(c, edit, outputs); errors.Is(err, errNamespaceDropped) {
+		// The drop deletes the inputs once the compaction lets go of them
+		return t.discard(outputs, nil)
+	} else if err != nil {
+		return t.discard(outputs, err)
+	}
+	t.counters.compactions.Add(1)
+
+	// No reader can hold the inputs once install released the lock
+	for _, input := range c.inputs {
+		input.Close()
+		if err := os.Remove(input.Path()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tombstoneDropper returns whether a tombstone can be left out of the output:
+// only when no table outside the compaction, at the output level or deeper,
+// can still hold an older version of the key.
+func (t *LsmTree) tombstoneDropper(c *compaction) func(key string) bool {
+	inputs := make(map[*SSTableReader]bool, len(c.inputs))
+	for _, input := range c.inputs {
+		inputs[input] = true
+	}
+	t.mu.RLock()
+	var older []*SSTableReader
+	for level := c.outputLevel; level < len(c.ns.levels); level++ {
+		for _, table := range c.ns.levels[level] {
+			if !inputs[table] {
+				older = append(older, table)
+			}
+		}
+	}
+	t.mu.RUnlock()
+
+	return func(key string) bool {
+		for _, table := range older {
+			if table.FirstKey() <= key && table.LastKey() >= key {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// logAndInstall makes the edit of a compaction durable and installs it. The
+// namespace may have been dropped while the compaction ran, there is nothing
+// left to install into then.
+func (t *LsmTree) logAndInstall(c *compaction, edit versionEdit, outputs []*SSTableReader) error {
+	t.versionMu.Lock()
+	defer t.versionMu.Unlock()
+	if c.ns.dropped {
+		return errNamespaceDropped
+	}
+	if err := t.manifest.log(edit); err != nil {
+		return err
+	}
+	t.install(c, outputs)
+	return nil
+}
+
+// install replaces the inputs of a compaction with its outputs.
+func (t *LsmTree) install(c *compaction, outputs []*SSTableReader) {
+	inputs := make(map[*SSTableReader]bool, len(c.inputs))
+	for _, input := range c.inputs {
+		inputs[input] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ns := c.ns
+	for level, tables := range ns.levels {
+		kept := tables[:0:0]
+		for _, table := range tables {
+			if !inputs[table] {
+				kept = append(kept, table)
+			}
+		}
+		ns.levels[level] = kept
+	}
+
+	level := c.outputLevel
+	if ns.policy.Overlapping(level) {
+		ns.levels[level] = append(outputs, ns.levels[level]...)
+	} else {
+		ns.levels[level] = append(ns.levels[level], outputs...)
+		sortByFirstKey(ns.levels[level])
+	}
+}
+
+func sortByFirstKey(tables []*SSTableReader) {
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].FirstKey() < tables[j].FirstKey()
+	})
+}
+
+func (t *LsmTree) discard(outputs []*SSTableReader, cause error) error {
+	for _, table := range outputs {
+		table.Close()
+		os.Remove(table.Path())
+	}
+	return cause
+}
+
+// entryIterator is implemented by every source a mergeIterator can merge.
+type entryIterator interface {
+	Next() (DbEntry, bool)
+	Err() error
+}
+
+// mergeIterator merges iterators into a single stream sorted by key, or in
+// reverse. Entries with equal keys come newest first among the ones queued,
+// and for equal sequence numbers those from iterators earlier in the input
+// list come first.
+type mergeIterator struct {
+	heap iteratorHeap
+	err  error
+}
+
+type heapItem struct {
+	entry DbEntry
+	rank  int
+	it    entryIterator
+}
+
+type iteratorHeap struct {
+	items   []heapItem
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int { return len(h.items) }
+func (h *iteratorHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.entry.Key() != b.entry.Key() {
+		return (a.entry.Key() < b.entry.Key()) != h.reverse
+	}
+	if a.entry.Seq() != b.entry.Seq() {
+		return a.entry.Seq() > b.entry.Seq()
+	}
+	return a.rank < b.rank
+}
+func (h *iteratorHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *iteratorHeap) Push(x any)    { h.items = append(h.items, x.(heapItem)) }
+func (h *iteratorHeap) Pop() any {
+	old := h.items
+	item := old[len(old)-1]
+	h.items = old[:len(old)-1]
+	return item
+}
+
+func newMergeIterator(tables []*SSTableReader) *mergeIterator {
+	its := make([]entryIterator, len(tables))
+	for i, table := range tables {
+		its[i] = table.iterator()
+	}
+	return mergeIterators(its, false)
+}
+
+func mergeIterators(its []entryIterator, reverse bool) *mergeIterator {
+	m := &mergeIterator{heap: iteratorHeap{reverse: reverse}}
+	for rank, it := range its {
+		m.push(heapItem{rank: rank, it: it})
+	}
+	return m
+}
+
+// push advances the item's iterator and queues it again if not exhausted.
+func (m *mergeIterator) push(item heapItem) {
+	entry, ok := item.it.Next()
+	if !ok {
+		if err := item.it.Err(); err != nil && m.err == nil {
+			m.err = err
+		}
+		return
+	}
+	item.entry = entry
+	heap.Push(&m.heap, item)
+}
+
+func (m *mergeIterator) Next() (DbEntry, bool) {
+	if m.err != nil || m.heap.Len() == 0 {
+		return DbEntry{}, false
+	}
+	item := heap.Pop(&m.heap).(heapItem)
+	m.push(item)
+	return item.entry, true
+}
+
+func (m *mergeIterator) Err() error {
+	return m.err
+}
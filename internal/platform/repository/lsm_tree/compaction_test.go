@@ -0,0 +1,178 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// espera a que no queden memtables por volcar ni compactaciones pendientes
+func waitForCompaction(t *testing.T, tree *LsmTree) {
+	waitForFlush(t, tree)
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		tree.mu.RLock()
+		l0 := len(defaultLevels(tree)[0])
+		tree.mu.RUnlock()
+		if l0 < compactionTrigger {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("la compactación no terminó")
+}
+
+func allTableEntries(t *testing.T, tree *LsmTree) []DbEntry {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	var all []DbEntry
+	for _, tables := range defaultLevels(tree) {
+		for _, table := range tables {
+			entries, err := table.All()
+			assert.NoError(t, err)
+			all = append(all, entries...)
+		}
+	}
+	return all
+}
+
+func fillTree(tree *LsmTree) {
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), fmt.Sprintf("value-%d", round), false))
+		}
+	}
+	for i := 0; i < 100; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "", true))
+	}
+	// empuja las tombstones de la memtable a disco
+	for i := 0; i < 200; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("other-%03d", i), "x", false))
+	}
+}
+
+func assertTreeContents(t *testing.T, tree *LsmTree) {
+	for i := 0; i < 200; i++ {
+		got, found := tree.Get(fmt.Sprintf("key-%03d", i))
+		if i < 100 {
+			assert.False(t, found, "key-%03d fue borrada", i)
+			continue
+		}
+		assert.True(t, found)
+		assert.Equal(t, "value-4", got.Value())
+	}
+}
+
+func TestCompaction_Leveled(t *testing.T) {
+	tree := openTree(t, config.Config{
+		WalDirectory:          t.TempDir(),
+		MemtableSizeThreshold: 2048,
+		CompactionPolicy:      LeveledCompaction,
+	})
+	fillTree(tree)
+	waitForCompaction(t, tree)
+
+	assertTreeContents(t, tree)
+
+	tree.mu.RLock()
+	for level := 1; level < maxLevels; level++ {
+		tables := defaultLevels(tree)[level]
+		for i := 1; i < len(tables); i++ {
+			assert.Less(t, tables[i-1].LastKey(), tables[i].FirstKey(), "nivel %d con rangos solapados", level)
+		}
+	}
+	tree.mu.RUnlock()
+
+	stats := tree.CompactionStats()
+	assert.Equal(t, LeveledCompaction, stats.Policy)
+	assert.Greater(t, stats.Compactions, int64(0))
+	assert.Greater(t, stats.BytesRead, int64(0))
+	assert.Greater(t, stats.BytesWritten, int64(0))
+	assert.Len(t, stats.FilesPerLevel, maxLevels)
+}
+
+func TestCompaction_SizeTiered(t *testing.T) {
+	tree := openTree(t, config.Config{
+		WalDirectory:          t.TempDir(),
+		MemtableSizeThreshold: 2048,
+		CompactionPolicy:      SizeTieredCompaction,
+	})
+	fillTree(tree)
+	waitForCompaction(t, tree)
+
+	assertTreeContents(t, tree)
+	assert.Greater(t, tree.CompactionStats().Compactions, int64(0))
+}
+
+func TestCompaction_DropsShadowedVersions(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+	newer := writeTable(t, dir, "newer.sst", NewDbEntry("a", "new", false), NewDbEntry("b", "", true))
+	older := writeTable(t, dir, "older.sst", NewDbEntry("a", "old", false), NewDbEntry("b", "old", false), NewDbEntry("c", "old", false))
+	setLevel(tree, 0, newer, older)
+
+	err := tree.compact(&compaction{ns: defaultNamespace(tree), inputs: []*SSTableReader{newer, older}, outputLevel: 1})
+	assert.NoError(t, err)
+
+	entries := allTableEntries(t, tree)
+	assert.Equal(t, []DbEntry{
+		NewDbEntry("a", "new", false),
+		NewDbEntry("c", "old", false),
+	}, entries, "la tombstone de b se descarta al no quedar niveles más antiguos")
+}
+
+func TestCompaction_KeepsTombstoneWhileOlderLevelHoldsKey(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+	l0 := writeTable(t, dir, "l0.sst", NewDbEntry("b", "", true))
+	l2 := writeTable(t, dir, "l2.sst", NewDbEntry("a", "old", false), NewDbEntry("b", "old", false))
+	setLevel(tree, 0, l0)
+	setLevel(tree, 2, l2)
+
+	err := tree.compact(&compaction{ns: defaultNamespace(tree), inputs: []*SSTableReader{l0}, outputLevel: 1})
+	assert.NoError(t, err)
+
+	_, found := tree.Get("b")
+	assert.False(t, found, "la tombstone debe seguir ocultando la versión del nivel 2")
+	assert.Len(t, defaultLevels(tree)[1], 1)
+}
+
+func TestCompaction_DropsExpiredEntries(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+	now := time.Unix(1_700_000_000, 0)
+	tree.now = func() time.Time { return now }
+	expiring := func(key string, deadline time.Time) DbEntry {
+		entry := NewDbEntry(key, "v", false)
+		entry.SetExpiresAt(deadline.UnixNano())
+		return entry
+	}
+	l0 := writeTable(t, dir, "l0.sst",
+		expiring("a", now.Add(-time.Second)),
+		expiring("b", now.Add(time.Hour)),
+		expiring("c", now.Add(-time.Second)))
+	l2 := writeTable(t, dir, "l2.sst", NewDbEntry("c", "old", false))
+	setLevel(tree, 0, l0)
+	setLevel(tree, 2, l2)
+
+	err := tree.compact(&compaction{ns: defaultNamespace(tree), inputs: []*SSTableReader{l0}, outputLevel: 1})
+	assert.NoError(t, err)
+
+	entries, err := defaultLevels(tree)[1][0].All()
+	assert.NoError(t, err)
+	assert.Equal(t, []DbEntry{
+		expiring("b", now.Add(time.Hour)),
+		NewDbEntry("c", "", true),
+	}, entries, "c expirada pasa a tombstone para seguir ocultando el nivel 2")
+}
+
+func TestRateLimiter_Throttles(t *testing.T) {
+	limiter := newRateLimiter(1000)
+	start := time.Now()
+	limiter.wait(100)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
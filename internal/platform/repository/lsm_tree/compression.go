@@ -0,0 +1,215 @@
+package lsm_tree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Codec tells how a data block is compressed. It is stored in the trailer of
+// every block, so tables written with different codecs can be read side by
+// side.
+type Codec uint8
+
+const (
+	CodecNone Codec = 0
+	// CodecLZ4 is the LZ4 block format: fast, with a modest ratio that is
+	// good enough for repetitive values.
+	CodecLZ4 Codec = 1
+)
+
+// A compressed data block is laid out as [Payload][Codec][RawLength], where
+// RawLength is the size of the encoded block before compression.
+const blockTrailerSize = 5
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// ParseCodec maps a codec name from the configuration. An empty name means
+// no compression.
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "", "none":
+		return CodecNone, nil
+	case "lz4":
+		return CodecLZ4, nil
+	}
+	return 0, fmt.Errorf("%w %q, expected none or lz4", ErrUnknownCodec, name)
+}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecLZ4:
+		return "lz4"
+	}
+	return fmt.Sprintf("codec(%d)", uint8(c))
+}
+
+// compressBlock appends the trailer to the block, compressed with codec
+// unless that would not make it smaller.
+func compressBlock(codec Codec, raw []byte) []byte {
+	payload := raw
+	if codec == CodecLZ4 {
+		if compressed := lz4Compress(raw); len(compressed) < len(raw) {
+			payload = compressed
+		} else {
+			codec = CodecNone
+		}
+	}
+	buf := make([]byte, len(payload), len(payload)+blockTrailerSize)
+	copy(buf, payload)
+	buf = append(buf, byte(codec))
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(raw)))
+}
+
+func decompressBlock(buf []byte) ([]byte, error) {
+	if len(buf) < blockTrailerSize {
+		return nil, fmt.Errorf("%w: block of %d bytes has no trailer", ErrCorruptedBlock, len(buf))
+	}
+	payload := buf[:len(buf)-blockTrailerSize]
+	codec := Codec(buf[len(payload)])
+	rawLength := int(binary.LittleEndian.Uint32(buf[len(payload)+1:]))
+	switch codec {
+	case CodecNone:
+		if len(payload) != rawLength {
+			return nil, fmt.Errorf("%w: block length %d, expected %d", ErrCorruptedBlock, len(payload), rawLength)
+		}
+		return payload, nil
+	case CodecLZ4:
+		return lz4Decompress(payload, rawLength)
+	}
+	return nil, fmt.Errorf("%w: block codec %d", ErrUnknownCodec, uint8(codec))
+}
+
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 12
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5  // the last bytes are always literals
+	lz4MatchLimit   = 12 // no match may start closer to the end
+)
+
+// lz4Compress encodes src as a single LZ4 block, finding matches greedily
+// through a hash table of the last position every 4-byte sequence was seen.
+func lz4Compress(src []byte) []byte {
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	anchor := 0
+	if len(src) > lz4MatchLimit {
+		var table [1 << lz4HashLog]int32 // position + 1, zero when unset
+		for i := 0; i < len(src)-lz4MatchLimit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := (seq * 2654435761) >> (32 - lz4HashLog)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+
+			end := i + lz4MinMatch
+			for end < len(src)-lz4LastLiterals && src[end] == src[ref+end-i] {
+				end++
+			}
+			for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+				i--
+				ref--
+			}
+			dst = appendLZ4Literals(dst, src[anchor:i], end-i-lz4MinMatch)
+			dst = binary.LittleEndian.AppendUint16(dst, uint16(i-ref))
+			if end-i-lz4MinMatch >= 15 {
+				dst = appendLZ4Length(dst, end-i-lz4MinMatch-15)
+			}
+			i, anchor = end, end
+		}
+	}
+	// The block ends with a sequence holding only literals
+	return appendLZ4Literals(dst, src[anchor:], 0)
+}
+
+// appendLZ4Literals writes the token and the literals of a sequence.
+func appendLZ4Literals(dst, literals []byte, matchLength int) []byte {
+	dst = append(dst, byte(min(len(literals), 15)<<4|min(matchLength, 15)))
+	if len(literals) >= 15 {
+		dst = appendLZ4Length(dst, len(literals)-15)
+	}
+	return append(dst, literals...)
+}
+
+func appendLZ4Length(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+func lz4Decompress(src []byte, rawLength int) ([]byte, error) {
+	corrupted := func(reason string) error {
+		return fmt.Errorf("%w: lz4 %s", ErrCorruptedBlock, reason)
+	}
+	dst := make([]byte, 0, rawLength)
+	pos := 0
+	readLength := func(n int) (int, bool) {
+		for {
+			if pos >= len(src) {
+				return 0, false
+			}
+			b := src[pos]
+			pos++
+			n += int(b)
+			if b != 255 {
+				return n, true
+			}
+		}
+	}
+
+	for pos < len(src) {
+		token := src[pos]
+		pos++
+		literals := int(token >> 4)
+		if literals == 15 {
+			var ok bool
+			if literals, ok = readLength(literals); !ok {
+				return nil, corrupted("truncated literal length")
+			}
+		}
+		if pos+literals > len(src) || len(dst)+literals > rawLength {
+			return nil, corrupted("literals out of bounds")
+		}
+		dst = append(dst, src[pos:pos+literals]...)
+		pos += literals
+		if pos == len(src) {
+			break
+		}
+
+		if pos+2 > len(src) {
+			return nil, corrupted("truncated offset")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		matchLength := int(token & 15)
+		if matchLength == 15 {
+			var ok bool
+			if matchLength, ok = readLength(matchLength); !ok {
+				return nil, corrupted("truncated match length")
+			}
+		}
+		matchLength += lz4MinMatch
+		if offset == 0 || offset > len(dst) || len(dst)+matchLength > rawLength {
+			return nil, corrupted("match out of bounds")
+		}
+		start := len(dst) - offset
+		if offset >= matchLength {
+			dst = append(dst, dst[start:start+matchLength]...)
+		} else {
+			// The match overlaps the bytes it produces
+			for k := 0; k < matchLength; k++ {
+				dst = append(dst, dst[start+k])
+			}
+		}
+	}
+	if len(dst) != rawLength {
+		return nil, corrupted(fmt.Sprintf("block length %d, expected %d", len(dst), rawLength))
+	}
+	return dst, nil
+}
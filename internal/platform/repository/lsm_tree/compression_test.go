@@ -0,0 +1,120 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func jsonBlob(i int) string {
+	return fmt.Sprintf(`{"id":%d,"user":"user-%d","session":{"active":true,"roles":["reader","writer"],"locale":"es-AR"},"updated_at":"2024-05-%02dT10:00:00Z"}`, i, i%50, i%28+1)
+}
+
+func TestLz4_RoundTrip(t *testing.T) {
+	random := make([]byte, 10000)
+	for i := range random {
+		random[i] = byte(rand.IntN(256))
+	}
+	inputs := map[string][]byte{
+		"vacío":      {},
+		"corto":      []byte("abc"),
+		"repetido":   bytes.Repeat([]byte("a"), 5000),
+		"json":       []byte(strings.Repeat(jsonBlob(1), 40)),
+		"aleatorio":  random,
+		"solapado":   []byte("abcabcabcabcabcabcabcabcxyz" + strings.Repeat("ab", 300) + "end!!"),
+		"casi corto": []byte("0123456789abcdef"),
+	}
+	for name, input := range inputs {
+		compressed := lz4Compress(input)
+		got, err := lz4Decompress(compressed, len(input))
+		if assert.NoError(t, err, name) {
+			assert.True(t, bytes.Equal(input, got), "%s: el contenido no coincide", name)
+		}
+	}
+}
+
+func TestLz4_RejectsCorruptedInput(t *testing.T) {
+	input := []byte(strings.Repeat(jsonBlob(1), 10))
+	compressed := lz4Compress(input)
+	_, err := lz4Decompress(compressed[:len(compressed)/2], len(input))
+	assert.ErrorIs(t, err, ErrCorruptedBlock)
+	_, err = lz4Decompress(compressed, len(input)-1)
+	assert.ErrorIs(t, err, ErrCorruptedBlock)
+}
+
+func TestCodec_RejectsUnknown(t *testing.T) {
+	_, err := ParseCodec("zstd")
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+
+	_, err = OpenLsmTree(config.Config{WalDirectory: t.TempDir(), SSTCompression: "zstd"})
+	assert.ErrorIs(t, err, ErrUnknownCodec, "la configuración con un codec desconocido debe fallar")
+
+	block := compressBlock(CodecNone, []byte("payload"))
+	block[len(block)-blockTrailerSize] = 9
+	_, err = decompressBlock(block)
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+}
+
+func TestSSTable_CompressedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	var entries []DbEntry
+	for i := 0; i < 2000; i++ {
+		entries = append(entries, NewDbEntry(fmt.Sprintf("key-%05d", i), jsonBlob(i), false))
+	}
+
+	sizes := map[Codec]int64{}
+	for _, codec := range []Codec{CodecNone, CodecLZ4} {
+		file := path.Join(dir, codec.String()+".sst")
+		_, err := NewSSTableWriter(SSTableOptions{Compression: codec}).Write(file, 1, entries)
+		assert.NoError(t, err)
+		table, err := OpenSSTable(file)
+		assert.NoError(t, err)
+		defer table.Close()
+
+		assert.Equal(t, codec, table.Codec())
+		all, err := table.All()
+		assert.NoError(t, err)
+		assert.Equal(t, entries, all)
+		got, found, err := table.Get("key-01234")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, jsonBlob(1234), got.Value())
+		sizes[codec] = table.Size()
+	}
+	assert.Less(t, sizes[CodecLZ4]*3, sizes[CodecNone], "los valores JSON repetitivos deben ocupar al menos 3 veces menos")
+}
+
+func TestCompaction_RewritesTablesWithNewCodec(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir, SSTCompression: "lz4"})
+	file := path.Join(dir, sstPrefix+formatVersion(tree.nextFileNumber())+sstExtension)
+	_, err := NewSSTableWriter(SSTableOptions{Compression: CodecNone}).Write(file, 1, []DbEntry{
+		NewDbEntry("a", jsonBlob(1), false),
+		NewDbEntry("b", jsonBlob(2), false),
+	})
+	assert.NoError(t, err)
+	old, err := tree.openTable(file)
+	assert.NoError(t, err)
+	setLevel(tree, 1, old)
+
+	tree.mu.RLock()
+	c := defaultNamespace(tree).pickRewrite()
+	tree.mu.RUnlock()
+	if assert.NotNil(t, c, "la tabla sin comprimir debe reescribirse") {
+		assert.NoError(t, tree.compact(c))
+	}
+
+	assert.Len(t, defaultLevels(tree)[1], 1)
+	assert.Equal(t, CodecLZ4, defaultLevels(tree)[1][0].Codec())
+	got, found := tree.Get("b")
+	assert.True(t, found)
+	assert.Equal(t, jsonBlob(2), got.Value())
+	assert.Nil(t, defaultNamespace(tree).pickRewrite(), "no queda nada por reescribir")
+}
@@ -0,0 +1,402 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// quarantineDir receives the tables and WAL segments fsck could not read, so
+// the node can start without them and they are still around to be looked at.
+const quarantineDir = "quarantine"
+
+// FsckIssue is a problem found in one file of the data directory.
+type FsckIssue struct {
+	Path    string
+	Problem string
+	// Repair tells what was done about it, empty when nothing was
+	Repair string
+}
+
+// FsckReport sums up a check of the data directory.
+type FsckReport struct {
+	WalSegments   int
+	Tables        int
+	ValueLogFiles int
+	// Records counts the WAL records, table entries and value log records
+	// that were read successfully
+	Records int
+	Issues  []FsckIssue
+}
+
+// Healthy tells whether every issue found was repaired.
+func (r *FsckReport) Healthy() bool {
+	for _, issue := range r.Issues {
+		if issue.Repair == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *FsckReport) issue(path, repair, format string, args ...any) {
+	r.Issues = append(r.Issues, FsckIssue{Path: path, Problem: fmt.Sprintf(format, args...), Repair: repair})
+}
+
+// Fsck checks the WAL segments, SSTables, value log files and manifest of a
+// stopped node's data directory. With repair set, torn value log tails and
+// the torn tail of the newest WAL segment are truncated, other corrupted WAL
+// segments and unreadable tables are moved to the quarantine directory and
+// the tables dropped from the manifest.
+func Fsck(dir string, repair bool) (FsckReport, error) {
+	var report FsckReport
+
+	segments, err := ListWalSegments(dir)
+	if err != nil {
+		return report, err
+	}
+	for i, segment := range segments {
+		report.WalSegments++
+		newest := i == len(segments)-1
+		if err := fsckWal(&report, dir, segment, newest, repair); err != nil {
+			return report, err
+		}
+	}
+
+	tables, err := ListSSTables(dir)
+	if err != nil {
+		return report, err
+	}
+	quarantined := make(map[string]bool)
+	for _, table := range tables {
+		report.Tables++
+		n, err := checkTable(table)
+		report.Records += n
+		if err == nil {
+			continue
+		}
+		action := ""
+		if repair {
+			if action, err = quarantine(dir, table); err != nil {
+				return report, err
+			}
+			quarantined[path.Base(table)] = true
+		}
+		report.issue(table, action, "%v", err)
+	}
+
+	numbers, err := listValueLogFiles(dir)
+	if err != nil {
+		return report, err
+	}
+	for _, number := range numbers {
+		report.ValueLogFiles++
+		if err := fsckValueLog(&report, vlogPath(dir, number), number, repair); err != nil {
+			return report, err
+		}
+	}
+	return report, fsckManifest(&report, dir, quarantined, repair)
+}
+
+// fsckManifest checks that every table the manifest lists is there. Missing
+// tables, and those just quarantined, are dropped from it by writing a new
+// manifest. An unreadable manifest is removed, the node then takes the level
+// of each table from the table itself.
+func fsckManifest(report *FsckReport, dir string, quarantined map[string]bool, repair bool) error {
+	state, number, err := readManifest(dir)
+	current := path.Join(dir, currentManifestFile)
+	if err != nil {
+		action := ""
+		if repair {
+			if err := os.Remove(current); err != nil {
+				return err
+			}
+			action = "removed " + currentManifestFile
+		}
+		report.issue(current, action, "%v", err)
+		return nil
+	}
+	if number == 0 {
+		return nil
+	}
+	dropped := false
+	for name := range state.tables {
+		if quarantined[name] {
+			delete(state.tables, name)
+			delete(state.tableNamespaces, name)
+			dropped = true
+			continue
+		}
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		action := ""
+		if repair {
+			delete(state.tables, name)
+			delete(state.tableNamespaces, name)
+			dropped = true
+			action = "dropped from the manifest"
+		}
+		report.issue(path.Join(dir, name), action, "listed in the manifest but missing")
+	}
+	if !dropped {
+		return nil
+	}
+	fd, _, err := writeManifest(dir, number+1, state)
+	if err != nil {
+		return err
+	}
+	fd.Close()
+	return removeOldManifests(dir, number+1)
+}
+
+// fsckWal only truncates a torn tail of the newest segment, what a crash in
+// the middle of a write leaves. Corruption anywhere else would take valid
+// records after it along, so the segment is quarantined instead.
+func fsckWal(report *FsckReport, dir, segment string, newest, repair bool) error {
+	text, err := IsTextWal(segment)
+	if err != nil {
+		return err
+	}
+	if text {
+		// Converted to the binary format when the node opens it
+		return nil
+	}
+	fd, err := os.Open(segment)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	entries, err := readWalRecords(fd)
+	report.Records += len(entries)
+	var corruption *WalCorruptionError
+	if !errors.As(err, &corruption) {
+		if err != nil {
+			report.issue(segment, "", "%v", err)
+		}
+		return nil
+	}
+	action := ""
+	if repair && corruption.Tail && newest {
+		if err := os.Truncate(segment, corruption.Offset); err != nil {
+			return err
+		}
+		action = fmt.Sprintf("truncated to %d bytes", corruption.Offset)
+	} else if repair {
+		fd.Close()
+		if action, err = quarantine(dir, segment); err != nil {
+			return err
+		}
+	}
+	report.issue(segment, action, "%v", corruption)
+	return nil
+}
+
+// checkTable reads every block of a table and returns how many entries it
+// holds. The index must agree with the blocks it points to and keys must be
+// sorted, newest version first.
+func checkTable(path string) (int, error) {
+	table, err := OpenSSTable(path)
+	if err != nil {
+		return 0, err
+	}
+	defer table.Close()
+
+	if int(table.header.NumBlocks) != len(table.index.Entries) {
+		return 0, fmt.Errorf("%w: header counts %d blocks, index has %d", ErrCorruptedBlock, table.header.NumBlocks, len(table.index.Entries))
+	}
+	count := 0
+	var prev DbEntry
+	for i, indexEntry := range table.index.Entries {
+		block, err := table.readDataBlock(indexEntry.Metadata)
+		if err != nil {
+			return count, fmt.Errorf("block %d: %w", i, err)
+		}
+		if len(block.Entries) == 0 {
+			return count, fmt.Errorf("%w: block %d is empty", ErrCorruptedBlock, i)
+		}
+		first, last := block.Entries[0].Key(), block.Entries[len(block.Entries)-1].Key()
+		if first != indexEntry.FirstKey || last != indexEntry.LastKey {
+			return count, fmt.Errorf("%w: block %d holds keys %q to %q, index says %q to %q",
+				ErrCorruptedBlock, i, first, last, indexEntry.FirstKey, indexEntry.LastKey)
+		}
+		for _, entry := range block.Entries {
+			if count > 0 && (entry.Key() < prev.Key() || (entry.Key() == prev.Key() && entry.Seq() >= prev.Seq())) {
+				return count, fmt.Errorf("%w: key %q seq %d out of order in block %d", ErrCorruptedBlock, entry.Key(), entry.Seq(), i)
+			}
+			if entry.Seq() > table.MaxSeq() {
+				return count, fmt.Errorf("%w: key %q seq %d above the table's %d", ErrCorruptedBlock, entry.Key(), entry.Seq(), table.MaxSeq())
+			}
+			prev = entry
+			count++
+		}
+	}
+	return count, nil
+}
+
+func quarantine(dir, file string) (string, error) {
+	target := path.Join(dir, quarantineDir)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+	target = path.Join(target, path.Base(file))
+	if err := os.Rename(file, target); err != nil {
+		return "", err
+	}
+	return "moved to " + target, nil
+}
+
+func fsckValueLog(report *FsckReport, file string, number uint64, repair bool) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	end, err := readValueLogRecords(fd, number, func(ValuePointer, string, string, string) error {
+		report.Records++
+		return nil
+	})
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	if end == info.Size() {
+		return nil
+	}
+	action := ""
+	if repair {
+		if err := os.Truncate(file, end); err != nil {
+			return err
+		}
+		action = fmt.Sprintf("truncated to %d bytes", end)
+	}
+	report.issue(file, action, "%v: unreadable record at offset %d", ErrCorruptedValue, end)
+	return nil
+}
+
+// DumpRecord is one record of a data file as printed by the dump tool.
+type DumpRecord struct {
+	File string `json:"file"`
+	// Namespace is empty for the default one, and for table entries: the
+	// manifest tells which namespace a table belongs to
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Tombstone bool   `json:"tombstone,omitempty"`
+	Seq       uint64 `json:"seq,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	// Pointer is set on entries whose value is in the value log, and on the
+	// records of the value log themselves
+	Pointer *ValuePointer `json:"pointer,omitempty"`
+}
+
+func dumpEntry(file string, entry DbEntry) DumpRecord {
+	record := DumpRecord{
+		File:      file,
+		Namespace: storedNamespace(entry.Namespace()),
+		Key:       entry.Key(),
+		Value:     entry.Value(),
+		Tombstone: entry.Tombstone(),
+		Seq:       entry.Seq(),
+		ExpiresAt: entry.ExpiresAt(),
+	}
+	if entry.External() {
+		if ptr, err := decodeValuePointer(entry.Value()); err == nil {
+			record.Value, record.Pointer = "", &ptr
+		}
+	}
+	return record
+}
+
+// Dump calls fn with every record of a WAL segment, SSTable or value log
+// file. Given a directory it dumps every such file in it: WAL segments,
+// then tables, then the value log.
+func Dump(file string, fn func(DumpRecord) error) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return dumpDirectory(file, fn)
+	}
+
+	name := path.Base(file)
+	switch {
+	case strings.HasSuffix(name, walExtension):
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		entries, err := readWalRecords(fd)
+		for _, entry := range entries {
+			if err := fn(dumpEntry(file, entry)); err != nil {
+				return err
+			}
+		}
+		return err
+	case strings.HasSuffix(name, sstExtension):
+		table, err := OpenSSTable(file)
+		if err != nil {
+			return err
+		}
+		defer table.Close()
+		it := table.iterator()
+		for entry, ok := it.Next(); ok; entry, ok = it.Next() {
+			if err := fn(dumpEntry(file, entry)); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	case strings.HasSuffix(name, vlogExtension):
+		number, ok := versionNumber(strings.TrimSuffix(strings.TrimPrefix(name, vlogPrefix), vlogExtension))
+		if !ok {
+			return fmt.Errorf("%s is not a value log file", file)
+		}
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		_, err = readValueLogRecords(fd, number, func(ptr ValuePointer, namespace, key, value string) error {
+			return fn(DumpRecord{File: file, Namespace: namespace, Key: key, Value: value, Pointer: &ptr})
+		})
+		return err
+	}
+	return fmt.Errorf("%s is not a wal segment, sstable or value log file", file)
+}
+
+func dumpDirectory(dir string, fn func(DumpRecord) error) error {
+	segments, err := ListWalSegments(dir)
+	if err != nil {
+		return err
+	}
+	tables, err := ListSSTables(dir)
+	if err != nil {
+		return err
+	}
+	numbers, err := listValueLogFiles(dir)
+	if err != nil {
+		return err
+	}
+	files := append(segments, tables...)
+	for _, number := range numbers {
+		files = append(files, vlogPath(dir, number))
+	}
+	for _, file := range files {
+		if err := Dump(file, fn); err != nil {
+			return fmt.Errorf("dumping %s: %w", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendToFile(t *testing.T, file string, data []byte) {
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+}
+
+func TestFsck_RepairsDataDirectory(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, ValueLogThreshold: 100})
+	assert.NoError(t, err)
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.Set(NewDbEntry("b", bigValue(1), false))
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+	tree.Set(NewDbEntry("c", "3", false))
+	assert.NoError(t, tree.Close())
+
+	report, err := Fsck(dir, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Issues)
+	assert.True(t, report.Healthy())
+	assert.Equal(t, 1, report.Tables)
+
+	// Cola rota en el WAL y en el value log, tabla con el número mágico roto
+	segments, _ := ListWalSegments(dir)
+	wal := segments[len(segments)-1]
+	appendToFile(t, wal, []byte{1, 2, 3})
+	numbers, _ := listValueLogFiles(dir)
+	appendToFile(t, vlogPath(dir, numbers[0]), []byte{9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9})
+	tables, _ := ListSSTables(dir)
+	info, _ := os.Stat(tables[0])
+	fd, _ := os.OpenFile(tables[0], os.O_WRONLY, 0)
+	fd.WriteAt([]byte("XXXXXXXX"), info.Size()-8)
+	fd.Close()
+
+	report, err = Fsck(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, report.Issues, 3)
+	assert.False(t, report.Healthy(), "sin --repair nada se arregla")
+
+	report, err = Fsck(dir, true)
+	assert.NoError(t, err)
+	assert.Len(t, report.Issues, 3)
+	assert.True(t, report.Healthy())
+	_, err = os.Stat(path.Join(dir, quarantineDir, path.Base(tables[0])))
+	assert.NoError(t, err, "la tabla ilegible debe quedar en cuarentena")
+
+	report, err = Fsck(dir, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Issues, "después de reparar no deben quedar problemas")
+	assert.Equal(t, 0, report.Tables)
+
+	reopened := openTree(t, config.Config{WalDirectory: dir})
+	got, found := reopened.Get("c")
+	assert.True(t, found, "las entradas anteriores a la cola rota se conservan")
+	assert.Equal(t, "3", got.Value())
+}
+
+func TestFsck_QuarantinesWalCorruptedBeforeItsEnd(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.NoError(t, err)
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.Set(NewDbEntry("b", "2", false))
+	tree.mu.Lock()
+	w, err := tree.newWal()
+	assert.NoError(t, err)
+	assert.NoError(t, tree.active.rotate(w))
+	tree.mu.Unlock()
+	tree.Set(NewDbEntry("c", "3", false))
+	assert.NoError(t, tree.Close())
+
+	segments, _ := ListWalSegments(dir)
+	assert.Len(t, segments, 2)
+	older, newest := segments[0], segments[1]
+	// Un bit cambiado en el primer registro del segmento viejo, seguido de
+	// registros válidos, y una cola rota en el más nuevo
+	fd, _ := os.OpenFile(older, os.O_WRONLY, 0)
+	fd.WriteAt([]byte{0xff}, walHeaderSize+walRecordHeaderSize)
+	fd.Close()
+	appendToFile(t, newest, []byte{1, 2, 3})
+	info, _ := os.Stat(older)
+
+	report, err := Fsck(dir, true)
+	assert.NoError(t, err)
+	assert.Len(t, report.Issues, 2)
+	assert.True(t, report.Healthy())
+
+	quarantined, err := os.Stat(path.Join(dir, quarantineDir, path.Base(older)))
+	assert.NoError(t, err, "el segmento dañado en el medio va a cuarentena")
+	assert.Equal(t, info.Size(), quarantined.Size(), "sin truncar los registros válidos que siguen")
+	segments, _ = ListWalSegments(dir)
+	assert.Equal(t, []string{newest}, segments)
+
+	reopened := openTree(t, config.Config{WalDirectory: dir})
+	_, found := reopened.Get("c")
+	assert.True(t, found, "la cola rota del segmento más nuevo se trunca")
+}
+
+func TestDump_PrintsRecords(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, ValueLogThreshold: 100})
+	assert.NoError(t, err)
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.Set(NewDbEntry("b", bigValue(1), false))
+	tree.Set(NewDbEntry("a", "", true))
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+	assert.NoError(t, tree.Close())
+
+	tables, _ := ListSSTables(dir)
+	var records []DumpRecord
+	assert.NoError(t, Dump(tables[0], func(record DumpRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "a", records[0].Key)
+		assert.True(t, records[0].Tombstone)
+		assert.Equal(t, "b", records[1].Key)
+		assert.NotNil(t, records[1].Pointer, "el valor grande se muestra como puntero")
+	}
+
+	records = nil
+	assert.NoError(t, Dump(dir, func(record DumpRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	assert.Equal(t, bigValue(1), records[len(records)-1].Value, "el value log se vuelca al final")
+}
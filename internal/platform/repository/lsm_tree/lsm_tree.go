@@ -1,4 +1,513 @@
 package lsm_tree
 
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxLevels = 7
+
+// LsmTree owns every place an entry can live in: the active memtable taking
+// writes, the immutable memtables waiting to be flushed and the SSTable
+// levels of each namespace. Lookups go through them newest first.
 type LsmTree struct {
+	mu         sync.RWMutex
+	active     *Memtable
+	immutables []*Memtable // newest first
+
+	// namespaces holds the tables of each namespace by name
+	namespaces map[string]*namespace
+	// versionMu orders the edits to the manifest with installing what they
+	// describe, so no table is added to a namespace being dropped
+	versionMu sync.Mutex
+
+	dir        string
+	threshold  int
+	walOptions WalOptions
+	// fileNumber is the last number handed out to a WAL segment or SSTable
+	fileNumber    atomic.Uint64
+	writerOptions SSTableOptions
+	// flushCh wakes the flusher up once a memtable is frozen, closing it
+	// makes the flusher go through the pending ones a last time and stop
+	flushCh chan struct{}
+	flushWg sync.WaitGroup
+	// flushFailed is closed and replaced, under mu, whenever a flush fails
+	flushFailed chan struct{}
+	logger      *log.Logger
+
+	// readerOptions holds the caches shared by every table of the tree
+	readerOptions ReaderOptions
+	// manifest records which tables are live and at which level
+	manifest *manifest
+
+	// values keeps the large values out of the tree, so compactions only
+	// move pointers to them
+	values          *ValueLog
+	valueLogOptions ValueLogOptions
+	valueLogGC      time.Duration
+	gcMu            sync.Mutex // one value log collection at a time
+	gcStop          chan struct{}
+	gcWg            sync.WaitGroup
+
+	// sequence is the last sequence number given to a write
+	sequence  atomic.Uint64
+	snapshots snapshotRegistry
+
+	filterStats filterCounters
+	// now tells compactions which entries have expired
+	now func() time.Time
+
+	policyName     string
+	compactionRate int64
+	counters       compactionCounters
+	compactCh      chan struct{}
+	compactWg      sync.WaitGroup
+}
+
+func newLsmTree(conf config.Config) (*LsmTree, error) {
+	policyName := conf.CompactionPolicy
+	if policyName == "" {
+		policyName = LeveledCompaction
+	}
+	walOptions, err := validateWalOptions(WalOptions{
+		SyncMode:          conf.WalSyncMode,
+		SyncInterval:      conf.WalSyncInterval,
+		GroupCommitWindow: conf.WalGroupCommitWindow,
+		MaxSegmentSize:    conf.WalMaxSegmentSize,
+		MaxSegmentAge:     conf.WalMaxSegmentAge,
+		ArchiveDir:        conf.WalArchiveDirectory,
+	})
+	if err != nil {
+		return nil, err
+	}
+	codec, err := ParseCodec(conf.SSTCompression)
+	if err != nil {
+		return nil, err
+	}
+	writerOptions := SSTableOptions{
+		BloomFalsePositiveRate: conf.BloomFalsePositiveRate,
+		Compression:            codec,
+	}
+	readerOptions := ReaderOptions{
+		BlockCache: NewBlockCache(conf.BlockCacheSize),
+		TableCache: NewTableCache(conf.MaxOpenTables),
+	}
+	tree := &LsmTree{
+		namespaces:     make(map[string]*namespace),
+		dir:            conf.WalDirectory,
+		threshold:      conf.MemtableSizeThreshold,
+		walOptions:     walOptions,
+		writerOptions:  writerOptions,
+		readerOptions:  readerOptions,
+		logger:         log.Default(),
+		now:            time.Now,
+		policyName:     policyName,
+		compactionRate: conf.CompactionBytesPerSecond,
+	}
+	tree.namespaces[DefaultNamespace], err = tree.newNamespace(Namespace{Name: DefaultNamespace}, 0)
+	if err != nil {
+		return nil, err
+	}
+	tree.valueLogOptions = ValueLogOptions{
+		Threshold:    conf.ValueLogThreshold,
+		MaxFileSize:  conf.ValueLogFileSize,
+		DiscardRatio: conf.ValueLogDiscardRatio,
+	}
+	tree.valueLogGC = conf.ValueLogGCInterval
+	return tree, nil
+}
+
+// Set writes entry to its namespace, which must exist.
+func (t *LsmTree) Set(entry DbEntry) error {
+	// The read lock keeps the active memtable from being frozen while the
+	// write is in progress, so no write lands in a memtable being flushed,
+	// and the namespace from being dropped.
+	t.mu.RLock()
+	if _, ok := t.namespaces[entry.Namespace()]; !ok {
+		t.mu.RUnlock()
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, entry.Namespace())
+	}
+	active := t.active
+	active.Set(entry)
+	full := t.isFull(active)
+	rotate := !full && active.wal.shouldRotate()
+	t.mu.RUnlock()
+
+	if full {
+		t.mu.Lock()
+		frozen := t.freeze(active)
+		t.mu.Unlock()
+		if frozen != nil {
+			t.wakeFlusher()
+		}
+	} else if rotate {
+		t.mu.Lock()
+		t.rotateWal(active)
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Get returns the newest version of key in the default namespace. A
+// tombstone means the key was deleted, and it hides any older version stored
+// further down.
+func (t *LsmTree) Get(key string) (DbEntry, bool) {
+	return t.GetIn(DefaultNamespace, key)
+}
+
+// GetIn returns the newest version of key in namespace.
+func (t *LsmTree) GetIn(namespace, key string) (DbEntry, bool) {
+	return t.getAt(namespace, key, math.MaxUint64)
+}
+
+func (t *LsmTree) getAt(namespace, key string, seq uint64) (DbEntry, bool) {
+	entry, found := t.lookup(namespace, key, seq)
+	if !found || entry.Tombstone() {
+		return DbEntry{}, false
+	}
+	return entry, true
+}
+
+// lookup returns the newest version of key in namespace with a sequence
+// number up to seq, with its value read from the value log if it was moved
+// there.
+func (t *LsmTree) lookup(namespace, key string, seq uint64) (DbEntry, bool) {
+	// The value is read under the lock, so the value log collector cannot
+	// delete its file in between.
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entry, found := t.find(namespace, key, seq)
+	if !found {
+		return DbEntry{}, false
+	}
+	entry, err := t.resolve(entry)
+	if err != nil {
+		t.logger.Printf("read value of %q failed: %v", key, err)
+		return DbEntry{}, false
+	}
+	return entry, true
+}
+
+// find returns the newest version of key in namespace with a sequence number
+// up to seq, as stored. Memtables and level 0 tables are visited newest
+// first, so the first version found is the one wanted. Must be called with mu
+// held.
+func (t *LsmTree) find(namespace, key string, seq uint64) (DbEntry, bool) {
+	ns, ok := t.namespaces[namespace]
+	if !ok {
+		return DbEntry{}, false
+	}
+	if entry, found := t.active.GetAt(namespace, key, seq); found {
+		return entry, true
+	}
+	for _, immutable := range t.immutables {
+		if entry, found := immutable.GetAt(namespace, key, seq); found {
+			return entry, true
+		}
+	}
+	for _, tables := range ns.levels {
+		for _, table := range tables {
+			if table.FirstKey() > key || table.LastKey() < key {
+				continue
+			}
+			t.filterStats.checks.Add(1)
+			if !table.MayContain(key) {
+				t.filterStats.skipped.Add(1)
+				continue
+			}
+			entry, found, err := table.GetAt(key, seq)
+			if err != nil {
+				t.logger.Printf("read sstable %s failed: %v", table.Path(), err)
+				continue
+			}
+			if found {
+				// Tables do not store the namespace, it is the one they
+				// belong to
+				entry.SetNamespace(namespace)
+				return entry, true
+			}
+			t.filterStats.falsePositives.Add(1)
+		}
+	}
+	return DbEntry{}, false
+}
+
+// resolve follows the pointer of an entry whose value is in the value log.
+// Expired values are never read, the collector may have reclaimed them.
+func (t *LsmTree) resolve(entry DbEntry) (DbEntry, error) {
+	if entry.External() && entry.Expired(t.now()) {
+		entry.SetValue("", false)
+		return entry, nil
+	}
+	return t.values.resolve(entry)
+}
+
+func (t *LsmTree) isFull(mem *Memtable) bool {
+	return t.threshold > 0 && mem.Size() >= t.threshold
+}
+
+// freeze swaps the active memtable for an empty one, backed by a new WAL
+// segment, if mem is still the active one. Must be called with mu held.
+func (t *LsmTree) freeze(mem *Memtable) *Memtable {
+	if t.active != mem {
+		return nil
+	}
+	w, err := t.newWal()
+	if err != nil {
+		t.logger.Printf("rotate wal failed, memtable not frozen: %v", err)
+		return nil
+	}
+	t.immutables = append([]*Memtable{mem}, t.immutables...)
+	t.active = t.newMemtable(w)
+	return mem
+}
+
+// newMemtable returns a memtable that stamps writes from the tree sequence
+// and moves large values to the tree's value log.
+func (t *LsmTree) newMemtable(w *WAL) *Memtable {
+	mem := NewMemtable(w)
+	mem.sequence = &t.sequence
+	mem.values = t.values
+	return mem
+}
+
+// rotateWal moves the active memtable to a new WAL segment once the current
+// one is too large or too old. Must be called with mu held, so no write is
+// waiting on the old segment when it is closed.
+func (t *LsmTree) rotateWal(mem *Memtable) {
+	if t.active != mem || !mem.wal.shouldRotate() {
+		return
+	}
+	w, err := t.newWal()
+	if err != nil {
+		t.logger.Printf("rotate wal failed: %v", err)
+		return
+	}
+	if err := mem.rotate(w); err != nil {
+		t.logger.Printf("close rotated wal segment failed: %v", err)
+	}
+}
+
+func (t *LsmTree) openTable(path string) (*SSTableReader, error) {
+	return OpenSSTableWith(path, t.readerOptions)
+}
+
+func (t *LsmTree) newWal() (*WAL, error) {
+	return NewWal(t.dir, t.nextFileNumber(), t.walOptions)
+}
+
+func (t *LsmTree) nextFileNumber() uint64 {
+	return t.fileNumber.Add(1)
+}
+
+// flushRetryDelay is how long the flusher waits before trying again a
+// memtable whose flush failed
+const flushRetryDelay = time.Second
+
+func (t *LsmTree) startFlusher() {
+	t.flushCh = make(chan struct{}, 1)
+	t.flushFailed = make(chan struct{})
+	t.flushWg.Add(1)
+	go func() {
+		defer t.flushWg.Done()
+		var retry <-chan time.Time
+		for {
+			select {
+			case _, open := <-t.flushCh:
+				if !open {
+					t.flushPending()
+					return
+				}
+			case <-retry:
+			}
+			retry = nil
+			if err := t.flushPending(); err != nil {
+				retry = time.After(flushRetryDelay)
+			}
+		}
+	}()
+}
+
+// wakeFlusher tells the flusher there are memtables to flush, without
+// waiting for it
+func (t *LsmTree) wakeFlusher() {
+	select {
+	case t.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// flushPending flushes the immutable memtables oldest first, the order they
+// were frozen in whoever froze them. It stops at the first one that fails, so
+// none is flushed before an older one; that one stays readable from memory
+// and is tried again later.
+func (t *LsmTree) flushPending() error {
+	for {
+		t.mu.RLock()
+		var oldest *Memtable
+		if n := len(t.immutables); n > 0 {
+			oldest = t.immutables[n-1]
+		}
+		t.mu.RUnlock()
+		if oldest == nil {
+			return nil
+		}
+		if err := t.flush(oldest); err != nil {
+			t.logger.Printf("flush memtable %s failed: %v", oldest.wal.Version(), err)
+			t.mu.Lock()
+			oldest.flushErr = err
+			close(t.flushFailed)
+			t.flushFailed = make(chan struct{})
+			t.mu.Unlock()
+			return err
+		}
+		close(oldest.flushed)
+	}
+}
+
+// flush writes the entries of each namespace in the memtable to a level 0
+// table of their own, all of them added to the manifest in a single edit.
+func (t *LsmTree) flush(frozen *Memtable) error {
+	// Flushing past an older memtable would let flushedLog cover segments
+	// that are not in any table yet, and recovery would retire them
+	t.mu.RLock()
+	oldest := len(t.immutables) > 0 && t.immutables[len(t.immutables)-1] == frozen
+	t.mu.RUnlock()
+	if !oldest {
+		return fmt.Errorf("memtable %s is not the oldest one waiting to be flushed", frozen.wal.Version())
+	}
+	// The tables make the pointers to the value log durable, so the values
+	// must be too.
+	if err := t.values.sync(); err != nil {
+		return err
+	}
+	// Versions no snapshot can see are dropped. Tombstones are kept, older
+	// versions of their keys may live in other tables.
+	snapshots := t.snapshots.sequences()
+	var tables []*SSTableReader
+	var owners []*namespace
+	for _, name := range frozen.namespaces() {
+		t.mu.RLock()
+		ns, ok := t.namespaces[name]
+		t.mu.RUnlock()
+		if !ok {
+			// Dropped since the memtable was frozen
+			continue
+		}
+		var entries []DbEntry
+		versionGroups(frozen.All(name), func(versions []DbEntry) {
+			entries = append(entries, retainVersions(versions, snapshots)...)
+		})
+		if len(entries) == 0 {
+			continue
+		}
+		// The default namespace's table is named after the WAL segment,
+		// like before namespaces existed
+		version := frozen.wal.Version()
+		if name != DefaultNamespace {
+			version = formatVersion(t.nextFileNumber())
+		}
+		path := sstPath(t.dir, version)
+		if _, err := ns.writer.Write(path, 0, entries); err != nil {
+			return t.discard(tables, err)
+		}
+		table, err := t.openTable(path)
+		if err != nil {
+			return t.discard(tables, err)
+		}
+		tables = append(tables, table)
+		owners = append(owners, ns)
+	}
+
+	t.versionMu.Lock()
+	defer t.versionMu.Unlock()
+	edit := versionEdit{flushedLog: frozen.wal.Version(), nextFile: t.fileNumber.Load()}
+	var kept []*SSTableReader
+	var keptOwners []*namespace
+	for i, table := range tables {
+		if owners[i].dropped {
+			t.discard([]*SSTableReader{table}, nil)
+			continue
+		}
+		kept = append(kept, table)
+		keptOwners = append(keptOwners, owners[i])
+		edit.added = append(edit.added, tableFile{level: 0, name: table.Name(), namespace: storedNamespace(owners[i].settings.Name)})
+	}
+	if err := t.manifest.log(edit); err != nil {
+		return t.discard(kept, err)
+	}
+
+	// The tables are durable, the log that produced them is no longer
+	// needed. It is retired before they are published so a compaction can
+	// never consume a table while its log could still be replayed on
+	// restart.
+	if err := frozen.Close(); err != nil {
+		return err
+	}
+	for _, segment := range append(frozen.segments, frozen.wal.Path()) {
+		if err := retireSegment(segment, t.walOptions.ArchiveDir); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	for i, table := range kept {
+		ns := keptOwners[i]
+		ns.levels[0] = append([]*SSTableReader{table}, ns.levels[0]...)
+	}
+	for i, immutable := range t.immutables {
+		if immutable == frozen {
+			t.immutables = append(t.immutables[:i], t.immutables[i+1:]...)
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	t.scheduleCompaction()
+	return nil
+}
+
+func (t *LsmTree) Close() error {
+	t.stopValueLogGC()
+	if t.flushCh != nil {
+		close(t.flushCh)
+		t.flushWg.Wait()
+	}
+	if t.compactCh != nil {
+		close(t.compactCh)
+		t.compactWg.Wait()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ns := range t.namespaces {
+		for _, tables := range ns.levels {
+			for _, table := range tables {
+				table.Close()
+			}
+		}
+	}
+	if t.readerOptions.TableCache != nil {
+		t.readerOptions.TableCache.Close()
+	}
+	if err := t.values.Close(); err != nil {
+		t.logger.Printf("close value log failed: %v", err)
+	}
+	if err := t.manifest.Close(); err != nil {
+		t.logger.Printf("close manifest failed: %v", err)
+	}
+	// Left by flushes that kept failing, replayed on the next open
+	for _, immutable := range t.immutables {
+		if err := immutable.Close(); err != nil {
+			t.logger.Printf("close wal %s failed: %v", immutable.wal.Version(), err)
+		}
+	}
+	return t.active.Close()
 }
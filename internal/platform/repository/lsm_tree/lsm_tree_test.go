@@ -0,0 +1,244 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForFlush(t *testing.T, tree *LsmTree) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		tree.mu.RLock()
+		pending := len(tree.immutables)
+		tree.mu.RUnlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("la memtable no se volcó a disco")
+}
+
+func writeTable(t *testing.T, dir, name string, entries ...DbEntry) *SSTableReader {
+	file := path.Join(dir, name)
+	if _, err := NewSSTableWriter(SSTableOptions{}).Write(file, 0, entries); err != nil {
+		t.Fatalf("error escribiendo sstable: %v", err)
+	}
+	table, err := OpenSSTable(file)
+	if err != nil {
+		t.Fatalf("error abriendo sstable: %v", err)
+	}
+	t.Cleanup(func() {
+		table.Close()
+	})
+	return table
+}
+
+// defaultNamespace no toma el lock: el espacio por defecto nunca se borra
+func defaultNamespace(tree *LsmTree) *namespace {
+	return tree.namespaces[DefaultNamespace]
+}
+
+func defaultLevels(tree *LsmTree) [][]*SSTableReader {
+	return defaultNamespace(tree).levels
+}
+
+func setLevel(tree *LsmTree, level int, tables ...*SSTableReader) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defaultLevels(tree)[level] = tables
+}
+
+func TestLsmTree_FlushesToSSTableWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{WalDirectory: dir, MemtableSizeThreshold: 1024}
+	tree, err := OpenLsmTree(conf)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "some value", false))
+	}
+	tree.Set(NewDbEntry("key-000", "", true))
+	waitForFlush(t, tree)
+
+	tables, _ := ListSSTables(dir)
+	assert.NotEmpty(t, tables)
+	segments, _ := ListWalSegments(dir)
+	assert.Len(t, segments, 1, "solo debe quedar el segmento de la memtable activa")
+
+	got, found := tree.Get("key-001")
+	assert.True(t, found)
+	assert.Equal(t, "some value", got.Value())
+	_, found = tree.Get("key-000")
+	assert.False(t, found)
+	assert.NoError(t, tree.Close())
+
+	// reinicio: los datos vuelven desde las SSTables y el WAL restante
+	recovered := openTree(t, conf)
+	for i := 1; i < 100; i++ {
+		got, found := recovered.Get(fmt.Sprintf("key-%03d", i))
+		assert.True(t, found)
+		assert.Equal(t, "some value", got.Value())
+	}
+	_, found = recovered.Get("key-000")
+	assert.False(t, found)
+}
+
+// blockFlush hace fallar el volcado de la memtable activa: un directorio
+// ocupa el nombre de su sstable
+func blockFlush(t *testing.T, tree *LsmTree) string {
+	tree.mu.RLock()
+	blocked := sstPath(tree.dir, tree.active.wal.Version())
+	tree.mu.RUnlock()
+	assert.NoError(t, os.MkdirAll(path.Join(blocked, "ocupado"), 0755))
+	return blocked
+}
+
+// freezeActive congela la memtable activa como lo hace Set al llenarse
+func freezeActive(tree *LsmTree) {
+	tree.mu.Lock()
+	tree.freeze(tree.active)
+	tree.mu.Unlock()
+	tree.wakeFlusher()
+}
+
+func TestLsmTree_RetriesFailedFlushInFreezeOrder(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+
+	tree.Set(NewDbEntry("k1", "v1", false))
+	blocked := blockFlush(t, tree)
+	freezeActive(tree)
+	tree.Set(NewDbEntry("k2", "v2", false))
+	freezeActive(tree)
+
+	time.Sleep(100 * time.Millisecond)
+	tree.mu.RLock()
+	assert.Len(t, tree.immutables, 2, "la segunda no se vuelca antes que la primera")
+	assert.Error(t, tree.immutables[1].flushErr)
+	tree.mu.RUnlock()
+	assert.Empty(t, defaultLevels(tree)[0])
+	_, found := tree.Get("k1")
+	assert.True(t, found, "sigue legible desde memoria")
+
+	assert.NoError(t, os.RemoveAll(blocked))
+	waitForFlush(t, tree)
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	level0 := defaultLevels(tree)[0]
+	assert.Len(t, level0, 2)
+	_, found, _ = level0[0].Get("k2")
+	assert.True(t, found, "la más nueva queda primero")
+}
+
+func TestLsmTree_GetSearchesNewestFirst(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+
+	// nivel 1: rangos disjuntos y ordenados
+	setLevel(tree, 1,
+		writeTable(t, dir, "l1-a.sst", NewDbEntry("a", "l1", false), NewDbEntry("c", "l1", false)),
+		writeTable(t, dir, "l1-b.sst", NewDbEntry("m", "l1", false), NewDbEntry("z", "l1", false)),
+	)
+	// nivel 0: la tabla más reciente va primero
+	setLevel(tree, 0,
+		writeTable(t, dir, "l0-new.sst", NewDbEntry("c", "", true), NewDbEntry("m", "l0-new", false)),
+		writeTable(t, dir, "l0-old.sst", NewDbEntry("a", "l0-old", false), NewDbEntry("m", "l0-old", false)),
+	)
+	tree.Set(NewDbEntry("z", "memtable", false))
+
+	cases := map[string]string{
+		"a": "l0-old",
+		"m": "l0-new",
+		"z": "memtable",
+	}
+	for key, expected := range cases {
+		got, found := tree.Get(key)
+		assert.True(t, found, key)
+		assert.Equal(t, expected, got.Value(), key)
+	}
+
+	_, found := tree.Get("c")
+	assert.False(t, found, "la tombstone oculta la versión de niveles inferiores")
+	_, found = tree.Get("b")
+	assert.False(t, found)
+}
+
+func TestLsmTree_BloomFilterSkipsAbsentKeys(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir})
+	var entries []DbEntry
+	for i := 0; i < 1000; i += 2 {
+		entries = append(entries, NewDbEntry(fmt.Sprintf("key-%03d", i), "value", false))
+	}
+	setLevel(tree, 0, writeTable(t, dir, "a.sst", entries...))
+
+	// solo las claves pares están en la tabla
+	for i := 1; i < 1000; i += 2 {
+		_, found := tree.Get(fmt.Sprintf("key-%03d", i))
+		assert.False(t, found)
+	}
+	_, found := tree.Get("key-998")
+	assert.True(t, found)
+
+	stats := tree.BloomFilterStats()
+	assert.Equal(t, int64(500), stats.Checks, "key-999 queda fuera del rango de la tabla")
+	assert.Greater(t, stats.SkippedReads, int64(450))
+	assert.Equal(t, stats.Checks-1, stats.SkippedReads+stats.FalsePositives)
+}
+
+func TestLsmTree_RotatesWalBySize(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, WalMaxSegmentSize: 512})
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "some value", false))
+	}
+
+	segments, _ := ListWalSegments(dir)
+	assert.Greater(t, len(segments), 3)
+	tree.mu.RLock()
+	rotated := len(tree.active.segments)
+	tree.mu.RUnlock()
+	assert.Equal(t, len(segments)-1, rotated, "la memtable activa debe conservar los segmentos rotados")
+
+	// los segmentos se reproducen en orden tras un reinicio
+	assert.NoError(t, tree.Close())
+	recovered := recoverTree(t, dir)
+	for i := 0; i < 100; i++ {
+		_, found := recovered.Get(fmt.Sprintf("key-%03d", i))
+		assert.True(t, found)
+	}
+}
+
+func TestLsmTree_ArchivesRetiredSegments(t *testing.T) {
+	dir := t.TempDir()
+	archive := path.Join(t.TempDir(), "archive")
+	tree := openTree(t, config.Config{
+		WalDirectory:          dir,
+		WalArchiveDirectory:   archive,
+		WalMaxSegmentSize:     512,
+		MemtableSizeThreshold: 1024,
+	})
+
+	for i := 0; i < 100; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "some value", false))
+	}
+	waitForFlush(t, tree)
+
+	archived, err := ListWalSegments(archive)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, archived)
+	segments, _ := ListWalSegments(dir)
+	for _, segment := range segments {
+		assert.NotContains(t, archived, path.Join(archive, path.Base(segment)))
+	}
+}
@@ -0,0 +1,442 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	manifestPrefix      = "MANIFEST-"
+	currentManifestFile = "CURRENT"
+
+	manifestMagicNumber   = 0x4b564d46 // "KVMF"
+	manifestFormatVersion = 1
+	manifestHeaderSize    = 8
+
+	// maxManifestSize is the size past which the manifest is rewritten as a
+	// single edit holding the current version
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+// Fields of a version edit, each starting with its tag
+const (
+	editFlushedLog byte = iota + 1
+	editNextFile
+	editAddTable
+	editRemoveTable
+	editAddNamespace
+	editDropNamespace
+	// editAddNamespaceTable adds a table outside the default namespace
+	editAddNamespaceTable
+)
+
+var ErrCorruptedManifest = errors.New("corrupted manifest")
+
+// versionEdit is a change to the set of live tables, applied atomically: a
+// flush adds a table per namespace and a compaction swaps its inputs for its
+// outputs. Creating or dropping a namespace is an edit as well.
+type versionEdit struct {
+	added   []tableFile
+	removed []string
+	// created and dropped are namespaces other than the default one
+	created []namespaceInfo
+	dropped []string
+	// flushedLog is the newest WAL segment whose entries are in the tables.
+	// Memtables are flushed in the order they were frozen, so every older
+	// segment is in them too.
+	flushedLog string
+	// nextFile is the last file number handed out
+	nextFile uint64
+}
+
+type tableFile struct {
+	level int
+	name  string
+	// namespace is empty for the default one
+	namespace string
+}
+
+// namespaceInfo is a namespace as the manifest records it. Since is the last
+// sequence number handed out before it was created: WAL records of its name
+// up to it belong to a namespace of the same name dropped before.
+type namespaceInfo struct {
+	settings Namespace
+	since    uint64
+}
+
+// versionState is the result of applying every edit of the manifest.
+type versionState struct {
+	tables map[string]int // live table file name to its level
+	// tableNamespaces maps the tables outside the default namespace to
+	// theirs
+	tableNamespaces map[string]string
+	namespaces      map[string]namespaceInfo
+	flushedLog      string
+	nextFile        uint64
+}
+
+func newVersionState() versionState {
+	return versionState{
+		tables:          make(map[string]int),
+		tableNamespaces: make(map[string]string),
+		namespaces:      make(map[string]namespaceInfo),
+	}
+}
+
+func (s *versionState) apply(edit versionEdit) {
+	for _, name := range edit.removed {
+		delete(s.tables, name)
+		delete(s.tableNamespaces, name)
+	}
+	for _, namespace := range edit.dropped {
+		delete(s.namespaces, namespace)
+		for name, owner := range s.tableNamespaces {
+			if owner == namespace {
+				delete(s.tables, name)
+				delete(s.tableNamespaces, name)
+			}
+		}
+	}
+	for _, namespace := range edit.created {
+		s.namespaces[namespace.settings.Name] = namespace
+	}
+	for _, table := range edit.added {
+		s.tables[table.name] = table.level
+		if table.namespace != "" {
+			s.tableNamespaces[table.name] = table.namespace
+		}
+	}
+	if edit.flushedLog != "" && (s.flushedLog == "" || compareWalVersions(edit.flushedLog, s.flushedLog) > 0) {
+		s.flushedLog = edit.flushedLog
+	}
+	s.nextFile = max(s.nextFile, edit.nextFile)
+}
+
+// snapshot returns the edit that builds the whole state from scratch.
+func (s *versionState) snapshot() versionEdit {
+	edit := versionEdit{flushedLog: s.flushedLog, nextFile: s.nextFile}
+	for _, namespace := range s.namespaces {
+		edit.created = append(edit.created, namespace)
+	}
+	for name, level := range s.tables {
+		edit.added = append(edit.added, tableFile{level: level, name: name, namespace: s.tableNamespaces[name]})
+	}
+	return edit
+}
+
+func (e *versionEdit) encode() []byte {
+	var buf []byte
+	if e.flushedLog != "" {
+		buf = append(buf, editFlushedLog)
+		buf = appendString(buf, e.flushedLog)
+	}
+	buf = append(buf, editNextFile)
+	buf = binary.LittleEndian.AppendUint64(buf, e.nextFile)
+	// Namespaces come first, so their tables are added to a namespace that
+	// exists
+	for _, name := range e.dropped {
+		buf = append(buf, editDropNamespace)
+		buf = appendString(buf, name)
+	}
+	for _, namespace := range e.created {
+		buf = append(buf, editAddNamespace)
+		buf = appendString(buf, namespace.settings.Name)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(namespace.settings.DefaultTTL))
+		buf = appendString(buf, namespace.settings.Compression)
+		buf = appendString(buf, namespace.settings.ConflictResolver)
+		buf = binary.LittleEndian.AppendUint64(buf, namespace.since)
+	}
+	for _, table := range e.added {
+		if table.namespace == "" {
+			buf = append(buf, editAddTable)
+		} else {
+			buf = append(buf, editAddNamespaceTable)
+			buf = appendString(buf, table.namespace)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(table.level))
+		buf = appendString(buf, table.name)
+	}
+	for _, name := range e.removed {
+		buf = append(buf, editRemoveTable)
+		buf = appendString(buf, name)
+	}
+	return buf
+}
+
+func decodeVersionEdit(buf []byte) (versionEdit, error) {
+	var edit versionEdit
+	d := decoder{buf: buf}
+	for d.err == nil && d.pos < len(buf) {
+		switch tag := d.byte(); tag {
+		case editFlushedLog:
+			edit.flushedLog = d.string()
+		case editNextFile:
+			edit.nextFile = d.uint64()
+		case editAddTable:
+			level := int(d.uint32())
+			edit.added = append(edit.added, tableFile{level: level, name: d.string()})
+		case editAddNamespaceTable:
+			namespace := d.string()
+			level := int(d.uint32())
+			edit.added = append(edit.added, tableFile{level: level, name: d.string(), namespace: namespace})
+		case editRemoveTable:
+			edit.removed = append(edit.removed, d.string())
+		case editAddNamespace:
+			var namespace namespaceInfo
+			namespace.settings.Name = d.string()
+			namespace.settings.DefaultTTL = time.Duration(d.uint64())
+			namespace.settings.Compression = d.string()
+			namespace.settings.ConflictResolver = d.string()
+			namespace.since = d.uint64()
+			edit.created = append(edit.created, namespace)
+		case editDropNamespace:
+			edit.dropped = append(edit.dropped, d.string())
+		default:
+			return versionEdit{}, fmt.Errorf("%w: unknown edit tag %d", ErrCorruptedManifest, tag)
+		}
+	}
+	if d.err != nil {
+		return versionEdit{}, fmt.Errorf("%w: %v", ErrCorruptedManifest, d.err)
+	}
+	return edit, nil
+}
+
+// manifest logs the version edits of a tree. CURRENT names the manifest file
+// in use; a new file is started on open and whenever the log grows too
+// large, holding the current version as its first edit, and CURRENT is
+// switched to it atomically. Manifest files are numbered on their own, apart
+// from the other files of the tree.
+type manifest struct {
+	dir     string
+	maxSize int64
+
+	mu     sync.Mutex
+	fd     *os.File
+	number uint64
+	size   int64
+	state  versionState
+	// err is sticky: after a failed write the file may end in a partial
+	// record, and edits appended after it would be lost on replay
+	err error
+}
+
+func manifestPath(dir string, number uint64) string {
+	return path.Join(dir, manifestPrefix+formatVersion(number))
+}
+
+// openManifest starts a new manifest file holding state, numbered after the
+// one found on disk.
+func openManifest(dir string, state versionState, number uint64) (*manifest, error) {
+	m := &manifest{dir: dir, maxSize: maxManifestSize, number: number, state: state}
+	if err := m.rewrite(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// log makes edit durable and applies it. The tables it adds must already
+// be synced under their final name.
+func (m *manifest) log(edit versionEdit) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if len(edit.added) > 0 {
+		// The renames that gave the new tables their names must be durable
+		if err := syncDir(m.dir); err != nil {
+			return err
+		}
+	}
+	edit.nextFile = max(edit.nextFile, m.state.nextFile)
+	record := encodeManifestRecord(edit.encode())
+	if _, err := m.fd.Write(record); err != nil {
+		m.err = err
+		return err
+	}
+	if err := m.fd.Sync(); err != nil {
+		m.err = err
+		return err
+	}
+	m.size += int64(len(record))
+	m.state.apply(edit)
+	if m.size < m.maxSize {
+		return nil
+	}
+	return m.rewrite()
+}
+
+// rewrite starts a new manifest file with the current state and retires the
+// previous ones. Must be called with mu held, or before the manifest is
+// shared.
+func (m *manifest) rewrite() error {
+	fd, size, err := writeManifest(m.dir, m.number+1, m.state)
+	if err != nil {
+		return err
+	}
+	if m.fd != nil {
+		m.fd.Close()
+	}
+	m.number++
+	m.fd, m.size = fd, size
+	return removeOldManifests(m.dir, m.number)
+}
+
+// writeManifest creates a manifest file holding state and makes CURRENT
+// point to it. The file is returned open for the edits that follow.
+func writeManifest(dir string, number uint64, state versionState) (*os.File, int64, error) {
+	name := manifestPath(dir, number)
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, manifestHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], manifestMagicNumber)
+	binary.LittleEndian.PutUint32(header[4:], manifestFormatVersion)
+	snapshot := state.snapshot()
+	buf := append(header, encodeManifestRecord(snapshot.encode())...)
+	if _, err := fd.Write(buf); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+
+	tmp := path.Join(dir, currentManifestFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(path.Base(name)+"\n"), 0644); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	if err := syncFile(tmp); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	if err := os.Rename(tmp, path.Join(dir, currentManifestFile)); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	if err := syncDir(dir); err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	return fd, int64(len(buf)), nil
+}
+
+func removeOldManifests(dir string, current uint64) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), manifestPrefix) && f.Name() != path.Base(manifestPath(dir, current)) {
+			if err := os.Remove(path.Join(dir, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encodeManifestRecord(payload []byte) []byte {
+	buf := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(payload)))
+	buf = append(buf, payload...)
+	binary.LittleEndian.PutUint32(buf[0:], crc32.Checksum(buf[4:], castagnoli))
+	return buf
+}
+
+// readManifest rebuilds the version from the manifest CURRENT points to and
+// returns its number. The number is 0 when there is none, as in directories
+// written before the manifest existed. A torn last record is an edit that was
+// never applied and is ignored.
+func readManifest(dir string) (versionState, uint64, error) {
+	current, err := os.ReadFile(path.Join(dir, currentManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return versionState{}, 0, nil
+	}
+	if err != nil {
+		return versionState{}, 0, err
+	}
+	name := strings.TrimSpace(string(current))
+	number, ok := versionNumber(strings.TrimPrefix(name, manifestPrefix))
+	if !ok || !strings.HasPrefix(name, manifestPrefix) || number == 0 {
+		return versionState{}, 0, fmt.Errorf("%w: CURRENT names %q", ErrCorruptedManifest, name)
+	}
+	fd, err := os.Open(path.Join(dir, name))
+	if err != nil {
+		return versionState{}, 0, fmt.Errorf("%w: CURRENT names %s: %v", ErrCorruptedManifest, name, err)
+	}
+	defer fd.Close()
+
+	reader := bufio.NewReader(fd)
+	header := make([]byte, manifestHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil ||
+		binary.LittleEndian.Uint32(header[0:]) != manifestMagicNumber ||
+		binary.LittleEndian.Uint32(header[4:]) != manifestFormatVersion {
+		return versionState{}, 0, fmt.Errorf("%w: %s has a bad header", ErrCorruptedManifest, name)
+	}
+	state := newVersionState()
+	recordHeader := make([]byte, 8)
+	records := 0
+	for ; ; records++ {
+		if _, err := io.ReadFull(reader, recordHeader); err != nil {
+			break
+		}
+		payload, err := readPayload(reader, int(binary.LittleEndian.Uint32(recordHeader[4:])))
+		if err != nil {
+			break
+		}
+		crc := crc32.Update(crc32.Checksum(recordHeader[4:], castagnoli), castagnoli, payload)
+		if crc != binary.LittleEndian.Uint32(recordHeader[0:]) {
+			break
+		}
+		edit, err := decodeVersionEdit(payload)
+		if err != nil {
+			return versionState{}, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		state.apply(edit)
+	}
+	if records == 0 {
+		// The first record is synced before CURRENT points to the file
+		return versionState{}, 0, fmt.Errorf("%w: %s holds no version", ErrCorruptedManifest, name)
+	}
+	return state, number, nil
+}
+
+func syncFile(name string) error {
+	fd, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
+
+func (m *manifest) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fd == nil {
+		return nil
+	}
+	err := m.fd.Close()
+	m.fd = nil
+	return err
+}
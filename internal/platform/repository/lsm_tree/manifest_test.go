@@ -0,0 +1,179 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func levelsOf(tree *LsmTree) map[string]int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	levels := make(map[string]int)
+	for _, ns := range tree.namespaces {
+		for level, tables := range ns.levels {
+			for _, table := range tables {
+				levels[table.Name()] = level
+			}
+		}
+	}
+	return levels
+}
+
+func TestVersionEdit_RoundTrip(t *testing.T) {
+	edit := versionEdit{
+		added:      []tableFile{{level: 0, name: "sst-0000000003.sst"}, {level: 2, name: "sst-0000000004.sst"}},
+		removed:    []string{"sst-0000000001.sst"},
+		flushedLog: "0000000002",
+		nextFile:   4,
+	}
+	decoded, err := decodeVersionEdit(edit.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, edit, decoded)
+
+	_, err = decodeVersionEdit([]byte{99})
+	assert.ErrorIs(t, err, ErrCorruptedManifest)
+}
+
+func TestVersionEdit_RoundTripsNamespaces(t *testing.T) {
+	edit := versionEdit{
+		created: []namespaceInfo{{settings: Namespace{Name: "a", DefaultTTL: time.Second, Compression: "none", ConflictResolver: LastWriterWins}, since: 9}},
+		dropped: []string{"b"},
+		added:   []tableFile{{level: 1, name: "sst-0000000010.sst", namespace: "a"}},
+	}
+	decoded, err := decodeVersionEdit(edit.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, edit, decoded)
+
+	state := newVersionState()
+	state.apply(versionEdit{created: []namespaceInfo{{settings: Namespace{Name: "b"}}}, added: []tableFile{{name: "b.sst", namespace: "b"}}})
+	state.apply(edit)
+	assert.Equal(t, map[string]int{"sst-0000000010.sst": 1}, state.tables, "borrar un espacio quita sus tablas")
+	assert.Equal(t, map[string]string{"sst-0000000010.sst": "a"}, state.tableNamespaces)
+	assert.Contains(t, state.namespaces, "a")
+	assert.NotContains(t, state.namespaces, "b")
+}
+
+func TestManifest_ReplaysEditsAndIgnoresTornTail(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManifest(dir, newVersionState(), 0)
+	assert.NoError(t, err)
+	assert.NoError(t, m.log(versionEdit{added: []tableFile{{level: 0, name: "a.sst"}, {level: 0, name: "b.sst"}}, flushedLog: "0000000002"}))
+	assert.NoError(t, m.log(versionEdit{added: []tableFile{{level: 1, name: "c.sst"}}, removed: []string{"a.sst", "b.sst"}, nextFile: 7}))
+	assert.NoError(t, m.Close())
+
+	// Un registro a medio escribir no llegó a aplicarse
+	appendToFile(t, manifestPath(dir, 1), []byte{1, 2, 3, 4, 5})
+
+	state, number, err := readManifest(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), number)
+	assert.Equal(t, map[string]int{"c.sst": 1}, state.tables)
+	assert.Equal(t, "0000000002", state.flushedLog)
+	assert.Equal(t, uint64(7), state.nextFile)
+}
+
+func TestManifest_RewritesWhenTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManifest(dir, newVersionState(), 0)
+	assert.NoError(t, err)
+	m.maxSize = 256
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, m.log(versionEdit{added: []tableFile{{level: 0, name: sstName(i)}}}))
+	}
+	assert.Greater(t, m.number, uint64(1), "el manifiesto debe haberse reescrito")
+	assert.NoError(t, m.Close())
+
+	files, _ := os.ReadDir(dir)
+	manifests := 0
+	for _, f := range files {
+		if path.Ext(f.Name()) != ".tmp" && f.Name() != currentManifestFile {
+			manifests++
+		}
+	}
+	assert.Equal(t, 1, manifests, "solo queda el manifiesto en uso")
+
+	state, number, err := readManifest(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, m.number, number)
+	assert.Len(t, state.tables, 20)
+}
+
+func sstName(i int) string {
+	return path.Base(sstPath("", formatVersion(uint64(i+1))))
+}
+
+func TestOpenLsmTree_RemovesTablesNotInManifest(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.NoError(t, err)
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+	assert.NoError(t, tree.Close())
+
+	// Salida de un volcado o una compactación que no llegó al manifiesto
+	orphan := sstPath(dir, formatVersion(99))
+	_, err = defaultNamespace(tree).writer.Write(orphan, 0, []DbEntry{NewDbEntry("a", "huérfana", false)})
+	assert.NoError(t, err)
+	tmp := sstPath(dir, formatVersion(100)) + ".tmp"
+	assert.NoError(t, os.WriteFile(tmp, []byte("a medias"), 0644))
+
+	reopened := openTree(t, config.Config{WalDirectory: dir})
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err), "la tabla huérfana debe borrarse al arrancar")
+	_, err = os.Stat(tmp)
+	assert.True(t, os.IsNotExist(err))
+	got, found := reopened.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, "1", got.Value())
+}
+
+func TestOpenLsmTree_FailsWhenLiveTableIsMissing(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.NoError(t, err)
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+	assert.NoError(t, tree.Close())
+
+	tables, _ := ListSSTables(dir)
+	assert.NoError(t, os.Remove(tables[0]))
+	_, err = OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.ErrorIs(t, err, ErrCorruptedManifest)
+
+	report, err := Fsck(dir, true)
+	assert.NoError(t, err)
+	assert.Len(t, report.Issues, 1)
+	assert.True(t, report.Healthy(), "fsck quita la tabla del manifiesto")
+	openTree(t, config.Config{WalDirectory: dir})
+}
+
+func TestManifest_KeepsLevelsAcrossCompactionAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{WalDirectory: dir, MemtableSizeThreshold: 2048, CompactionPolicy: LeveledCompaction}
+	tree, err := OpenLsmTree(conf)
+	assert.NoError(t, err)
+	fillTree(tree)
+	waitForCompaction(t, tree)
+	levels := levelsOf(tree)
+	assert.NoError(t, tree.Close())
+
+	state, _, err := readManifest(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, levels, state.tables, "el manifiesto debe listar las tablas vivas con su nivel")
+
+	reopened := openTree(t, conf)
+	assert.Equal(t, levels, levelsOf(reopened))
+	assertTreeContents(t, reopened)
+}
@@ -3,37 +3,217 @@ package lsm_tree
 import (
 	. "KVDB/internal/domain"
 	"log"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 )
 
+// Memtable reads go straight to the skiplists, which are safe for concurrent
+// use. writeMu only orders writers, so the WAL and the skiplists see them in
+// the same order. Every namespace written to gets a skiplist of its own,
+// while all of them share the WAL.
 type Memtable struct {
-	mu       sync.RWMutex
-	skiplist *SkipList
+	writeMu   sync.Mutex
+	listsMu   sync.RWMutex
+	skiplists map[string]*SkipList
+	// sequence holds the last sequence number applied, shared by every
+	// memtable of a tree. Writers bump it under writeMu once the entry is
+	// in the skiplist, so whatever it reads is fully visible.
+	sequence *atomic.Uint64
 	wal      *WAL
-	logger   log.Logger
+	// values receives the values too large to keep in the skiplist, nil
+	// keeps every value inline
+	values *ValueLog
+	logger *log.Logger
+
+	// segments holds older WAL segments, replayed into the skiplist on
+	// recovery or rotated out, oldest first. They are retired together with
+	// wal once the memtable is flushed.
+	segments []string
+
+	// flushed is closed once the memtable made it to an SSTable. flushErr
+	// holds why the last attempt failed, guarded by the tree's mu.
+	flushed  chan struct{}
+	flushErr error
 }
 
 func NewMemtable(wal *WAL) *Memtable {
 	return &Memtable{
-		skiplist: NewSkipList(32, 0.5),
-		wal:      wal,
+		skiplists: make(map[string]*SkipList),
+		sequence:  new(atomic.Uint64),
+		wal:       wal,
+		logger:    log.Default(),
+		flushed:   make(chan struct{}),
 	}
 }
 
+// Set returns once the entry is as durable as the WAL sync mode promises.
+// Appending to the WAL and applying to the skiplist happen under writeMu, so
+// both see writes in the same order; readers never wait on it, and neither
+// does the sync, which is what lets concurrent writers share an fsync. The
+// entry is visible to readers from the moment it is applied.
 func (mt *Memtable) Set(entry DbEntry) {
-	mt.mu.Lock()
-	defer mt.mu.Unlock()
+	mt.write(func() (DbEntry, bool) { return entry, true })
+}
 
-	mt.skiplist.Set(entry)
-	if err := mt.wal.Write(entry); err != nil {
+// write stores the entry returned by next, unless it declines. next runs
+// under writeMu, so no other write lands in between. The WAL logs the full
+// value, the skiplist keeps the pointer when it goes to the value log.
+func (mt *Memtable) write(next func() (DbEntry, bool)) bool {
+	mt.writeMu.Lock()
+	entry, ok := next()
+	if !ok {
+		mt.writeMu.Unlock()
+		return false
+	}
+	seq := mt.sequence.Load() + 1
+	entry.SetSeq(seq)
+	offset, err := mt.wal.append(entry)
+	if err != nil {
+		mt.writeMu.Unlock()
 		mt.logger.Panicf("write wal failed: %v", err)
 	}
+	if entry, err = mt.values.separate(entry); err != nil {
+		mt.writeMu.Unlock()
+		mt.logger.Panicf("write value log failed: %v", err)
+	}
+	mt.skiplistFor(entry.Namespace()).Set(entry)
+	mt.sequence.Store(seq)
+	mt.writeMu.Unlock()
+
+	if err := mt.wal.waitDurable(offset); err != nil {
+		mt.logger.Panicf("sync wal failed: %v", err)
+	}
 	//mt.logger.Printf("Memtable set [key: %v] [value: %v] [tombstone: %v]", entry.Key(), string(entry.Value()), entry.Tombstone())
+	return true
 }
 
+// Get returns the newest version of key in the default namespace,
+// tombstones included.
 func (mt *Memtable) Get(key string) (DbEntry, bool) {
-	mt.mu.RLock()
-	defer mt.mu.RUnlock()
+	return mt.GetAt(DefaultNamespace, key, math.MaxUint64)
+}
+
+// GetAt returns the newest version of key in namespace visible at sequence
+// number seq.
+func (mt *Memtable) GetAt(namespace, key string, seq uint64) (DbEntry, bool) {
+	if list := mt.skiplist(namespace); list != nil {
+		return list.GetAt(key, seq)
+	}
+	return DbEntry{}, false
+}
+
+func (mt *Memtable) skiplist(namespace string) *SkipList {
+	mt.listsMu.RLock()
+	defer mt.listsMu.RUnlock()
+	return mt.skiplists[namespace]
+}
+
+// skiplistFor returns the skiplist of namespace, creating it on its first
+// write.
+func (mt *Memtable) skiplistFor(namespace string) *SkipList {
+	if list := mt.skiplist(namespace); list != nil {
+		return list
+	}
+	mt.listsMu.Lock()
+	defer mt.listsMu.Unlock()
+	list, ok := mt.skiplists[namespace]
+	if !ok {
+		list = NewSkipList(32, 0.5)
+		mt.skiplists[namespace] = list
+	}
+	return list
+}
+
+// namespaces returns the namespaces holding entries, sorted by name.
+func (mt *Memtable) namespaces() []string {
+	mt.listsMu.RLock()
+	defer mt.listsMu.RUnlock()
+	names := make([]string, 0, len(mt.skiplists))
+	for name := range mt.skiplists {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// drop forgets the entries of a dropped namespace. They stay in the WAL,
+// where replay skips them.
+func (mt *Memtable) drop(namespace string) {
+	mt.listsMu.Lock()
+	defer mt.listsMu.Unlock()
+	delete(mt.skiplists, namespace)
+}
+
+// apply stores an entry that is already persisted in the WAL, keeping its
+// sequence number. Entries logged before writes were sequenced are stamped
+// in replay order.
+func (mt *Memtable) apply(entry DbEntry) {
+	mt.writeMu.Lock()
+	defer mt.writeMu.Unlock()
+
+	if entry.Seq() == 0 {
+		entry.SetSeq(mt.sequence.Load() + 1)
+	}
+	entry, err := mt.values.separate(entry)
+	if err != nil {
+		mt.logger.Panicf("write value log failed: %v", err)
+	}
+	mt.skiplistFor(entry.Namespace()).Set(entry)
+	if entry.Seq() > mt.sequence.Load() {
+		mt.sequence.Store(entry.Seq())
+	}
+}
+
+func (mt *Memtable) Size() int {
+	mt.listsMu.RLock()
+	defer mt.listsMu.RUnlock()
+	size := 0
+	for _, list := range mt.skiplists {
+		size += list.Size()
+	}
+	return size
+}
+
+// All returns every version of namespace in key order, newest first for
+// equal keys, tombstones included.
+func (mt *Memtable) All(namespace string) []DbEntry {
+	if list := mt.skiplist(namespace); list != nil {
+		return list.All()
+	}
+	return nil
+}
+
+// Range returns a copy of every version of the keys of namespace with
+// start <= key < end, tombstones included.
+func (mt *Memtable) Range(namespace, start, end string) []DbEntry {
+	if list := mt.skiplist(namespace); list != nil {
+		return list.Range(start, end)
+	}
+	return nil
+}
+
+// rotate switches to a new WAL segment and closes the current one, which
+// syncs whatever it still holds.
+func (mt *Memtable) rotate(w *WAL) error {
+	mt.writeMu.Lock()
+	old := mt.wal
+	mt.wal = w
+	mt.segments = append(mt.segments, old.Path())
+	mt.writeMu.Unlock()
+
+	return old.Close()
+}
+
+// sync makes every write logged so far durable, whatever the sync mode.
+func (mt *Memtable) sync() error {
+	mt.writeMu.Lock()
+	w := mt.wal
+	mt.writeMu.Unlock()
+	return w.Sync()
+}
 
-	return mt.skiplist.Get(key)
+func (mt *Memtable) Close() error {
+	return mt.wal.Close()
 }
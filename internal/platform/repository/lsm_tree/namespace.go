@@ -0,0 +1,202 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// errNamespaceDropped stops a compaction from installing tables into a
+// namespace dropped while it ran.
+var errNamespaceDropped = errors.New("namespace dropped")
+
+// namespace holds the tables of one namespace and how they are written. Its
+// entries still in memory live in the memtables, next to those of the other
+// namespaces, and share their WAL.
+type namespace struct {
+	settings Namespace
+	// since is the last sequence number handed out before it was created
+	since uint64
+
+	// levels[0] holds flushed tables, newest first. Whether deeper levels
+	// overlap, and so how they are ordered, depends on the compaction policy.
+	levels [][]*SSTableReader
+	policy CompactionPolicy
+	writer *SSTableWriter
+
+	// compactMu is held by the compaction running on the namespace, so a
+	// drop does not delete the tables it reads
+	compactMu sync.Mutex
+	// dropped is set, with versionMu and the tree's mu held, once the drop
+	// is in the manifest
+	dropped bool
+}
+
+func (t *LsmTree) newNamespace(settings Namespace, since uint64) (*namespace, error) {
+	policy, err := NewCompactionPolicy(t.policyName)
+	if err != nil {
+		return nil, err
+	}
+	options := t.writerOptions
+	if settings.Compression != "" {
+		if options.Compression, err = ParseCodec(settings.Compression); err != nil {
+			return nil, err
+		}
+	}
+	return &namespace{
+		settings: settings,
+		since:    since,
+		levels:   make([][]*SSTableReader, maxLevels),
+		policy:   policy,
+		writer:   NewSSTableWriter(options),
+	}, nil
+}
+
+// storedNamespace is the name a namespace is stored under in WAL records,
+// value log records and the manifest, empty for the default one.
+func storedNamespace(name string) string {
+	if name == DefaultNamespace {
+		return ""
+	}
+	return name
+}
+
+// sortedNamespaces returns the namespaces ordered by name. Must be called
+// with mu held.
+func (t *LsmTree) sortedNamespaces() []*namespace {
+	namespaces := make([]*namespace, 0, len(t.namespaces))
+	for _, ns := range t.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	slices.SortFunc(namespaces, func(a, b *namespace) int {
+		if a.settings.Name < b.settings.Name {
+			return -1
+		}
+		if a.settings.Name > b.settings.Name {
+			return 1
+		}
+		return 0
+	})
+	return namespaces
+}
+
+// CreateNamespace adds an empty namespace. Its settings are in the manifest
+// before it takes any write.
+func (t *LsmTree) CreateNamespace(settings Namespace) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if _, err := ParseCodec(settings.Compression); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidNamespace, err)
+	}
+
+	t.versionMu.Lock()
+	defer t.versionMu.Unlock()
+	t.mu.RLock()
+	_, exists := t.namespaces[settings.Name]
+	t.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, settings.Name)
+	}
+	// Every write to a namespace of the same name dropped before has a
+	// sequence number up to this one
+	ns, err := t.newNamespace(settings, t.sequence.Load())
+	if err != nil {
+		return err
+	}
+	edit := versionEdit{created: []namespaceInfo{{settings: settings, since: ns.since}}}
+	if err := t.manifest.log(edit); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.namespaces[settings.Name] = ns
+	t.mu.Unlock()
+	return nil
+}
+
+// DropNamespace deletes a namespace and its entries. Its tables are deleted
+// as they are, without reading them or any table of another namespace; its
+// values in the value log are left to the collector, and its WAL records are
+// skipped on replay.
+func (t *LsmTree) DropNamespace(name string) error {
+	if name == DefaultNamespace {
+		return fmt.Errorf("%w: the default namespace cannot be dropped", ErrInvalidNamespace)
+	}
+	ns, tables, err := t.unlinkNamespace(name)
+	if err != nil {
+		return err
+	}
+	// A compaction picked before the drop sees it once it gets the lock
+	ns.compactMu.Lock()
+	defer ns.compactMu.Unlock()
+	for _, table := range tables {
+		table.Close()
+		if err := os.Remove(table.Path()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	t.logger.Printf("Dropped namespace %s and its %d sstables", name, len(tables))
+	return nil
+}
+
+// unlinkNamespace logs the drop and takes the namespace out of the tree,
+// returning its tables.
+func (t *LsmTree) unlinkNamespace(name string) (*namespace, []*SSTableReader, error) {
+	t.versionMu.Lock()
+	defer t.versionMu.Unlock()
+	t.mu.RLock()
+	ns, ok := t.namespaces[name]
+	var tables []*SSTableReader
+	if ok {
+		for _, level := range ns.levels {
+			tables = append(tables, level...)
+		}
+	}
+	t.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	edit := versionEdit{dropped: []string{name}}
+	for _, table := range tables {
+		edit.removed = append(edit.removed, table.Name())
+	}
+	if err := t.manifest.log(edit); err != nil {
+		return nil, nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.namespaces, name)
+	ns.dropped = true
+	ns.levels = make([][]*SSTableReader, maxLevels)
+	t.active.drop(name)
+	for _, immutable := range t.immutables {
+		immutable.drop(name)
+	}
+	return ns, tables, nil
+}
+
+// Namespaces returns the settings of every namespace, the default one
+// included, ordered by name.
+func (t *LsmTree) Namespaces() []Namespace {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var settings []Namespace
+	for _, ns := range t.sortedNamespaces() {
+		settings = append(settings, ns.settings)
+	}
+	return settings
+}
+
+func (t *LsmTree) Namespace(name string) (Namespace, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if ns, ok := t.namespaces[name]; ok {
+		return ns.settings, true
+	}
+	return Namespace{}, false
+}
@@ -0,0 +1,196 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func entryIn(namespace, key, value string) DbEntry {
+	entry := NewDbEntry(key, value, false)
+	entry.SetNamespace(namespace)
+	return entry
+}
+
+func flushNow(t *testing.T, tree *LsmTree) {
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+}
+
+func namespaceTables(tree *LsmTree, name string) []string {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	var tables []string
+	for _, level := range tree.namespaces[name].levels {
+		for _, table := range level {
+			tables = append(tables, table.Path())
+		}
+	}
+	return tables
+}
+
+func TestWAL_KeepsNamespaceOfRecords(t *testing.T) {
+	wal := createTempWal(t)
+	assert.NoError(t, wal.Write(entryIn("usuarios", "k", "v"), NewDbEntry("k", "otro", false)))
+
+	entries := reopen(t, wal)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "usuarios", entries[0].Namespace())
+	assert.Equal(t, DefaultNamespace, entries[1].Namespace())
+	assert.Equal(t, "otro", entries[1].Value())
+}
+
+func TestNamespaces_KeepEntriesApart(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir})
+	assert.NoError(t, tree.CreateNamespace(Namespace{Name: "usuarios"}))
+	assert.ErrorIs(t, tree.CreateNamespace(Namespace{Name: "usuarios"}), ErrNamespaceExists)
+	assert.ErrorIs(t, tree.CreateNamespace(Namespace{Name: "con espacios"}), ErrInvalidNamespace)
+
+	assert.NoError(t, tree.Set(NewDbEntry("k", "por defecto", false)))
+	assert.NoError(t, tree.Set(entryIn("usuarios", "k", "usuario")))
+	assert.NoError(t, tree.Set(entryIn("usuarios", "solo", "usuario")))
+	assert.ErrorIs(t, tree.Set(entryIn("inexistente", "k", "v")), ErrNamespaceNotFound)
+
+	check := func() {
+		got, found := tree.Get("k")
+		assert.True(t, found)
+		assert.Equal(t, "por defecto", got.Value())
+		got, found = tree.GetIn("usuarios", "k")
+		assert.True(t, found)
+		assert.Equal(t, "usuario", got.Value())
+		assert.Equal(t, "usuarios", got.Namespace(), "la entrada sabe a qué espacio pertenece")
+		_, found = tree.Get("solo")
+		assert.False(t, found)
+		assert.Equal(t, []string{"k=usuario", "solo=usuario"}, collect(t, tree.ScanIn("usuarios", "", "", false)))
+		assert.Equal(t, []string{"k=por defecto"}, collect(t, tree.Scan("", "", false)))
+	}
+	check()
+	flushNow(t, tree)
+	assert.Len(t, namespaceTables(tree, "usuarios"), 1, "cada espacio vuelca a sus propias tablas")
+	assert.Len(t, namespaceTables(tree, DefaultNamespace), 1)
+	check()
+}
+
+func TestNamespaces_SurviveReopenWithTheirSettings(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{WalDirectory: dir, SSTCompression: "lz4"}
+	tree, err := OpenLsmTree(conf)
+	assert.NoError(t, err)
+	settings := Namespace{Name: "plano", DefaultTTL: time.Hour, Compression: "none", ConflictResolver: FirstWriterWins}
+	assert.NoError(t, tree.CreateNamespace(settings))
+	tree.Set(entryIn("plano", "en-tabla", "1"))
+	flushNow(t, tree)
+	tree.Set(entryIn("plano", "en-wal", "2"))
+	assert.NoError(t, tree.Close())
+
+	reopened := openTree(t, conf)
+	assert.Equal(t, []Namespace{{Name: DefaultNamespace}, settings}, reopened.Namespaces())
+	for key, value := range map[string]string{"en-tabla": "1", "en-wal": "2"} {
+		got, found := reopened.GetIn("plano", key)
+		assert.True(t, found)
+		assert.Equal(t, value, got.Value())
+	}
+	_, found := reopened.Get("en-tabla")
+	assert.False(t, found)
+
+	flushNow(t, reopened)
+	reopened.mu.RLock()
+	for _, tables := range reopened.namespaces["plano"].levels {
+		for _, table := range tables {
+			assert.Equal(t, CodecNone, table.Codec(), "el espacio usa su propia compresión")
+		}
+	}
+	reopened.mu.RUnlock()
+}
+
+func TestDropNamespace_DeletesOnlyItsTables(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{WalDirectory: dir}
+	tree, err := OpenLsmTree(conf)
+	assert.NoError(t, err)
+	assert.NoError(t, tree.CreateNamespace(Namespace{Name: "temporal"}))
+	tree.Set(entryIn("temporal", "k", "volcado"))
+	tree.Set(NewDbEntry("k", "se queda", false))
+	flushNow(t, tree)
+	dropped := namespaceTables(tree, "temporal")
+	kept := namespaceTables(tree, DefaultNamespace)
+	// Solo en el WAL al borrarse
+	tree.Set(entryIn("temporal", "wal", "en memoria"))
+
+	assert.NoError(t, tree.DropNamespace("temporal"))
+	assert.ErrorIs(t, tree.DropNamespace("temporal"), ErrNamespaceNotFound)
+	assert.ErrorIs(t, tree.DropNamespace(DefaultNamespace), ErrInvalidNamespace)
+	for _, table := range dropped {
+		_, err := os.Stat(table)
+		assert.True(t, os.IsNotExist(err), "las tablas del espacio borrado desaparecen")
+	}
+	for _, table := range kept {
+		_, err := os.Stat(table)
+		assert.NoError(t, err)
+	}
+	_, found := tree.GetIn("temporal", "wal")
+	assert.False(t, found)
+
+	// Uno nuevo con el mismo nombre empieza vacío, también tras reiniciar
+	assert.NoError(t, tree.CreateNamespace(Namespace{Name: "temporal"}))
+	tree.Set(entryIn("temporal", "nueva", "1"))
+	assert.NoError(t, tree.Close())
+
+	reopened := openTree(t, conf)
+	_, found = reopened.GetIn("temporal", "wal")
+	assert.False(t, found, "el WAL del espacio borrado no se vuelve a aplicar")
+	_, found = reopened.GetIn("temporal", "k")
+	assert.False(t, found)
+	got, found := reopened.GetIn("temporal", "nueva")
+	assert.True(t, found)
+	assert.Equal(t, "1", got.Value())
+	got, _ = reopened.Get("k")
+	assert.Equal(t, "se queda", got.Value())
+}
+
+func TestDropNamespace_LeavesItsValuesToTheCollector(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir, ValueLogThreshold: 100, ValueLogFileSize: 1})
+	assert.NoError(t, tree.CreateNamespace(Namespace{Name: "grandes"}))
+	tree.Set(entryIn("grandes", "k", bigValue(1)))
+	tree.Set(NewDbEntry("k", bigValue(2), false))
+	flushNow(t, tree)
+	assert.Len(t, valueLogFiles(t, dir), 2)
+
+	assert.NoError(t, tree.DropNamespace("grandes"))
+	collected, err := tree.CollectValueLog()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, collected, "solo el archivo del espacio borrado es basura")
+	got, _ := tree.Get("k")
+	assert.Equal(t, bigValue(2), got.Value())
+}
+
+func TestCheckpoint_RestoresNamespaces(t *testing.T) {
+	tree := openTree(t, config.Config{WalDirectory: t.TempDir()})
+	settings := Namespace{Name: "copiado", DefaultTTL: time.Minute}
+	assert.NoError(t, tree.CreateNamespace(settings))
+	tree.Set(entryIn("copiado", "k", "v"))
+
+	target := path.Join(t.TempDir(), "checkpoint")
+	_, err := tree.Checkpoint(target)
+	assert.NoError(t, err)
+	restoredDir := t.TempDir()
+	_, err = RestoreCheckpoint(target, restoredDir)
+	assert.NoError(t, err)
+
+	restored := openTree(t, config.Config{WalDirectory: restoredDir})
+	got, found := restored.Namespace("copiado")
+	assert.True(t, found)
+	assert.Equal(t, settings, got)
+	entry, found := restored.GetIn("copiado", "k")
+	assert.True(t, found)
+	assert.Equal(t, "v", entry.Value())
+}
@@ -0,0 +1,266 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// OpenLsmTree opens the SSTables found in the WAL directory and rebuilds the
+// active memtable from the WAL segments that were not flushed yet, replaying
+// them oldest first. A new segment is opened for the writes that follow.
+func OpenLsmTree(conf config.Config) (*LsmTree, error) {
+	dir := conf.WalDirectory
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tree, err := newLsmTree(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	state, manifestNumber, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	found := manifestNumber > 0
+	tables, err := tree.liveTables(dir, state, found)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// Written before the manifest existed, every table is live, knows
+		// its own level and belongs to the default namespace
+		state = newVersionState()
+	}
+	for name, info := range state.namespaces {
+		if tree.namespaces[name], err = tree.newNamespace(info.settings, info.since); err != nil {
+			return nil, err
+		}
+	}
+	tree.observeVersion(formatVersion(state.nextFile))
+	// Without a manifest, flushes wrote level 0 tables named after their
+	// WAL segment, so the newest of them tells which segments are already
+	// stored in SSTables.
+	lastFlushed := state.flushedLog
+	for _, tablePath := range tables {
+		table, err := tree.openTable(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(tablePath)
+		level, listed := state.tables[name]
+		if !listed {
+			level = table.Level()
+			state.tables[name] = level
+		}
+		level = min(level, maxLevels-1)
+		namespace := DefaultNamespace
+		if owner, ok := state.tableNamespaces[name]; ok {
+			namespace = owner
+		}
+		ns, ok := tree.namespaces[namespace]
+		if !ok {
+			table.Close()
+			return nil, fmt.Errorf("%w: sstable %s belongs to unknown namespace %s", ErrCorruptedManifest, name, namespace)
+		}
+		ns.levels[level] = append([]*SSTableReader{table}, ns.levels[level]...)
+		version, _ := sstVersionFromName(name)
+		if !found && level == 0 && (lastFlushed == "" || compareWalVersions(version, lastFlushed) > 0) {
+			lastFlushed = version
+		}
+		tree.observeVersion(version)
+		if table.MaxSeq() > tree.sequence.Load() {
+			tree.sequence.Store(table.MaxSeq())
+		}
+	}
+	for _, ns := range tree.namespaces {
+		for level := 1; level < maxLevels; level++ {
+			if !ns.policy.Overlapping(level) {
+				sortByFirstKey(ns.levels[level])
+			}
+		}
+	}
+
+	segments, err := ListWalSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		version, _ := walVersionFromName(path.Base(segment))
+		tree.observeVersion(version)
+	}
+	// Opened before replaying, which writes the large values again
+	tree.values, err = openValueLog(dir, tree.valueLogOptions, tree.nextFileNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range tree.values.sealed() {
+		tree.observeVersion(formatVersion(number))
+	}
+	state.flushedLog = lastFlushed
+	state.nextFile = tree.fileNumber.Load()
+	if tree.manifest, err = openManifest(dir, state, manifestNumber); err != nil {
+		return nil, err
+	}
+
+	mem := tree.newMemtable(nil)
+	replayed, skipped := 0, 0
+	for i, segment := range segments {
+		version, _ := walVersionFromName(path.Base(segment))
+		if lastFlushed != "" && compareWalVersions(version, lastFlushed) <= 0 {
+			// Flushed before the crash but not retired yet
+			if err := retireSegment(segment, tree.walOptions.ArchiveDir); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		n, m, err := replaySegment(segment, mem, tree.replays, i == len(segments)-1)
+		if err != nil {
+			return nil, fmt.Errorf("replaying wal segment %s: %w", segment, err)
+		}
+		replayed += n
+		skipped += m
+		mem.segments = append(mem.segments, segment)
+	}
+	if len(mem.segments) > 0 || len(tables) > 0 {
+		tree.logger.Printf("Recovered %d entries from %d wal segments and %d sstables", replayed, len(mem.segments), len(tables))
+	}
+	if skipped > 0 {
+		tree.logger.Printf("Skipped %d wal entries of dropped namespaces", skipped)
+	}
+
+	w, err := tree.newWal()
+	if err != nil {
+		return nil, err
+	}
+	mem.wal = w
+	tree.active = mem
+
+	tree.startFlusher()
+	tree.startCompactor()
+	tree.startValueLogGC()
+	tree.scheduleCompaction()
+	if tree.isFull(mem) {
+		tree.mu.Lock()
+		frozen := tree.freeze(mem)
+		tree.mu.Unlock()
+		if frozen != nil {
+			tree.wakeFlusher()
+		}
+	}
+	return tree, nil
+}
+
+// liveTables returns the tables of the version the manifest holds, oldest
+// first, and deletes the table files it does not list: outputs of a flush or
+// compaction cut short, or inputs of one that was not cleaned up after.
+// Without a manifest every table found is live.
+func (t *LsmTree) liveTables(dir string, state versionState, found bool) ([]string, error) {
+	// Left behind by a writer that did not get to rename them
+	temporary, err := filepath.Glob(path.Join(dir, sstPrefix+"*"+sstExtension+".tmp"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range temporary {
+		if err := os.Remove(file); err != nil {
+			return nil, err
+		}
+	}
+	tables, err := ListSSTables(dir)
+	if err != nil || !found {
+		return tables, err
+	}
+
+	present := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		present[path.Base(table)] = true
+	}
+	// Checked before anything is deleted, a manifest that does not match
+	// the directory is left for fsck to look at
+	for name := range state.tables {
+		if !present[name] {
+			return nil, fmt.Errorf("%w: live sstable %s is missing", ErrCorruptedManifest, name)
+		}
+	}
+	var live []string
+	for _, table := range tables {
+		name := path.Base(table)
+		if _, ok := state.tables[name]; ok {
+			live = append(live, table)
+			continue
+		}
+		t.logger.Printf("Removing sstable %s, not in the manifest", name)
+		if err := os.Remove(table); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+// observeVersion makes sure file numbers handed out from now on follow the
+// number of a file found on disk.
+func (t *LsmTree) observeVersion(version string) {
+	if n, ok := versionNumber(version); ok && n > t.fileNumber.Load() {
+		t.fileNumber.Store(n)
+	}
+}
+
+// replays tells whether a WAL entry belongs to a namespace of the tree, and
+// not to a namespace dropped before, whatever its name.
+func (t *LsmTree) replays(entry DbEntry) bool {
+	ns, ok := t.namespaces[entry.Namespace()]
+	return ok && (entry.Seq() > ns.since || ns.since == 0)
+}
+
+// replaySegment applies the entries of a segment that keep says belong to
+// the tree, and returns how many were applied and how many skipped. Only the
+// newest segment may end in a record torn by a crash, which is cut off so
+// the segment reads clean once newer ones follow it. Any other bad record
+// fails the replay, since the entries after it, acknowledged to clients,
+// would be lost while newer segments are applied on top.
+func replaySegment(segment string, mem *Memtable, keep func(DbEntry) bool, newest bool) (int, int, error) {
+	text, err := IsTextWal(segment)
+	if err != nil {
+		return 0, 0, err
+	}
+	if text {
+		n, err := ConvertTextWal(segment)
+		if err != nil {
+			return 0, 0, fmt.Errorf("converting text wal: %w", err)
+		}
+		mem.logger.Printf("Converted text wal segment %s to the binary format (%d entries)", segment, n)
+	}
+
+	w, err := FromFile(segment)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer w.Close()
+
+	entries, err := w.Read()
+	var corruption *WalCorruptionError
+	if errors.As(err, &corruption) {
+		if !newest || !corruption.Tail {
+			return 0, 0, fmt.Errorf("%w, run kvdb-fsck to inspect it", corruption)
+		}
+		mem.logger.Printf("wal segment %s: %v, replaying %d entries before it", segment, err, len(entries))
+		if err := os.Truncate(segment, corruption.Offset); err != nil {
+			return 0, 0, err
+		}
+	} else if err != nil {
+		return 0, 0, err
+	}
+	applied := 0
+	for _, entry := range entries {
+		if keep(entry) {
+			mem.apply(entry)
+			applied++
+		}
+	}
+	return applied, len(entries) - applied, nil
+}
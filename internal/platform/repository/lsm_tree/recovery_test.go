@@ -0,0 +1,285 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"KVDB/internal/platform/utils"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// simula una caída: el descriptor se cierra sin pasar por WAL.Close
+func crash(wal *WAL) {
+	wal.fd.Close()
+}
+
+func openTree(t *testing.T, conf config.Config) *LsmTree {
+	tree, err := OpenLsmTree(conf)
+	if err != nil {
+		t.Fatalf("error abriendo lsm tree: %v", err)
+	}
+	t.Cleanup(func() {
+		tree.Close()
+	})
+	return tree
+}
+
+func recoverTree(t *testing.T, dir string) *LsmTree {
+	return openTree(t, config.Config{WalDirectory: dir})
+}
+
+func TestOpenLsmTree_ReplaysWalAfterCrash(t *testing.T) {
+	wal := createTempWal(t)
+	mem := NewMemtable(wal)
+
+	mem.Set(NewDbEntry("k1", "v1", false))
+	mem.Set(NewDbEntry("k2", "v2", false))
+	mem.Set(NewDbEntry("k1", "v1-bis", false))
+	mem.Set(NewDbEntry("k2", "", true))
+	crash(wal)
+
+	recovered := recoverTree(t, wal.dir)
+
+	got, found := recovered.Get("k1")
+	assert.True(t, found)
+	assert.Equal(t, "v1-bis", got.Value())
+
+	got, found = recovered.lookup(DefaultNamespace, "k2", math.MaxUint64)
+	assert.True(t, found, "la tombstone debe sobrevivir al reinicio")
+	assert.True(t, got.Tombstone())
+}
+
+func TestOpenLsmTree_IgnoresTornFinalRecord(t *testing.T) {
+	wal := createTempWal(t)
+	if err := wal.Write(NewDbEntry("alpha", "1", false)); err != nil {
+		t.Fatalf("fallo al escribir en WAL: %v", err)
+	}
+	// registro final escrito a medias
+	record := encodeWalRecord(NewDbEntry("beta", "value", false))
+	if _, err := wal.fd.Write(record[:len(record)-3]); err != nil {
+		t.Fatalf("fallo al escribir registro truncado: %v", err)
+	}
+	crash(wal)
+
+	recovered := recoverTree(t, wal.dir)
+
+	got, found := recovered.Get("alpha")
+	assert.True(t, found)
+	assert.Equal(t, "1", got.Value())
+	_, found = recovered.Get("beta")
+	assert.False(t, found, "el registro truncado no debe aplicarse")
+}
+
+func TestOpenLsmTree_CutsTornTailOffNewestSegment(t *testing.T) {
+	wal := createTempWal(t)
+	if err := wal.Write(NewDbEntry("alpha", "1", false)); err != nil {
+		t.Fatalf("fallo al escribir en WAL: %v", err)
+	}
+	record := encodeWalRecord(NewDbEntry("beta", "value", false))
+	if _, err := wal.fd.Write(record[:len(record)-3]); err != nil {
+		t.Fatalf("fallo al escribir registro truncado: %v", err)
+	}
+	crash(wal)
+
+	// el segmento roto deja de ser el más nuevo al reabrir
+	second := recoverTree(t, wal.dir)
+	second.Set(NewDbEntry("gamma", "3", false))
+	crash(second.active.wal)
+
+	recovered, err := OpenLsmTree(config.Config{WalDirectory: wal.dir})
+	assert.NoError(t, err, "la cola rota se corta en la primera recuperación")
+	if err != nil {
+		return
+	}
+	t.Cleanup(func() { recovered.Close() })
+	for _, key := range []string{"alpha", "gamma"} {
+		_, found := recovered.Get(key)
+		assert.True(t, found, key)
+	}
+}
+
+// corruptFirstRecord cambia un byte del primer registro del segmento, que
+// entonces no pasa su checksum aunque lo siga otro
+func corruptFirstRecord(t *testing.T, segment string) {
+	data, err := os.ReadFile(segment)
+	assert.NoError(t, err)
+	data[walHeaderSize+walRecordHeaderSize] ^= 0xff
+	assert.NoError(t, os.WriteFile(segment, data, 0644))
+}
+
+func TestOpenLsmTree_FailsOnCorruptionBeforeNewerSegments(t *testing.T) {
+	first := createTempWal(t)
+	first.Write(NewDbEntry("alpha", "1", false))
+	first.Write(NewDbEntry("beta", "2", false))
+	crash(first)
+	second := recoverTree(t, first.dir)
+	second.Set(NewDbEntry("gamma", "3", false))
+	crash(second.active.wal)
+
+	corruptFirstRecord(t, first.path)
+
+	_, err := OpenLsmTree(config.Config{WalDirectory: first.dir})
+	assert.ErrorIs(t, err, ErrCorruptedWal, "no se aplican segmentos nuevos sobre un hueco en la historia")
+	if err != nil {
+		assert.Contains(t, err.Error(), path.Base(first.path), "nombra el segmento")
+		assert.Contains(t, err.Error(), fmt.Sprintf("offset %d", walHeaderSize), "y dónde está el registro malo")
+	}
+}
+
+func TestOpenLsmTree_FailsOnCorruptionInsideNewestSegment(t *testing.T) {
+	wal := createTempWal(t)
+	wal.Write(NewDbEntry("alpha", "1", false))
+	wal.Write(NewDbEntry("beta", "2", false))
+	crash(wal)
+
+	corruptFirstRecord(t, wal.path)
+
+	_, err := OpenLsmTree(config.Config{WalDirectory: wal.dir})
+	assert.ErrorIs(t, err, ErrCorruptedWal, "solo se tolera una cola rota, no un registro malo seguido de otros")
+}
+
+func TestOpenLsmTree_ReplaysMemtablesWhoseFlushFailed(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.NoError(t, err)
+
+	tree.Set(NewDbEntry("k1", "v1", false))
+	blocked := blockFlush(t, tree)
+	freezeActive(tree)
+	tree.Set(NewDbEntry("k2", "v2", false))
+	freezeActive(tree)
+	tree.Set(NewDbEntry("k3", "v3", false))
+	assert.NoError(t, tree.Close())
+
+	state, _, err := readManifest(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, state.flushedLog, "ningún segmento sin volcar se da por volcado")
+
+	// Reabierto sin el fallo, el volcado sale bien y los datos siguen ahí
+	assert.NoError(t, os.RemoveAll(blocked))
+	recovered, err := OpenLsmTree(config.Config{WalDirectory: dir})
+	assert.NoError(t, err)
+	freezeActive(recovered)
+	waitForFlush(t, recovered)
+	recovered.Set(NewDbEntry("k4", "v4", false))
+	assert.NoError(t, recovered.Close())
+
+	reopened := recoverTree(t, dir)
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		_, found := reopened.Get(key)
+		assert.True(t, found, key)
+	}
+}
+
+func TestOpenLsmTree_ReplaysSegmentsInOrder(t *testing.T) {
+	first := createTempWal(t)
+	first.Write(NewDbEntry("k", "old", false))
+	crash(first)
+
+	// segundo arranque: nuevo segmento en el mismo directorio
+	second := recoverTree(t, first.dir)
+	second.Set(NewDbEntry("k", "new", false))
+	crash(second.active.wal)
+
+	recovered := recoverTree(t, first.dir)
+	got, found := recovered.Get("k")
+	assert.True(t, found)
+	assert.Equal(t, "new", got.Value())
+
+	segments, err := ListWalSegments(first.dir)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 3)
+	assert.Equal(t, first.path, segments[0])
+}
+
+func TestOpenLsmTree_CreatesMissingDirectory(t *testing.T) {
+	dir := t.TempDir() + "/wal"
+
+	mem := recoverTree(t, dir)
+	mem.Set(NewDbEntry("k", "v", false))
+
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("el directorio WAL no fue creado: %v", err)
+	}
+}
+
+func TestOpenLsmTree_ContinuesFileNumbering(t *testing.T) {
+	dir := t.TempDir()
+	first := recoverTree(t, dir)
+	first.Set(NewDbEntry("k", "v", false))
+	crash(first.active.wal)
+
+	second := recoverTree(t, dir)
+	assert.Equal(t, formatVersion(2), second.active.wal.Version())
+}
+
+func TestCompareWalVersions_NumberedAfterLegacy(t *testing.T) {
+	assert.Less(t, compareWalVersions("0000000009", "0000000010"), 0)
+	assert.Less(t, compareWalVersions("20990101000000-000000001", "0000000001"), 0)
+}
+
+func TestCompareWalVersions_LegacyNanos(t *testing.T) {
+	// segmentos antiguos sin relleno en los nanosegundos
+	assert.Less(t, compareWalVersions("20250101000000-99", "20250101000000-100"), 0)
+	assert.Less(t, compareWalVersions("20250101000000-999999999", "20250101000001-000000001"), 0)
+}
+
+func TestOpenLsmTree_ConvertsTextWal(t *testing.T) {
+	dir := t.TempDir()
+	segment := path.Join(dir, walPrefix+formatVersion(1)+walExtension)
+	var text bytes.Buffer
+	utils.AppendDbEntry(&text, NewDbEntry("k1", "v1", false))
+	utils.AppendDbEntry(&text, NewDbEntry("k2", "v2", false))
+	utils.AppendDbEntry(&text, NewDbEntry("k1", "", true))
+	assert.NoError(t, os.WriteFile(segment, text.Bytes(), 0755))
+
+	recovered := recoverTree(t, dir)
+
+	_, found := recovered.Get("k1")
+	assert.False(t, found)
+	got, found := recovered.Get("k2")
+	assert.True(t, found)
+	assert.Equal(t, "v2", got.Value())
+
+	isText, err := IsTextWal(segment)
+	assert.NoError(t, err)
+	assert.False(t, isText, "el segmento debe quedar en formato binario")
+}
+
+func TestOpenLsmTree_RoundTripsBinaryData(t *testing.T) {
+	dir := t.TempDir()
+	binary := []DbEntry{
+		NewDbEntryFromBytes([]byte{0, 1, ',', '\n', 0xff}, []byte("valor,con\ncomas\x00y\nsaltos"), false),
+		NewDbEntryFromBytes([]byte("imagen"), append([]byte{0x89, 'P', 'N', 'G', '\r', '\n'}, bytes.Repeat([]byte{0xfe, '\n'}, 100)...), false),
+	}
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, ValueLogThreshold: 100})
+	assert.NoError(t, err)
+	for _, entry := range binary {
+		tree.Set(entry)
+	}
+	crash(tree.active.wal)
+
+	// Primero desde el WAL, después desde una SSTable y el value log
+	replayed := recoverTree(t, dir)
+	for _, entry := range binary {
+		got, found := replayed.Get(entry.Key())
+		assert.True(t, found)
+		assert.Equal(t, entry.ValueBytes(), got.ValueBytes(), "el WAL debe conservar los bytes")
+	}
+	waitForFlush(t, replayed)
+	replayed.mu.Lock()
+	frozen := replayed.freeze(replayed.active)
+	replayed.mu.Unlock()
+	assert.NoError(t, replayed.flush(frozen))
+	for _, entry := range binary {
+		got, found := replayed.Get(entry.Key())
+		assert.True(t, found)
+		assert.Equal(t, entry.ValueBytes(), got.ValueBytes(), "la SSTable debe conservar los bytes")
+	}
+}
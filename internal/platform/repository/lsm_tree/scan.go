@@ -0,0 +1,174 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"math"
+	"slices"
+)
+
+// Iterator returns the live entries of a key range, merging the memtables
+// and every SSTable that overlaps it. Only the newest version of each key
+// visible at the iterator's sequence number is returned and deleted keys are
+// skipped.
+type Iterator struct {
+	tree      *LsmTree
+	namespace string
+	merged    *mergeIterator
+	tables    []*SSTableReader
+	seq       uint64
+	err       error
+	// registered is set while the sequence number is held in the snapshot
+	// registry, which keeps the value log collector off the values the
+	// iterator may still read
+	registered bool
+
+	// buffered holds the entry read past the versions of the previous key
+	buffered    DbEntry
+	hasBuffered bool
+}
+
+// Scan iterates over the keys of the default namespace with start <= key <
+// end, in key order or in reverse. Empty bounds leave that side of the range
+// open. The iterator sees the tree as it was when created, later writes are
+// not seen.
+func (t *LsmTree) Scan(start, end string, reverse bool) *Iterator {
+	return t.ScanIn(DefaultNamespace, start, end, reverse)
+}
+
+// ScanIn iterates over a key range of namespace. A namespace that does not
+// exist holds no keys.
+func (t *LsmTree) ScanIn(namespace, start, end string, reverse bool) *Iterator {
+	return t.scanAt(namespace, start, end, reverse, math.MaxUint64)
+}
+
+// scanAt scans the versions up to seq. Writes still being applied are left
+// out by capping it to the last sequence number handed out.
+func (t *LsmTree) scanAt(namespace, start, end string, reverse bool, seq uint64) *Iterator {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	seq = min(seq, t.sequence.Load())
+
+	var sources []entryIterator
+	memtable := func(mem *Memtable) {
+		entries := mem.Range(namespace, start, end)
+		if reverse {
+			slices.Reverse(entries)
+		}
+		sources = append(sources, &sliceIterator{entries: entries})
+	}
+	memtable(t.active)
+	for _, immutable := range t.immutables {
+		memtable(immutable)
+	}
+
+	it := &Iterator{tree: t, namespace: namespace, seq: t.snapshots.register(seq), registered: true}
+	var levels [][]*SSTableReader
+	if ns, ok := t.namespaces[namespace]; ok {
+		levels = ns.levels
+	}
+	for _, tables := range levels {
+		for _, table := range tables {
+			if table.LastKey() < start || (end != "" && table.FirstKey() >= end) {
+				continue
+			}
+			// Pinned so a compaction cannot close it while the scan runs
+			table.acquire()
+			it.tables = append(it.tables, table)
+			sources = append(sources, table.rangeIterator(start, end, reverse))
+		}
+	}
+	it.merged = mergeIterators(sources, reverse)
+	return it
+}
+
+// Prefix iterates over the keys of the default namespace starting with
+// prefix.
+func (t *LsmTree) Prefix(prefix string, reverse bool) *Iterator {
+	return t.PrefixIn(DefaultNamespace, prefix, reverse)
+}
+
+func (t *LsmTree) PrefixIn(namespace, prefix string, reverse bool) *Iterator {
+	start, end := PrefixRange(prefix)
+	return t.ScanIn(namespace, start, end, reverse)
+}
+
+func (it *Iterator) Next() (DbEntry, bool) {
+	for it.err == nil {
+		entry, ok := it.read()
+		if !ok {
+			return DbEntry{}, false
+		}
+		// Reverse scans get the versions of a key oldest first from each
+		// source, so every version is looked at before picking one.
+		key := entry.Key()
+		visible, found := DbEntry{}, false
+		for ok && entry.Key() == key {
+			if entry.Seq() <= it.seq && (!found || entry.Seq() > visible.Seq()) {
+				visible, found = entry, true
+			}
+			entry, ok = it.read()
+		}
+		if ok {
+			it.buffered, it.hasBuffered = entry, true
+		}
+		if found && !visible.Tombstone() {
+			visible.SetNamespace(it.namespace)
+			resolved, err := it.tree.resolve(visible)
+			if err != nil {
+				it.err = err
+				return DbEntry{}, false
+			}
+			return resolved, true
+		}
+	}
+	return DbEntry{}, false
+}
+
+func (it *Iterator) read() (DbEntry, bool) {
+	if it.hasBuffered {
+		it.hasBuffered = false
+		return it.buffered, true
+	}
+	return it.merged.Next()
+}
+
+func (it *Iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.merged.Err()
+}
+
+// Close releases the tables pinned by the iterator. It is safe to call more
+// than once.
+func (it *Iterator) Close() error {
+	if it.registered {
+		it.tree.snapshots.unregister(it.seq)
+		it.registered = false
+	}
+	var err error
+	for _, table := range it.tables {
+		if releaseErr := table.release(); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	it.tables = nil
+	return err
+}
+
+type sliceIterator struct {
+	entries []DbEntry
+	pos     int
+}
+
+func (it *sliceIterator) Next() (DbEntry, bool) {
+	if it.pos >= len(it.entries) {
+		return DbEntry{}, false
+	}
+	it.pos++
+	return it.entries[it.pos-1], true
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, it *Iterator) []string {
+	defer it.Close()
+	var keys []string
+	for {
+		entry, ok := it.Next()
+		if !ok {
+			break
+		}
+		keys = append(keys, entry.Key()+"="+entry.Value())
+	}
+	assert.NoError(t, it.Err())
+	return keys
+}
+
+func TestLsmTree_ScanMergesMemtableAndTables(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir})
+	setLevel(tree, 1, writeTable(t, dir, "old.sst",
+		NewDbEntry("a", "old", false),
+		NewDbEntry("b", "old", false),
+		NewDbEntry("c", "old", false),
+		NewDbEntry("e", "old", false),
+	))
+	setLevel(tree, 0, writeTable(t, dir, "new.sst",
+		NewDbEntry("b", "new", false),
+		NewDbEntry("c", "", true),
+	))
+	tree.Set(NewDbEntry("d", "mem", false))
+	tree.Set(NewDbEntry("e", "", true))
+	tree.Set(NewDbEntry("a", "mem", false))
+
+	assert.Equal(t, []string{"a=mem", "b=new", "d=mem"}, collect(t, tree.Scan("", "", false)))
+	assert.Equal(t, []string{"d=mem", "b=new", "a=mem"}, collect(t, tree.Scan("", "", true)))
+	assert.Equal(t, []string{"b=new"}, collect(t, tree.Scan("b", "d", false)))
+	assert.Equal(t, []string{"b=new"}, collect(t, tree.Scan("b", "d", true)))
+}
+
+func TestLsmTree_PrefixScan(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir})
+	setLevel(tree, 0, writeTable(t, dir, "a.sst",
+		NewDbEntry("user:1", "ana", false),
+		NewDbEntry("user:2", "bob", false),
+		NewDbEntry("users", "x", false),
+	))
+	tree.Set(NewDbEntry("user:3", "eva", false))
+	tree.Set(NewDbEntry("usen", "y", false))
+
+	assert.Equal(t, []string{"user:1=ana", "user:2=bob", "user:3=eva"}, collect(t, tree.Prefix("user:", false)))
+	assert.Equal(t, []string{"user:3=eva", "user:2=bob", "user:1=ana"}, collect(t, tree.Prefix("user:", true)))
+}
+
+func TestTableIterator_SpansBlocks(t *testing.T) {
+	dir := t.TempDir()
+	table := writeTable(t, dir, "a.sst", sortedEntries(2000)...)
+
+	count := func(it *tableIterator) (n int, first, last string) {
+		for {
+			entry, ok := it.Next()
+			if !ok {
+				return
+			}
+			if n == 0 {
+				first = entry.Key()
+			}
+			last = entry.Key()
+			n++
+		}
+	}
+	n, first, last := count(table.rangeIterator("key-00500", "key-01500", false))
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, "key-00500", first)
+	assert.Equal(t, "key-01499", last)
+
+	n, first, last = count(table.rangeIterator("key-00500", "key-01500", true))
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, "key-01499", first)
+	assert.Equal(t, "key-00500", last)
+}
+
+func TestIterator_PinsTablesUntilClosed(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir})
+	table := writeTable(t, dir, "a.sst", sortedEntries(2000)...)
+	table.acquire() // la referencia del árbol; writeTable ya registra su Close
+	setLevel(tree, 0, table)
+
+	it := tree.Scan("", "", false)
+	_, ok := it.Next()
+	assert.True(t, ok)
+
+	// una compactación suelta su referencia mientras el scan sigue abierto
+	setLevel(tree, 0)
+	table.release()
+
+	n := 1
+	for {
+		if _, ok := it.Next(); !ok {
+			break
+		}
+		n++
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, 2000-200, n, "las tombstones no se devuelven")
+	assert.NoError(t, it.Close())
+}
@@ -2,37 +2,58 @@ package lsm_tree
 
 import (
 	"KVDB/internal/domain"
-	"math/rand"
-	"time"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
 	"unsafe"
 )
 
+// SkipList is safe for concurrent use without locks. Elements are linked
+// with compare-and-swap and never unlinked, since deletes are stored as
+// tombstones, so readers can walk the list while writers insert.
+//
+// Every sequence number of a key is a separate element, ordered by key and
+// then newest first. Writing a key again with the same sequence number swaps
+// the entry of its element atomically.
 type SkipList struct {
 	maxLevel int
 	p        float64
-	level    int
-	rand     *rand.Rand
-	size     int
+	size     atomic.Int64
 	head     *Element
 }
 
 type Element struct {
-	domain.DbEntry
-	next []*Element
+	key   string
+	seq   uint64
+	entry atomic.Pointer[domain.DbEntry]
+	next  []atomic.Pointer[Element]
 }
 
+// maxSkipListLevel bounds maxLevel so searches can keep their splice on the
+// stack.
+const maxSkipListLevel = 64
+
 func NewSkipList(maxLevel int, p float64) *SkipList {
+	maxLevel = min(maxLevel, maxSkipListLevel)
 	return &SkipList{
 		maxLevel: maxLevel,
 		p:        p,
-		level:    1,
-		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
-		size:     0,
-		head: &Element{
-			DbEntry: domain.NewDbEntry("HEAD", "", false),
-			next:    make([]*Element, maxLevel),
-		},
+		head:     newElement(domain.NewDbEntry("HEAD", "", false), maxLevel),
+	}
+}
+
+func newElement(entry domain.DbEntry, level int) *Element {
+	e := &Element{
+		key:  entry.Key(),
+		seq:  entry.Seq(),
+		next: make([]atomic.Pointer[Element], level),
 	}
+	e.entry.Store(&entry)
+	return e
+}
+
+func (e *Element) Entry() domain.DbEntry {
+	return *e.entry.Load()
 }
 
 func (s *SkipList) Reset() *SkipList {
@@ -40,85 +61,112 @@ func (s *SkipList) Reset() *SkipList {
 }
 
 func (s *SkipList) Size() int {
-	return s.size
+	return int(s.size.Load())
 }
 
-func (e *Element) Set(entry domain.DbEntry) *Element {
-	return &Element{
-		entry,
-		e.next,
-	}
+// before tells whether e sorts before the version seq of key.
+func (e *Element) before(key string, seq uint64) bool {
+	return e.key < key || (e.key == key && e.seq > seq)
 }
 
-func (s *SkipList) Set(entry domain.DbEntry) {
+// findSplice fills preds and succs with the elements around the version seq
+// of key at every level and returns the element holding it, if any.
+func (s *SkipList) findSplice(key string, seq uint64, preds, succs []*Element) *Element {
 	curr := s.head
-	update := make([]*Element, s.maxLevel)
-
 	for i := s.maxLevel - 1; i >= 0; i-- {
-		for curr.next[i] != nil && curr.next[i].Key() < entry.Key() {
-			curr = curr.next[i]
+		next := curr.next[i].Load()
+		for next != nil && next.before(key, seq) {
+			curr = next
+			next = curr.next[i].Load()
 		}
-		update[i] = curr
+		preds[i], succs[i] = curr, next
 	}
-	if curr.next[0] != nil && curr.next[0].Key() == entry.Key() {
-		s.size += len(entry.Value()) - len(curr.next[0].Value())
-
-		// update value and tombstone
-		curr.next[0] = curr.next[0].Set(entry)
-		return
+	if succs[0] != nil && succs[0].key == key && succs[0].seq == seq {
+		return succs[0]
 	}
-	// add entry
-	level := s.randomLevel()
+	return nil
+}
 
-	if level > s.level {
-		for i := s.level; i < level; i++ {
-			update[i] = s.head
+func (s *SkipList) Set(entry domain.DbEntry) {
+	var predsBuf, succsBuf [maxSkipListLevel]*Element
+	preds, succs := predsBuf[:s.maxLevel], succsBuf[:s.maxLevel]
+
+	for {
+		if found := s.findSplice(entry.Key(), entry.Seq(), preds, succs); found != nil {
+			// update value and tombstone
+			old := found.entry.Swap(&entry)
+			s.size.Add(int64(len(entry.Value()) - len(old.Value())))
+			return
 		}
-		s.level = level
-	}
 
-	e := &Element{
-		entry,
-		make([]*Element, level),
+		level := s.randomLevel()
+		e := newElement(entry, level)
+		for i := range level {
+			e.next[i].Store(succs[i])
+		}
+		// Linking level 0 publishes the element. If another writer changed
+		// the predecessor meanwhile, search again: it may even have inserted
+		// the same key.
+		if !preds[0].next[0].CompareAndSwap(succs[0], e) {
+			continue
+		}
+		// Upper levels only speed searches up, so they are linked afterwards
+		for i := 1; i < level; i++ {
+			for !preds[i].next[i].CompareAndSwap(succs[i], e) {
+				s.findSplice(entry.Key(), entry.Seq(), preds, succs)
+				e.next[i].Store(succs[i])
+			}
+		}
+		s.size.Add(int64(len(entry.Key()) + len(entry.Value()) + int(unsafe.Sizeof(entry.Tombstone())) + level*int(unsafe.Sizeof((*Element)(nil)))))
+		return
 	}
+}
 
-	for i := range level {
-		e.next[i] = update[i].next[i]
-		update[i].next[i] = e
+// Get returns the newest version of key.
+func (s *SkipList) Get(key string) (domain.DbEntry, bool) {
+	return s.GetAt(key, math.MaxUint64)
+}
+
+// GetAt returns the newest version of key with a sequence number up to seq.
+func (s *SkipList) GetAt(key string, seq uint64) (domain.DbEntry, bool) {
+	curr := s.seek(key, seq)
+	if curr != nil && curr.key == key {
+		return curr.Entry(), true
 	}
-	s.size += len(entry.Key()) + len(entry.Value()) + int(unsafe.Sizeof(entry.Tombstone())) + len(e.next)*int(unsafe.Sizeof((*Element)(nil)))
+	return domain.DbEntry{}, false
 }
 
-func (s *SkipList) Get(key string) (domain.DbEntry, bool) {
+// seek returns the first element at or after the version seq of key.
+func (s *SkipList) seek(key string, seq uint64) *Element {
 	curr := s.head
-
 	for i := s.maxLevel - 1; i >= 0; i-- {
-		for curr.next[i] != nil && curr.next[i].Key() < key {
-			curr = curr.next[i]
+		next := curr.next[i].Load()
+		for next != nil && next.before(key, seq) {
+			curr = next
+			next = curr.next[i].Load()
 		}
 	}
-
-	curr = curr.next[0]
-
-	if curr != nil && curr.Key() == key {
-		return domain.NewDbEntry(curr.Key(), curr.Value(), curr.Tombstone()), true
-	}
-	return domain.DbEntry{}, false
+	return curr.next[0].Load()
 }
 
+// All returns every version of every key.
 func (s *SkipList) All() []domain.DbEntry {
-	var all []domain.DbEntry
+	return s.Range("", "")
+}
 
-	for curr := s.head.next[0]; curr != nil; curr = curr.next[0] {
-		all = append(all, domain.NewDbEntry(curr.Key(), curr.Value(), curr.Tombstone()))
+// Range returns every version of the keys with start <= key < end, by key
+// and then newest first. An empty end leaves the range open.
+func (s *SkipList) Range(start, end string) []domain.DbEntry {
+	var entries []domain.DbEntry
+	for curr := s.seek(start, math.MaxUint64); curr != nil && (end == "" || curr.key < end); curr = curr.next[0].Load() {
+		entries = append(entries, curr.Entry())
 	}
-
-	return all
+	return entries
 }
 
 func (s *SkipList) randomLevel() int {
 	level := 1
-	for s.rand.Float64() < s.p && level < s.maxLevel {
+	for rand.Float64() < s.p && level < s.maxLevel {
 		level++
 	}
 	return level
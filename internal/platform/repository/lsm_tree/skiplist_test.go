@@ -2,8 +2,10 @@ package lsm_tree
 
 import (
 	"KVDB/internal/domain"
+	"fmt"
 	_ "github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 )
 
@@ -88,3 +90,148 @@ func TestSkipList_Reset(t *testing.T) {
 		t.Errorf("Expected no elements in reset skiplist")
 	}
 }
+
+func TestSkipList_ConcurrentWritersAndReaders(t *testing.T) {
+	sl := NewSkipList(16, 0.5)
+	const writers, perWriter = 8, 500
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				all := sl.All()
+				for i := 1; i < len(all); i++ {
+					if all[i-1].Key() >= all[i].Key() {
+						t.Errorf("All() desordenado: %s >= %s", all[i-1].Key(), all[i].Key())
+						return
+					}
+				}
+				if len(all) > 0 {
+					if _, ok := sl.Get(all[len(all)/2].Key()); !ok {
+						t.Errorf("clave visible en All() no encontrada con Get")
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	var writersWg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWg.Add(1)
+		go func(w int) {
+			defer writersWg.Done()
+			for i := 0; i < perWriter; i++ {
+				sl.Set(domain.NewDbEntry(fmt.Sprintf("key-%05d", i*writers+w), "v", false))
+			}
+		}(w)
+	}
+	writersWg.Wait()
+	close(stop)
+	wg.Wait()
+
+	assert.Len(t, sl.All(), writers*perWriter)
+	for i := 0; i < writers*perWriter; i++ {
+		_, ok := sl.Get(fmt.Sprintf("key-%05d", i))
+		assert.True(t, ok)
+	}
+}
+
+func TestSkipList_ConcurrentInsertsOfSameKey(t *testing.T) {
+	sl := NewSkipList(16, 0.5)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				sl.Set(domain.NewDbEntry(fmt.Sprintf("key-%03d", i), fmt.Sprintf("writer-%d", w), false))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// una carrera entre inserciones de la misma clave no debe duplicarla
+	assert.Len(t, sl.All(), 200)
+	for _, entry := range sl.All() {
+		assert.Regexp(t, "^writer-[0-7]$", entry.Value())
+	}
+}
+
+func benchmarkKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%08d", (i*7919)%n)
+	}
+	return keys
+}
+
+func BenchmarkSkipList_Set(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	sl := NewSkipList(32, 0.5)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sl.Set(domain.NewDbEntry(keys[i%len(keys)], "value", false))
+	}
+}
+
+func BenchmarkSkipList_Get(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	sl := NewSkipList(32, 0.5)
+	for _, key := range keys {
+		sl.Set(domain.NewDbEntry(key, "value", false))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sl.Get(keys[i%len(keys)])
+	}
+}
+
+// BenchmarkMemtable_GetWhileWriting measures reads running in parallel with a
+// writer that keeps appending to the WAL.
+func BenchmarkMemtable_GetWhileWriting(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	wal, err := NewWal(b.TempDir(), 1, WalOptions{SyncMode: SyncPeriodic})
+	if err != nil {
+		b.Fatal(err)
+	}
+	mem := NewMemtable(wal)
+	defer mem.Close()
+	for _, key := range keys {
+		mem.Set(domain.NewDbEntry(key, "value", false))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				mem.Set(domain.NewDbEntry(keys[i%len(keys)], "value", false))
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			mem.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
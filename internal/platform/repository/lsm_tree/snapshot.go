@@ -0,0 +1,132 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"slices"
+	"sync"
+)
+
+// Snapshot reads the tree as it was when the snapshot was taken: every write
+// carries a sequence number and the snapshot only sees those up to its own.
+// Compactions keep the versions it needs until it is released.
+type Snapshot struct {
+	tree *LsmTree
+	seq  uint64
+	once sync.Once
+}
+
+// Snapshot pins the current state of the tree. It must be released once it
+// is no longer needed, or older versions are kept around forever.
+func (t *LsmTree) Snapshot() *Snapshot {
+	// Taken under the read lock so no write is half way through
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return &Snapshot{tree: t, seq: t.snapshots.register(t.sequence.Load())}
+}
+
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
+
+// Get, Scan and Prefix read the default namespace, the In variants the one
+// they are given. The snapshot covers every namespace.
+func (s *Snapshot) Get(key string) (DbEntry, bool) {
+	return s.GetIn(DefaultNamespace, key)
+}
+
+func (s *Snapshot) GetIn(namespace, key string) (DbEntry, bool) {
+	return s.tree.getAt(namespace, key, s.seq)
+}
+
+func (s *Snapshot) Scan(start, end string, reverse bool) *Iterator {
+	return s.ScanIn(DefaultNamespace, start, end, reverse)
+}
+
+func (s *Snapshot) ScanIn(namespace, start, end string, reverse bool) *Iterator {
+	return s.tree.scanAt(namespace, start, end, reverse, s.seq)
+}
+
+func (s *Snapshot) Prefix(prefix string, reverse bool) *Iterator {
+	return s.PrefixIn(DefaultNamespace, prefix, reverse)
+}
+
+func (s *Snapshot) PrefixIn(namespace, prefix string, reverse bool) *Iterator {
+	start, end := PrefixRange(prefix)
+	return s.ScanIn(namespace, start, end, reverse)
+}
+
+// Release lets compactions drop the versions only this snapshot could see.
+// It is safe to call more than once.
+func (s *Snapshot) Release() {
+	s.once.Do(func() {
+		s.tree.snapshots.unregister(s.seq)
+	})
+}
+
+// snapshotRegistry counts the live snapshots taken at each sequence number.
+type snapshotRegistry struct {
+	mu   sync.Mutex
+	live map[uint64]int
+}
+
+func (r *snapshotRegistry) register(seq uint64) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.live == nil {
+		r.live = make(map[uint64]int)
+	}
+	r.live[seq]++
+	return seq
+}
+
+func (r *snapshotRegistry) unregister(seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.live[seq]--; r.live[seq] <= 0 {
+		delete(r.live, seq)
+	}
+}
+
+// sequences returns the sequence numbers of the live snapshots, ascending.
+func (r *snapshotRegistry) sequences() []uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seqs := make([]uint64, 0, len(r.live))
+	for seq := range r.live {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs
+}
+
+// retainVersions filters the versions of one key, newest first, down to the
+// ones still visible to someone: the newest, plus every older version that
+// is the newest one a live snapshot can see.
+func retainVersions(versions []DbEntry, snapshots []uint64) []DbEntry {
+	kept := []DbEntry{versions[0]}
+	for i := 1; i < len(versions); i++ {
+		if visibleTo(snapshots, versions[i].Seq(), versions[i-1].Seq()) {
+			kept = append(kept, versions[i])
+		}
+	}
+	return kept
+}
+
+// visibleTo tells whether some snapshot falls in [from, to).
+func visibleTo(snapshots []uint64, from, to uint64) bool {
+	i, _ := slices.BinarySearch(snapshots, from)
+	return i < len(snapshots) && snapshots[i] < to
+}
+
+// versionGroups calls fn with the versions of each key in turn. Entries must
+// be sorted by key and then newest first.
+func versionGroups(entries []DbEntry, fn func(versions []DbEntry)) {
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].Key() == entries[start].Key() {
+			end++
+		}
+		fn(entries[start:end])
+		start = end
+	}
+}
@@ -0,0 +1,114 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stamped(key, value string, tombstone bool, seq uint64) DbEntry {
+	entry := NewDbEntry(key, value, tombstone)
+	entry.SetSeq(seq)
+	return entry
+}
+
+func TestLsmTree_StampsIncreasingSequenceNumbers(t *testing.T) {
+	tree := openTree(t, config.Config{WalDirectory: t.TempDir()})
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.Set(NewDbEntry("a", "2", false))
+
+	latest, _ := tree.Get("a")
+	assert.Equal(t, uint64(2), latest.Seq())
+	first, found := tree.getAt(DefaultNamespace, "a", 1)
+	assert.True(t, found)
+	assert.Equal(t, "1", first.Value(), "la versión anterior debe seguir en la memtable")
+}
+
+func TestSnapshot_ReadsStateWhenTaken(t *testing.T) {
+	tree := openTree(t, config.Config{WalDirectory: t.TempDir()})
+	tree.Set(NewDbEntry("a", "1", false))
+	tree.Set(NewDbEntry("b", "1", false))
+
+	snapshot := tree.Snapshot()
+	defer snapshot.Release()
+	tree.Set(NewDbEntry("a", "2", false))
+	tree.Set(NewDbEntry("b", "", true))
+	tree.Set(NewDbEntry("c", "2", false))
+
+	got, found := snapshot.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, "1", got.Value())
+	_, found = snapshot.Get("b")
+	assert.True(t, found, "el borrado posterior no debe verse en la snapshot")
+	_, found = snapshot.Get("c")
+	assert.False(t, found)
+
+	assert.Equal(t, []string{"a=1", "b=1"}, collect(t, snapshot.Scan("", "", false)))
+	assert.Equal(t, []string{"b=1", "a=1"}, collect(t, snapshot.Scan("", "", true)))
+	assert.Equal(t, []string{"a=2", "c=2"}, collect(t, tree.Scan("", "", false)))
+}
+
+func TestSnapshot_SurvivesFlushAndCompaction(t *testing.T) {
+	tree := openTree(t, config.Config{WalDirectory: t.TempDir(), MemtableSizeThreshold: 2048})
+	for i := 0; i < 50; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), "before", false))
+	}
+	snapshot := tree.Snapshot()
+	defer snapshot.Release()
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			tree.Set(NewDbEntry(fmt.Sprintf("key-%03d", i), fmt.Sprintf("after-%d", round), false))
+		}
+	}
+	waitForCompaction(t, tree)
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		got, found := snapshot.Get(key)
+		assert.True(t, found, key)
+		assert.Equal(t, "before", got.Value(), key)
+		got, _ = tree.Get(key)
+		assert.Equal(t, "after-4", got.Value(), key)
+	}
+}
+
+func TestCompaction_KeepsVersionsForLiveSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	tree := recoverTree(t, dir)
+	newer := writeTable(t, dir, "newer.sst", stamped("a", "", true, 5), stamped("b", "v4", false, 4))
+	older := writeTable(t, dir, "older.sst", stamped("a", "v3", false, 3), stamped("a", "v1", false, 1), stamped("b", "v2", false, 2))
+	setLevel(tree, 0, newer, older)
+
+	tree.snapshots.register(3)
+	err := tree.compact(&compaction{ns: defaultNamespace(tree), inputs: []*SSTableReader{newer, older}, outputLevel: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []DbEntry{
+		stamped("a", "", true, 5),
+		stamped("a", "v3", false, 3),
+		stamped("b", "v4", false, 4),
+		stamped("b", "v2", false, 2),
+	}, allTableEntries(t, tree), "se conservan las versiones que ve la snapshot en 3")
+
+	tree.snapshots.unregister(3)
+	err = tree.compact(&compaction{ns: defaultNamespace(tree), inputs: defaultLevels(tree)[1], outputLevel: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []DbEntry{
+		stamped("b", "v4", false, 4),
+	}, allTableEntries(t, tree), "sin snapshots solo queda la versión más nueva")
+}
+
+func TestRetainVersions(t *testing.T) {
+	versions := []DbEntry{
+		stamped("k", "v9", false, 9),
+		stamped("k", "v7", false, 7),
+		stamped("k", "v4", false, 4),
+		stamped("k", "v2", false, 2),
+	}
+	assert.Equal(t, versions[:1], retainVersions(versions, nil))
+	assert.Equal(t, []DbEntry{versions[0], versions[2]}, retainVersions(versions, []uint64{5, 6}))
+	assert.Equal(t, []DbEntry{versions[0], versions[1], versions[3]}, retainVersions(versions, []uint64{1, 3, 8}))
+}
@@ -1,6 +1,33 @@
 package lsm_tree
 
-import "KVDB/internal/domain"
+import (
+	"KVDB/internal/domain"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	MagicNumber uint64 = 0x4b56444253535431 // "KVDBSST1"
+	SSTVersion  uint32 = 5
+
+	// uncompressedVersion tables have no block trailers nor codec in the
+	// header. They are still read, and rewritten by compactions.
+	uncompressedVersion    uint32 = 3
+	uncompressedHeaderSize        = 28
+	// unversionedVersion tables hold entries with no replicated version,
+	// and are read like the current ones
+	unversionedVersion uint32 = 4
+
+	headerSize = 32
+	footerSize = 56
+
+	defaultBlockSize = 4 * 1024
+
+	sstPrefix    = "sst-"
+	sstExtension = ".sst"
+)
 
 type BlockMetadata struct {
 	Offset uint64
@@ -10,12 +37,22 @@ type Header struct {
 	Version   uint32
 	Timestamp uint64
 	NumBlocks uint32
+	Level     uint32
+	MaxSeq    uint64 // highest sequence number stored in the table
+	Codec     Codec  // codec the data blocks were written with
 }
 
+// DataBlock holds entries sorted by key and, for equal keys, newest first.
 type DataBlock struct {
 	Entries []domain.DbEntry
 }
 
+// FilterBlock holds the bloom filter of every key stored in the table.
+type FilterBlock struct {
+	Filter   *BloomFilter
+	Metadata BlockMetadata
+}
+
 type IndexBlock struct {
 	Entries  []IndexEntry
 	Metadata BlockMetadata
@@ -30,12 +67,53 @@ type IndexEntry struct {
 type Footer struct {
 	IndexMetadata  BlockMetadata
 	HeaderMetadata BlockMetadata
+	FilterMetadata BlockMetadata
 	MagicNumber    uint64
 }
 
 type SortedStringsTable struct {
 	Header *Header
 	Data   *[]DataBlock
+	Filter *FilterBlock
 	Index  *IndexBlock
 	Footer *Footer
 }
+
+// sstPath names a flushed table after the newest WAL segment it replaces.
+func sstPath(dir, version string) string {
+	return path.Join(dir, sstPrefix+version+sstExtension)
+}
+
+func sstVersionFromName(name string) (string, bool) {
+	if !strings.HasPrefix(name, sstPrefix) || !strings.HasSuffix(name, sstExtension) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(name, sstPrefix), sstExtension), true
+}
+
+// ListSSTables returns the paths of the SSTables stored in dir, oldest first.
+func ListSSTables(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if version, ok := sstVersionFromName(f.Name()); ok {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareWalVersions(versions[i], versions[j]) < 0
+	})
+
+	tables := make([]string, len(versions))
+	for i, version := range versions {
+		tables[i] = sstPath(dir, version)
+	}
+	return tables, nil
+}
@@ -0,0 +1,258 @@
+package lsm_tree
+
+import (
+	"KVDB/internal/domain"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrCorruptedBlock = errors.New("corrupted sstable block")
+
+func (h *Header) encode() []byte {
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(buf[0:], h.Version)
+	binary.LittleEndian.PutUint64(buf[4:], h.Timestamp)
+	binary.LittleEndian.PutUint32(buf[12:], h.NumBlocks)
+	binary.LittleEndian.PutUint32(buf[16:], h.Level)
+	binary.LittleEndian.PutUint64(buf[20:], h.MaxSeq)
+	binary.LittleEndian.PutUint32(buf[28:], uint32(h.Codec))
+	return buf
+}
+
+func decodeHeader(buf []byte) (Header, error) {
+	if len(buf) != headerSize && len(buf) != uncompressedHeaderSize {
+		return Header{}, fmt.Errorf("%w: header size %d", ErrCorruptedBlock, len(buf))
+	}
+	header := Header{
+		Version:   binary.LittleEndian.Uint32(buf[0:]),
+		Timestamp: binary.LittleEndian.Uint64(buf[4:]),
+		NumBlocks: binary.LittleEndian.Uint32(buf[12:]),
+		Level:     binary.LittleEndian.Uint32(buf[16:]),
+		MaxSeq:    binary.LittleEndian.Uint64(buf[20:]),
+	}
+	if len(buf) == headerSize {
+		header.Codec = Codec(binary.LittleEndian.Uint32(buf[28:]))
+	}
+	return header, nil
+}
+
+func (f *Footer) encode() []byte {
+	buf := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(buf[0:], f.IndexMetadata.Offset)
+	binary.LittleEndian.PutUint64(buf[8:], f.IndexMetadata.Size)
+	binary.LittleEndian.PutUint64(buf[16:], f.HeaderMetadata.Offset)
+	binary.LittleEndian.PutUint64(buf[24:], f.HeaderMetadata.Size)
+	binary.LittleEndian.PutUint64(buf[32:], f.FilterMetadata.Offset)
+	binary.LittleEndian.PutUint64(buf[40:], f.FilterMetadata.Size)
+	binary.LittleEndian.PutUint64(buf[48:], f.MagicNumber)
+	return buf
+}
+
+func decodeFooter(buf []byte) (Footer, error) {
+	if len(buf) != footerSize {
+		return Footer{}, fmt.Errorf("%w: footer size %d", ErrCorruptedBlock, len(buf))
+	}
+	footer := Footer{
+		IndexMetadata: BlockMetadata{
+			Offset: binary.LittleEndian.Uint64(buf[0:]),
+			Size:   binary.LittleEndian.Uint64(buf[8:]),
+		},
+		HeaderMetadata: BlockMetadata{
+			Offset: binary.LittleEndian.Uint64(buf[16:]),
+			Size:   binary.LittleEndian.Uint64(buf[24:]),
+		},
+		FilterMetadata: BlockMetadata{
+			Offset: binary.LittleEndian.Uint64(buf[32:]),
+			Size:   binary.LittleEndian.Uint64(buf[40:]),
+		},
+		MagicNumber: binary.LittleEndian.Uint64(buf[48:]),
+	}
+	if footer.MagicNumber != MagicNumber {
+		return Footer{}, fmt.Errorf("%w: bad magic number %x", ErrCorruptedBlock, footer.MagicNumber)
+	}
+	return footer, nil
+}
+
+// An entry is laid out as [Key][Value][Flags][ExpiresAt][Version][Seq],
+// where ExpiresAt is only present when entryExpires is set and Version when
+// entryVersioned is. With entryExternal the value is an encoded ValuePointer
+// into the value log.
+const (
+	entryTombstone byte = 1 << iota
+	entryExpires
+	entryExternal
+	entryVersioned
+)
+
+// encodedEntrySize is the number of bytes an entry takes inside a data block.
+func encodedEntrySize(entry domain.DbEntry) int {
+	size := 4 + len(entry.Key()) + 4 + len(entry.Value()) + 1 + 8
+	if entry.ExpiresAt() != 0 {
+		size += 8
+	}
+	if entry.Version() != 0 {
+		size += 8
+	}
+	return size
+}
+
+func (b *DataBlock) encode() []byte {
+	size := 4
+	for _, entry := range b.Entries {
+		size += encodedEntrySize(entry)
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(b.Entries)))
+	for _, entry := range b.Entries {
+		buf = appendEntry(buf, entry)
+	}
+	return buf
+}
+
+func decodeDataBlock(buf []byte) (DataBlock, error) {
+	d := decoder{buf: buf}
+	count := d.uint32()
+	entries := make([]domain.DbEntry, 0, count)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		entries = append(entries, d.entry())
+	}
+	if d.err != nil {
+		return DataBlock{}, d.err
+	}
+	return DataBlock{Entries: entries}, nil
+}
+
+func (b *IndexBlock) encode() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(b.Entries)))
+	for _, entry := range b.Entries {
+		buf = appendString(buf, entry.FirstKey)
+		buf = appendString(buf, entry.LastKey)
+		buf = binary.LittleEndian.AppendUint64(buf, entry.Metadata.Offset)
+		buf = binary.LittleEndian.AppendUint64(buf, entry.Metadata.Size)
+	}
+	return buf
+}
+
+func decodeIndexBlock(buf []byte, metadata BlockMetadata) (IndexBlock, error) {
+	d := decoder{buf: buf}
+	count := d.uint32()
+	entries := make([]IndexEntry, 0, count)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		entries = append(entries, IndexEntry{
+			FirstKey: d.string(),
+			LastKey:  d.string(),
+			Metadata: BlockMetadata{
+				Offset: d.uint64(),
+				Size:   d.uint64(),
+			},
+		})
+	}
+	if d.err != nil {
+		return IndexBlock{}, d.err
+	}
+	return IndexBlock{Entries: entries, Metadata: metadata}, nil
+}
+
+func appendEntry(buf []byte, entry domain.DbEntry) []byte {
+	buf = appendString(buf, entry.Key())
+	buf = appendString(buf, entry.Value())
+	var flags byte
+	if entry.Tombstone() {
+		flags |= entryTombstone
+	}
+	if entry.ExpiresAt() != 0 {
+		flags |= entryExpires
+	}
+	if entry.External() {
+		flags |= entryExternal
+	}
+	if entry.Version() != 0 {
+		flags |= entryVersioned
+	}
+	buf = append(buf, flags)
+	if flags&entryExpires != 0 {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.ExpiresAt()))
+	}
+	if flags&entryVersioned != 0 {
+		buf = binary.LittleEndian.AppendUint64(buf, entry.Version())
+	}
+	return binary.LittleEndian.AppendUint64(buf, entry.Seq())
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads little endian fields from a block, remembering the first
+// out of bounds access so callers only check once at the end.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.pos+n > len(d.buf) {
+		d.err = fmt.Errorf("%w: read past end of block at offset %d", ErrCorruptedBlock, d.pos)
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) entry() domain.DbEntry {
+	entry := d.unstampedEntry()
+	entry.SetSeq(d.uint64())
+	return entry
+}
+
+// unstampedEntry reads an entry written before entries carried a sequence
+// number.
+func (d *decoder) unstampedEntry() domain.DbEntry {
+	key := d.string()
+	value := d.string()
+	flags := d.byte()
+	entry := domain.NewDbEntry(key, value, flags&entryTombstone != 0)
+	if flags&entryExternal != 0 {
+		entry.SetValue(value, true)
+	}
+	if flags&entryExpires != 0 {
+		entry.SetExpiresAt(int64(d.uint64()))
+	}
+	if flags&entryVersioned != 0 {
+		entry.SetVersion(d.uint64())
+	}
+	return entry
+}
@@ -0,0 +1,342 @@
+package lsm_tree
+
+import (
+	"KVDB/internal/domain"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"sync/atomic"
+)
+
+// ReaderOptions sets the caches SSTable reads go through. Both are optional.
+type ReaderOptions struct {
+	BlockCache *BlockCache
+	// TableCache opens table files on demand. Without it every table keeps
+	// its file open for as long as it is in use.
+	TableCache *TableCache
+}
+
+// tableIds tells tables apart in the block cache, even when a path is reused.
+var tableIds atomic.Uint64
+
+// SSTableReader gives access to an SSTable on disk. Only the header, filter,
+// index and footer are kept in memory; data blocks are read on demand.
+type SSTableReader struct {
+	id     uint64
+	path   string
+	fd     *os.File
+	opts   ReaderOptions
+	size   int64
+	header Header
+	filter *BloomFilter
+	index  IndexBlock
+	footer Footer
+
+	// refs counts the tree plus every iterator still reading the table. The
+	// file is closed when the last of them lets go.
+	refs atomic.Int32
+}
+
+func OpenSSTable(path string) (*SSTableReader, error) {
+	return OpenSSTableWith(path, ReaderOptions{})
+}
+
+func OpenSSTableWith(path string, opts ReaderOptions) (*SSTableReader, error) {
+	r := &SSTableReader{id: tableIds.Add(1), path: path, opts: opts}
+	if opts.TableCache == nil {
+		fd, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r.fd = fd
+	}
+	if err := r.load(); err != nil {
+		r.closeFile()
+		return nil, fmt.Errorf("opening sstable %s: %w", path, err)
+	}
+	r.refs.Store(1)
+	return r, nil
+}
+
+func (r *SSTableReader) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.size = info.Size()
+	if r.size < headerSize+footerSize {
+		return fmt.Errorf("%w: file too small", ErrCorruptedBlock)
+	}
+
+	buf, err := r.readBlock(BlockMetadata{Offset: uint64(r.size - footerSize), Size: footerSize})
+	if err != nil {
+		return err
+	}
+	if r.footer, err = decodeFooter(buf); err != nil {
+		return err
+	}
+
+	if buf, err = r.readBlock(r.footer.HeaderMetadata); err != nil {
+		return err
+	}
+	if r.header, err = decodeHeader(buf); err != nil {
+		return err
+	}
+	if r.header.Version != SSTVersion && r.header.Version != unversionedVersion && r.header.Version != uncompressedVersion {
+		return fmt.Errorf("unsupported sstable version %d", r.header.Version)
+	}
+
+	if buf, err = r.readBlock(r.footer.FilterMetadata); err != nil {
+		return err
+	}
+	if r.filter, err = decodeBloomFilter(buf); err != nil {
+		return err
+	}
+
+	if buf, err = r.readBlock(r.footer.IndexMetadata); err != nil {
+		return err
+	}
+	r.index, err = decodeIndexBlock(buf, r.footer.IndexMetadata)
+	return err
+}
+
+func (r *SSTableReader) readBlock(metadata BlockMetadata) ([]byte, error) {
+	if metadata.Offset+metadata.Size > uint64(r.size) {
+		return nil, fmt.Errorf("%w: block out of file bounds", ErrCorruptedBlock)
+	}
+	buf := make([]byte, metadata.Size)
+	if r.opts.TableCache != nil {
+		return buf, r.opts.TableCache.readAt(r.path, buf, int64(metadata.Offset))
+	}
+	if _, err := r.fd.ReadAt(buf, int64(metadata.Offset)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readDataBlock returns the decoded block, which may be shared through the
+// block cache and must not be modified.
+func (r *SSTableReader) readDataBlock(metadata BlockMetadata) (DataBlock, error) {
+	cache := r.opts.BlockCache
+	key := blockKey{table: r.id, offset: metadata.Offset}
+	if cache != nil {
+		if block, ok := cache.get(key); ok {
+			return block, nil
+		}
+	}
+	buf, err := r.readBlock(metadata)
+	if err != nil {
+		return DataBlock{}, err
+	}
+	if r.header.Version != uncompressedVersion {
+		if buf, err = decompressBlock(buf); err != nil {
+			return DataBlock{}, err
+		}
+	}
+	block, err := decodeDataBlock(buf)
+	if err != nil {
+		return DataBlock{}, err
+	}
+	if cache != nil {
+		// Charged by its uncompressed size, closer to what it takes in memory
+		cache.put(key, block, int64(len(buf)))
+	}
+	return block, nil
+}
+
+// MayContain checks the bloom filter. When it returns false the key is
+// certainly not in the table.
+func (r *SSTableReader) MayContain(key string) bool {
+	return r.filter.MayContain(key)
+}
+
+// Get returns the newest version of key.
+func (r *SSTableReader) Get(key string) (domain.DbEntry, bool, error) {
+	return r.GetAt(key, math.MaxUint64)
+}
+
+// GetAt returns the newest version of key with a sequence number up to seq.
+// The versions of a key may span several blocks, they are walked newest
+// first starting at the first block that can hold the key.
+func (r *SSTableReader) GetAt(key string, seq uint64) (domain.DbEntry, bool, error) {
+	it := r.rangeIterator(key, key+"\x00", false)
+	for {
+		entry, ok := it.Next()
+		if !ok {
+			return domain.DbEntry{}, false, it.Err()
+		}
+		if entry.Seq() <= seq {
+			return entry, true, nil
+		}
+	}
+}
+
+// All decodes every entry of the table in key order.
+func (r *SSTableReader) All() ([]domain.DbEntry, error) {
+	var all []domain.DbEntry
+	for _, entry := range r.index.Entries {
+		block, err := r.readDataBlock(entry.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, block.Entries...)
+	}
+	return all, nil
+}
+
+func (r *SSTableReader) Path() string {
+	return r.path
+}
+
+// Name is the file name of the table, the way the manifest refers to it.
+func (r *SSTableReader) Name() string {
+	return path.Base(r.path)
+}
+
+func (r *SSTableReader) Size() int64 {
+	return r.size
+}
+
+func (r *SSTableReader) Close() error {
+	return r.release()
+}
+
+// acquire keeps the table open for an iterator that outlives the tree lock.
+func (r *SSTableReader) acquire() {
+	r.refs.Add(1)
+}
+
+func (r *SSTableReader) release() error {
+	if r.refs.Add(-1) == 0 {
+		return r.closeFile()
+	}
+	return nil
+}
+
+func (r *SSTableReader) closeFile() error {
+	if r.opts.TableCache != nil {
+		r.opts.TableCache.evict(r.path)
+		return nil
+	}
+	return r.fd.Close()
+}
+
+func (r *SSTableReader) FirstKey() string {
+	if len(r.index.Entries) == 0 {
+		return ""
+	}
+	return r.index.Entries[0].FirstKey
+}
+
+func (r *SSTableReader) LastKey() string {
+	if len(r.index.Entries) == 0 {
+		return ""
+	}
+	return r.index.Entries[len(r.index.Entries)-1].LastKey
+}
+
+// Codec returns the codec the table was written with.
+func (r *SSTableReader) Codec() Codec {
+	return r.header.Codec
+}
+
+func (r *SSTableReader) MaxSeq() uint64 {
+	return r.header.MaxSeq
+}
+
+func (r *SSTableReader) Level() int {
+	return int(r.header.Level)
+}
+
+// tableIterator walks the entries of a table with start <= key < end, one
+// data block at a time, in key order or in reverse. Empty bounds leave the
+// range open.
+type tableIterator struct {
+	table      *SSTableReader
+	start, end string
+	reverse    bool
+	block      int // next block to read
+	entries    []domain.DbEntry
+	pos        int
+	err        error
+}
+
+func (r *SSTableReader) iterator() *tableIterator {
+	return r.rangeIterator("", "", false)
+}
+
+func (r *SSTableReader) rangeIterator(start, end string, reverse bool) *tableIterator {
+	it := &tableIterator{table: r, start: start, end: end, reverse: reverse}
+	entries := r.index.Entries
+	if reverse {
+		// last block starting before end
+		it.block = len(entries) - 1
+		if end != "" {
+			it.block = sort.Search(len(entries), func(i int) bool {
+				return entries[i].FirstKey >= end
+			}) - 1
+		}
+	} else {
+		// first block ending at or after start
+		it.block = sort.Search(len(entries), func(i int) bool {
+			return entries[i].LastKey >= start
+		})
+	}
+	return it
+}
+
+func (it *tableIterator) Next() (domain.DbEntry, bool) {
+	for {
+		for it.pos < len(it.entries) {
+			entry := it.entries[it.pos]
+			it.pos++
+			if it.reverse {
+				if it.end != "" && entry.Key() >= it.end {
+					continue
+				}
+				if entry.Key() < it.start {
+					it.stop()
+					return domain.DbEntry{}, false
+				}
+			} else {
+				if entry.Key() < it.start {
+					continue
+				}
+				if it.end != "" && entry.Key() >= it.end {
+					it.stop()
+					return domain.DbEntry{}, false
+				}
+			}
+			return entry, true
+		}
+		if it.err != nil || it.block < 0 || it.block >= len(it.table.index.Entries) {
+			return domain.DbEntry{}, false
+		}
+		block, err := it.table.readDataBlock(it.table.index.Entries[it.block].Metadata)
+		if err != nil {
+			it.err = err
+			return domain.DbEntry{}, false
+		}
+		it.entries, it.pos = block.Entries, 0
+		if it.reverse {
+			it.entries = slices.Clone(block.Entries)
+			slices.Reverse(it.entries)
+			it.block--
+		} else {
+			it.block++
+		}
+	}
+}
+
+// stop ends the iteration once a key falls past the range.
+func (it *tableIterator) stop() {
+	it.entries = nil
+	it.block = -1
+}
+
+func (it *tableIterator) Err() error {
+	return it.err
+}
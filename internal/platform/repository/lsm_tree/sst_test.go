@@ -0,0 +1,64 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortedEntries(n int) []DbEntry {
+	entries := make([]DbEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, NewDbEntry(fmt.Sprintf("key-%05d", i), fmt.Sprintf("value-%d", i), i%10 == 0))
+	}
+	return entries
+}
+
+func TestSSTable_WriteAndGet(t *testing.T) {
+	file := path.Join(t.TempDir(), "test.sst")
+	entries := sortedEntries(2000)
+
+	table, err := NewSSTableWriter(SSTableOptions{}).Write(file, 0, entries)
+	assert.NoError(t, err)
+	assert.Greater(t, len(*table.Data), 1, "las entradas deben repartirse en varios bloques")
+	assert.Equal(t, MagicNumber, table.Footer.MagicNumber)
+
+	reader, err := OpenSSTable(file)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	for _, expected := range []DbEntry{entries[0], entries[777], entries[1999]} {
+		got, found, err := reader.Get(expected.Key())
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, expected, got)
+	}
+
+	_, found, err := reader.Get("key-99999")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, _ = reader.Get("a")
+	assert.False(t, found)
+
+	all, err := reader.All()
+	assert.NoError(t, err)
+	assert.Equal(t, entries, all)
+}
+
+func TestSSTable_RejectsBadMagicNumber(t *testing.T) {
+	file := path.Join(t.TempDir(), "test.sst")
+	_, err := NewSSTableWriter(SSTableOptions{}).Write(file, 0, sortedEntries(10))
+	assert.NoError(t, err)
+
+	data, _ := os.ReadFile(file)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(file, data, 0644)
+
+	_, err = OpenSSTable(file)
+	assert.True(t, errors.Is(err, ErrCorruptedBlock))
+}
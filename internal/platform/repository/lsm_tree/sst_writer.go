@@ -0,0 +1,156 @@
+package lsm_tree
+
+import (
+	"KVDB/internal/domain"
+	"bufio"
+	"os"
+	"time"
+)
+
+type SSTableOptions struct {
+	BloomFalsePositiveRate float64
+	Compression            Codec
+}
+
+// SSTableWriter lays out sorted entries as
+// [Header][DataBlock...][FilterBlock][IndexBlock][Footer]. Data blocks are
+// compressed one by one, so a read only decompresses the block it needs.
+type SSTableWriter struct {
+	blockSize         int
+	falsePositiveRate float64
+	codec             Codec
+}
+
+func NewSSTableWriter(opts SSTableOptions) *SSTableWriter {
+	return &SSTableWriter{
+		blockSize:         defaultBlockSize,
+		falsePositiveRate: opts.BloomFalsePositiveRate,
+		codec:             opts.Compression,
+	}
+}
+
+// Write stores entries, which must be sorted by key and then newest first, in
+// a new table at path that belongs to the given level. The table is written to
+// a temporary file and renamed once synced, so a crash never leaves a half
+// written table under its final name.
+func (w *SSTableWriter) Write(path string, level int, entries []domain.DbEntry) (*SortedStringsTable, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	table, err := w.writeTo(f, level, entries)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (w *SSTableWriter) writeTo(f *os.File, level int, entries []domain.DbEntry) (*SortedStringsTable, error) {
+	out := bufio.NewWriter(f)
+	blocks := w.split(entries)
+
+	header := &Header{
+		Version:   SSTVersion,
+		Timestamp: uint64(time.Now().UnixNano()),
+		NumBlocks: uint32(len(blocks)),
+		Level:     uint32(level),
+		Codec:     w.codec,
+	}
+	for _, entry := range entries {
+		header.MaxSeq = max(header.MaxSeq, entry.Seq())
+	}
+	offset := uint64(0)
+	write := func(buf []byte) (BlockMetadata, error) {
+		metadata := BlockMetadata{Offset: offset, Size: uint64(len(buf))}
+		_, err := out.Write(buf)
+		offset += metadata.Size
+		return metadata, err
+	}
+
+	headerMetadata, err := write(header.encode())
+	if err != nil {
+		return nil, err
+	}
+
+	index := &IndexBlock{Entries: make([]IndexEntry, 0, len(blocks))}
+	for _, block := range blocks {
+		metadata, err := write(compressBlock(w.codec, block.encode()))
+		if err != nil {
+			return nil, err
+		}
+		index.Entries = append(index.Entries, IndexEntry{
+			FirstKey: block.Entries[0].Key(),
+			LastKey:  block.Entries[len(block.Entries)-1].Key(),
+			Metadata: metadata,
+		})
+	}
+
+	filter := &FilterBlock{Filter: NewBloomFilter(len(entries), w.falsePositiveRate)}
+	for _, entry := range entries {
+		filter.Filter.Add(entry.Key())
+	}
+	filter.Metadata, err = write(filter.Filter.encode())
+	if err != nil {
+		return nil, err
+	}
+
+	index.Metadata, err = write(index.encode())
+	if err != nil {
+		return nil, err
+	}
+
+	footer := &Footer{
+		IndexMetadata:  index.Metadata,
+		HeaderMetadata: headerMetadata,
+		FilterMetadata: filter.Metadata,
+		MagicNumber:    MagicNumber,
+	}
+	if _, err := write(footer.encode()); err != nil {
+		return nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &SortedStringsTable{
+		Header: header,
+		Data:   &blocks,
+		Filter: filter,
+		Index:  index,
+		Footer: footer,
+	}, nil
+}
+
+// split groups entries into data blocks of roughly blockSize bytes.
+func (w *SSTableWriter) split(entries []domain.DbEntry) []DataBlock {
+	var blocks []DataBlock
+	var current DataBlock
+	size := 0
+	for _, entry := range entries {
+		current.Entries = append(current.Entries, entry)
+		size += encodedEntrySize(entry)
+		if size >= w.blockSize {
+			blocks = append(blocks, current)
+			current = DataBlock{}
+			size = 0
+		}
+	}
+	if len(current.Entries) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
@@ -0,0 +1,388 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	vlogPrefix    = "vlog-"
+	vlogExtension = ".vlog"
+
+	// A value log record is laid out as [CRC][KeyLength][ValueLength][Key]
+	// [Value], the checksum covering everything after it. The key is kept so
+	// the garbage collector can tell whether the record is still referenced.
+	// Keys outside the default namespace have vlogNamespacedKey set in their
+	// length and are stored as [NamespaceLength][Namespace][Key].
+	vlogRecordHeaderSize = 12
+	valuePointerSize     = 20
+	vlogNamespacedKey    = 1 << 31
+
+	defaultValueLogFileSize = 64 * 1024 * 1024
+)
+
+var ErrCorruptedValue = errors.New("corrupted value log record")
+
+// ValueLogOptions sets when values are moved out of the tree. Values of at
+// least Threshold bytes go to the value log; zero keeps every value inline.
+type ValueLogOptions struct {
+	Threshold int
+	// MaxFileSize is the size at which the log moves on to a new file. Only
+	// files no longer written to are garbage collected.
+	MaxFileSize int64
+	// DiscardRatio is the fraction of a file that must be garbage before the
+	// collector rewrites it.
+	DiscardRatio float64
+}
+
+// ValuePointer locates a record of the value log.
+type ValuePointer struct {
+	File   uint64 `json:"file"`
+	Offset uint64 `json:"offset"`
+	Length uint32 `json:"length"`
+}
+
+func (p ValuePointer) encode() string {
+	buf := make([]byte, 0, valuePointerSize)
+	buf = binary.LittleEndian.AppendUint64(buf, p.File)
+	buf = binary.LittleEndian.AppendUint64(buf, p.Offset)
+	buf = binary.LittleEndian.AppendUint32(buf, p.Length)
+	return string(buf)
+}
+
+func decodeValuePointer(s string) (ValuePointer, error) {
+	if len(s) != valuePointerSize {
+		return ValuePointer{}, fmt.Errorf("%w: value pointer of %d bytes", ErrCorruptedValue, len(s))
+	}
+	buf := []byte(s)
+	return ValuePointer{
+		File:   binary.LittleEndian.Uint64(buf[0:]),
+		Offset: binary.LittleEndian.Uint64(buf[8:]),
+		Length: binary.LittleEndian.Uint32(buf[16:]),
+	}, nil
+}
+
+// ValueLog is an append-only store for large values, so compactions move
+// small pointers around instead of the values themselves. The WAL still
+// logs the full value: records the log loses in a crash are written again
+// on replay, and it only needs to be synced before a flush makes the
+// pointers to it durable.
+type ValueLog struct {
+	dir  string
+	opts ValueLogOptions
+	// nextNumber hands out file numbers, shared with the tree
+	nextNumber func() uint64
+
+	mu           sync.RWMutex
+	files        map[uint64]*os.File // every file, open for reading
+	active       *os.File
+	activeNumber uint64
+	activeSize   int64
+}
+
+// openValueLog opens the files found in dir. A new file is started for the
+// values that follow: the last one may end in a torn record, so it is never
+// appended to.
+func openValueLog(dir string, opts ValueLogOptions, nextNumber func() uint64) (*ValueLog, error) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultValueLogFileSize
+	}
+	v := &ValueLog{dir: dir, opts: opts, nextNumber: nextNumber, files: make(map[uint64]*os.File)}
+	numbers, err := listValueLogFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range numbers {
+		fd, err := os.Open(vlogPath(dir, number))
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.files[number] = fd
+	}
+	return v, nil
+}
+
+func vlogPath(dir string, number uint64) string {
+	return path.Join(dir, vlogPrefix+formatVersion(number)+vlogExtension)
+}
+
+// listValueLogFiles returns the numbers of the value log files in dir,
+// oldest first.
+func listValueLogFiles(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, vlogPrefix) || !strings.HasSuffix(name, vlogExtension) {
+			continue
+		}
+		if n, ok := versionNumber(strings.TrimSuffix(strings.TrimPrefix(name, vlogPrefix), vlogExtension)); ok {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers, nil
+}
+
+// separates tells whether the value of entry belongs in the value log.
+func (v *ValueLog) separates(entry DbEntry) bool {
+	return v != nil && v.opts.Threshold > 0 && !entry.Tombstone() && !entry.External() &&
+		len(entry.Value()) >= v.opts.Threshold
+}
+
+// separate moves the value of entry to the log when it is large enough and
+// returns the entry holding the pointer instead.
+func (v *ValueLog) separate(entry DbEntry) (DbEntry, error) {
+	if !v.separates(entry) {
+		return entry, nil
+	}
+	ptr, err := v.append(storedNamespace(entry.Namespace()), entry.Key(), entry.Value())
+	if err != nil {
+		return entry, err
+	}
+	entry.SetValue(ptr.encode(), true)
+	return entry, nil
+}
+
+func (v *ValueLog) append(namespace, key, value string) (ValuePointer, error) {
+	keyLength := uint32(len(key))
+	if namespace != "" {
+		keyLength = uint32(4+len(namespace)+len(key)) | vlogNamespacedKey
+	}
+	record := make([]byte, vlogRecordHeaderSize, vlogRecordHeaderSize+4+len(namespace)+len(key)+len(value))
+	binary.LittleEndian.PutUint32(record[4:], keyLength)
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(value)))
+	if namespace != "" {
+		record = appendString(record, namespace)
+	}
+	record = append(record, key...)
+	record = append(record, value...)
+	binary.LittleEndian.PutUint32(record[0:], crc32.Checksum(record[4:], castagnoli))
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.active == nil || v.activeSize >= v.opts.MaxFileSize {
+		if err := v.seal(); err != nil {
+			return ValuePointer{}, err
+		}
+		if err := v.rotate(); err != nil {
+			return ValuePointer{}, err
+		}
+	}
+	ptr := ValuePointer{File: v.activeNumber, Offset: uint64(v.activeSize), Length: uint32(len(record))}
+	n, err := v.active.Write(record)
+	v.activeSize += int64(n)
+	return ptr, err
+}
+
+// seal syncs the active file, which is only read from afterwards. Must be
+// called with mu held.
+func (v *ValueLog) seal() error {
+	if v.active == nil {
+		return nil
+	}
+	if err := v.active.Sync(); err != nil {
+		return err
+	}
+	err := v.active.Close()
+	v.active = nil
+	return err
+}
+
+// rotate starts a new active file. Must be called with mu held.
+func (v *ValueLog) rotate() error {
+	number := v.nextNumber()
+	name := vlogPath(v.dir, number)
+	active, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0755)
+	if err != nil {
+		return err
+	}
+	reader, err := os.Open(name)
+	if err != nil {
+		active.Close()
+		return err
+	}
+	v.files[number] = reader
+	v.active, v.activeNumber, v.activeSize = active, number, 0
+	return nil
+}
+
+// resolve returns entry with the value its pointer refers to. Entries
+// holding their value are returned as they are.
+func (v *ValueLog) resolve(entry DbEntry) (DbEntry, error) {
+	if !entry.External() {
+		return entry, nil
+	}
+	if v == nil {
+		return entry, fmt.Errorf("%w: key %q points to a value log that is not open", ErrCorruptedValue, entry.Key())
+	}
+	ptr, err := decodeValuePointer(entry.Value())
+	if err != nil {
+		return entry, err
+	}
+	_, key, value, err := v.read(ptr)
+	if err != nil {
+		return entry, err
+	}
+	if key != entry.Key() {
+		return entry, fmt.Errorf("%w: record at %d:%d holds key %q, expected %q", ErrCorruptedValue, ptr.File, ptr.Offset, key, entry.Key())
+	}
+	entry.SetValue(value, false)
+	return entry, nil
+}
+
+// read returns the namespace, key and value of a record, the namespace empty
+// for the default one.
+func (v *ValueLog) read(ptr ValuePointer) (string, string, string, error) {
+	v.mu.RLock()
+	fd, ok := v.files[ptr.File]
+	v.mu.RUnlock()
+	if !ok {
+		return "", "", "", fmt.Errorf("%w: value log file %d not found", ErrCorruptedValue, ptr.File)
+	}
+	buf := make([]byte, ptr.Length)
+	if _, err := fd.ReadAt(buf, int64(ptr.Offset)); err != nil {
+		return "", "", "", fmt.Errorf("reading value log file %d at %d: %w", ptr.File, ptr.Offset, err)
+	}
+	return decodeValueRecord(buf, ptr)
+}
+
+func decodeValueRecord(buf []byte, ptr ValuePointer) (string, string, string, error) {
+	if len(buf) < vlogRecordHeaderSize {
+		return "", "", "", fmt.Errorf("%w: record at %d:%d is too short", ErrCorruptedValue, ptr.File, ptr.Offset)
+	}
+	keyLength := binary.LittleEndian.Uint32(buf[4:])
+	namespaced := keyLength&vlogNamespacedKey != 0
+	keyLength &^= vlogNamespacedKey
+	valueLength := int(binary.LittleEndian.Uint32(buf[8:]))
+	if vlogRecordHeaderSize+int(keyLength)+valueLength != len(buf) {
+		return "", "", "", fmt.Errorf("%w: record at %d:%d has the wrong length", ErrCorruptedValue, ptr.File, ptr.Offset)
+	}
+	if crc32.Checksum(buf[4:], castagnoli) != binary.LittleEndian.Uint32(buf[0:]) {
+		return "", "", "", fmt.Errorf("%w: checksum mismatch at %d:%d", ErrCorruptedValue, ptr.File, ptr.Offset)
+	}
+	d := decoder{buf: buf[vlogRecordHeaderSize : vlogRecordHeaderSize+int(keyLength)]}
+	namespace := ""
+	if namespaced {
+		namespace = d.string()
+	}
+	key := d.next(len(d.buf) - d.pos)
+	if d.err != nil {
+		return "", "", "", fmt.Errorf("%w: record at %d:%d has a malformed key", ErrCorruptedValue, ptr.File, ptr.Offset)
+	}
+	return namespace, string(key), string(buf[vlogRecordHeaderSize+int(keyLength):]), nil
+}
+
+// records calls fn with every record of a file, in the order they were
+// written.
+func (v *ValueLog) records(number uint64, fn func(ptr ValuePointer, namespace, key, value string) error) error {
+	v.mu.RLock()
+	fd, ok := v.files[number]
+	v.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("value log file %d not found", number)
+	}
+	_, err := readValueLogRecords(fd, number, fn)
+	return err
+}
+
+// readValueLogRecords calls fn with every record of a file and returns the
+// offset where the readable records end. A torn or corrupted record ends the
+// file, as records after it cannot be located reliably.
+func readValueLogRecords(fd *os.File, number uint64, fn func(ptr ValuePointer, namespace, key, value string) error) (int64, error) {
+	info, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, vlogRecordHeaderSize)
+	offset := int64(0)
+	for offset+vlogRecordHeaderSize <= info.Size() {
+		if _, err := fd.ReadAt(header, offset); err != nil {
+			return offset, err
+		}
+		keyLength := binary.LittleEndian.Uint32(header[4:]) &^ vlogNamespacedKey
+		length := vlogRecordHeaderSize + int64(keyLength) + int64(binary.LittleEndian.Uint32(header[8:]))
+		if offset+length > info.Size() {
+			break
+		}
+		buf := make([]byte, length)
+		if _, err := fd.ReadAt(buf, offset); err != nil {
+			return offset, err
+		}
+		ptr := ValuePointer{File: number, Offset: uint64(offset), Length: uint32(length)}
+		namespace, key, value, err := decodeValueRecord(buf, ptr)
+		if err != nil {
+			break
+		}
+		if err := fn(ptr, namespace, key, value); err != nil {
+			return offset, err
+		}
+		offset += length
+	}
+	return offset, nil
+}
+
+// sealed returns the files no longer written to, oldest first.
+func (v *ValueLog) sealed() []uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	var numbers []uint64
+	for number := range v.files {
+		if v.active == nil || number != v.activeNumber {
+			numbers = append(numbers, number)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers
+}
+
+// sync makes every value appended so far durable.
+func (v *ValueLog) sync() error {
+	if v == nil {
+		return nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.active == nil {
+		return nil
+	}
+	return v.active.Sync()
+}
+
+// remove deletes a sealed file. Nothing may point into it anymore.
+func (v *ValueLog) remove(number uint64) error {
+	v.mu.Lock()
+	fd, ok := v.files[number]
+	delete(v.files, number)
+	v.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	fd.Close()
+	return os.Remove(vlogPath(v.dir, number))
+}
+
+func (v *ValueLog) Close() error {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	err := v.seal()
+	for _, fd := range v.files {
+		fd.Close()
+	}
+	return err
+}
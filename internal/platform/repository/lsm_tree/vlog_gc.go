@@ -0,0 +1,179 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"math"
+	"time"
+)
+
+const defaultDiscardRatio = 0.5
+
+func (t *LsmTree) startValueLogGC() {
+	if t.valueLogGC <= 0 {
+		return
+	}
+	t.gcStop = make(chan struct{})
+	t.gcWg.Add(1)
+	go func() {
+		defer t.gcWg.Done()
+		ticker := time.NewTicker(t.valueLogGC)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.gcStop:
+				return
+			case <-ticker.C:
+				if n, err := t.CollectValueLog(); err != nil {
+					t.logger.Printf("value log collection failed: %v", err)
+				} else if n > 0 {
+					t.logger.Printf("Value log collection reclaimed %d files", n)
+				}
+			}
+		}
+	}()
+}
+
+func (t *LsmTree) stopValueLogGC() {
+	if t.gcStop != nil {
+		close(t.gcStop)
+		t.gcWg.Wait()
+		t.gcStop = nil
+	}
+}
+
+// CollectValueLog reclaims the value log files no longer written to whose
+// garbage reaches the discard ratio: the values still in use are written
+// again, through the tree, and the file is deleted. It returns how many
+// files were deleted.
+func (t *LsmTree) CollectValueLog() (int, error) {
+	t.gcMu.Lock()
+	defer t.gcMu.Unlock()
+
+	collected := 0
+	for _, number := range t.values.sealed() {
+		deleted, err := t.collectValueLogFile(number)
+		if err != nil {
+			return collected, err
+		}
+		if deleted {
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+type valueRecord struct {
+	ptr       ValuePointer
+	namespace string
+	key       string
+}
+
+func (t *LsmTree) collectValueLogFile(number uint64) (bool, error) {
+	var records []valueRecord
+	total, live := 0, 0
+	pinned := false
+	snapshots := t.snapshots.sequences()
+	err := t.values.records(number, func(ptr ValuePointer, namespace, key, _ string) error {
+		size := int(ptr.Length)
+		record := valueRecord{ptr: ptr, namespace: namespace, key: key}
+		if namespace == "" {
+			record.namespace = DefaultNamespace
+		}
+		records = append(records, record)
+		total += size
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		if t.pointsTo(record, math.MaxUint64) {
+			live += size
+		}
+		if t.pointedBySnapshot(record, snapshots) {
+			pinned = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	ratio := t.valueLogOptions.DiscardRatio
+	if ratio <= 0 {
+		ratio = defaultDiscardRatio
+	}
+	// Relocating moves the newest version only, so a file a snapshot still
+	// reads from waits for it to be released.
+	if pinned || (total > 0 && float64(total-live) < ratio*float64(total)) {
+		return false, nil
+	}
+
+	var written []*Memtable
+	for _, record := range records {
+		mem, err := t.relocate(record)
+		if err != nil {
+			return false, err
+		}
+		if mem != nil && (len(written) == 0 || written[len(written)-1] != mem) {
+			written = append(written, mem)
+		}
+	}
+	// The file goes away for good, the values written again must not
+	for _, mem := range written {
+		if err := mem.sync(); err != nil {
+			return false, err
+		}
+	}
+
+	// Readers resolve values under the read lock and scans register in the
+	// snapshot registry, so once no version anyone can read points into the
+	// file it is safe to delete.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshots = append(t.snapshots.sequences(), math.MaxUint64)
+	for _, record := range records {
+		if t.pointedBySnapshot(record, snapshots) {
+			return false, nil
+		}
+	}
+	return true, t.values.remove(number)
+}
+
+// relocate writes the value of a record again if the newest version of its
+// key still points to it, and returns the memtable it was written to. The
+// check runs under the memtable's write lock, so a concurrent write to the
+// key is never overwritten by the old value.
+func (t *LsmTree) relocate(record valueRecord) (*Memtable, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var readErr error
+	mem := t.active
+	written := mem.write(func() (DbEntry, bool) {
+		if !t.pointsTo(record, math.MaxUint64) {
+			return DbEntry{}, false
+		}
+		entry, _ := t.find(record.namespace, record.key, math.MaxUint64)
+		if entry, readErr = t.values.resolve(entry); readErr != nil {
+			return DbEntry{}, false
+		}
+		return entry, true
+	})
+	if readErr != nil || !written {
+		return nil, readErr
+	}
+	return mem, nil
+}
+
+// pointsTo tells whether the version of the record's key visible at seq
+// holds its value in the record. Expired values are no longer needed, nor
+// are those of dropped namespaces. Must be called with mu held.
+func (t *LsmTree) pointsTo(record valueRecord, seq uint64) bool {
+	entry, found := t.find(record.namespace, record.key, seq)
+	return found && entry.External() && entry.Value() == record.ptr.encode() && !entry.Expired(t.now())
+}
+
+func (t *LsmTree) pointedBySnapshot(record valueRecord, snapshots []uint64) bool {
+	for _, seq := range snapshots {
+		if t.pointsTo(record, seq) {
+			return true
+		}
+	}
+	return false
+}
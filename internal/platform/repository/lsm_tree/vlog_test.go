@@ -0,0 +1,186 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/config"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bigValue(i int) string {
+	return fmt.Sprintf("%04d-", i) + strings.Repeat("x", 500)
+}
+
+func valueLogFiles(t *testing.T, dir string) []uint64 {
+	numbers, err := listValueLogFiles(dir)
+	assert.NoError(t, err)
+	return numbers
+}
+
+func TestLsmTree_SeparatesLargeValues(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Config{WalDirectory: dir, ValueLogThreshold: 100}
+	tree, err := OpenLsmTree(conf)
+	assert.NoError(t, err)
+
+	tree.Set(NewDbEntry("grande", bigValue(1), false))
+	tree.Set(NewDbEntry("chico", "v", false))
+	tree.mu.Lock()
+	frozen := tree.freeze(tree.active)
+	tree.mu.Unlock()
+	assert.NoError(t, tree.flush(frozen))
+
+	tree.mu.RLock()
+	stored, _ := tree.find(DefaultNamespace, "grande", math.MaxUint64)
+	small, _ := tree.find(DefaultNamespace, "chico", math.MaxUint64)
+	tree.mu.RUnlock()
+	assert.True(t, stored.External(), "la tabla solo debe guardar el puntero")
+	assert.Len(t, stored.Value(), valuePointerSize)
+	assert.False(t, small.External(), "los valores chicos quedan en el árbol")
+
+	got, found := tree.Get("grande")
+	assert.True(t, found)
+	assert.Equal(t, bigValue(1), got.Value())
+	assert.False(t, got.External())
+
+	it := tree.Scan("", "", false)
+	var values []string
+	for entry, ok := it.Next(); ok; entry, ok = it.Next() {
+		values = append(values, entry.Value())
+	}
+	assert.NoError(t, it.Err())
+	assert.NoError(t, it.Close())
+	assert.Equal(t, []string{"v", bigValue(1)}, values)
+
+	// Sin volcar, el valor se vuelve a escribir al reproducir el WAL
+	tree.Set(NewDbEntry("en-wal", bigValue(2), false))
+	assert.NoError(t, tree.Close())
+
+	reopened := openTree(t, config.Config{WalDirectory: dir})
+	for key, want := range map[string]string{"grande": bigValue(1), "en-wal": bigValue(2)} {
+		got, found := reopened.Get(key)
+		assert.True(t, found, key)
+		assert.Equal(t, want, got.Value(), key)
+	}
+}
+
+func TestValueLog_CollectsOverwrittenValues(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, ValueLogThreshold: 100, ValueLogFileSize: 2048})
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%02d", i), bigValue(i), false))
+	}
+	for i := 0; i < 20; i += 2 {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%02d", i), bigValue(100+i), false))
+	}
+	tree.Set(NewDbEntry("key-19", "", true))
+	before := valueLogFiles(t, dir)
+
+	collected, err := tree.CollectValueLog()
+	assert.NoError(t, err)
+	assert.Greater(t, collected, 0, "los archivos con mayoría de basura deben liberarse")
+	assert.NotContains(t, valueLogFiles(t, dir), before[0], "el primer archivo solo tenía basura y valores reubicados")
+
+	check := func(tree *LsmTree) {
+		for i := 0; i < 19; i++ {
+			want := bigValue(i)
+			if i%2 == 0 {
+				want = bigValue(100 + i)
+			}
+			got, found := tree.Get(fmt.Sprintf("key-%02d", i))
+			assert.True(t, found)
+			assert.Equal(t, want, got.Value(), "los valores vivos deben sobrevivir a la recolección")
+		}
+		_, found := tree.Get("key-19")
+		assert.False(t, found)
+	}
+	check(tree)
+	assert.NoError(t, tree.Close())
+	check(openTree(t, config.Config{WalDirectory: dir}))
+}
+
+func TestValueLog_RelocationKeepsVersions(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLsmTree(config.Config{WalDirectory: dir, ValueLogThreshold: 100, ValueLogFileSize: 2048})
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		entry := NewDbEntry(fmt.Sprintf("key-%02d", i), bigValue(i), false)
+		entry.SetVersion(uint64(1000 + i))
+		tree.Set(entry)
+	}
+	for i := 0; i < 20; i += 2 {
+		tree.Set(NewDbEntry(fmt.Sprintf("key-%02d", i), bigValue(100+i), false))
+	}
+	before, _ := tree.Get("key-01")
+
+	collected, err := tree.CollectValueLog()
+	assert.NoError(t, err)
+	assert.Greater(t, collected, 0)
+	relocated, _ := tree.Get("key-01")
+	assert.Greater(t, relocated.Seq(), before.Seq(), "la reubicación vuelve a escribir la entrada")
+
+	check := func(tree *LsmTree, origen string) {
+		for i := 1; i < 20; i += 2 {
+			got, found := tree.Get(fmt.Sprintf("key-%02d", i))
+			assert.True(t, found)
+			assert.Equal(t, uint64(1000+i), got.Version(), "la versión replicada no cambia: %s", origen)
+		}
+	}
+	check(tree, "reubicada")
+	assert.NoError(t, tree.Close())
+
+	// Primero desde el WAL, después desde una SSTable
+	replayed := openTree(t, config.Config{WalDirectory: dir})
+	check(replayed, "WAL")
+	waitForFlush(t, replayed)
+	replayed.mu.Lock()
+	frozen := replayed.freeze(replayed.active)
+	replayed.mu.Unlock()
+	assert.NoError(t, replayed.flush(frozen))
+	check(replayed, "SSTable")
+}
+
+func TestValueLog_KeepsFilesReadBySnapshots(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTree(t, config.Config{WalDirectory: dir, ValueLogThreshold: 100, ValueLogFileSize: 512})
+	tree.Set(NewDbEntry("k", bigValue(1), false))
+	snapshot := tree.Snapshot()
+	tree.Set(NewDbEntry("k", bigValue(2), false))
+	tree.Set(NewDbEntry("otra", bigValue(3), false))
+
+	collected, err := tree.CollectValueLog()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, collected, "la instantánea todavía lee el valor viejo")
+	got, found := snapshot.Get("k")
+	assert.True(t, found)
+	assert.Equal(t, bigValue(1), got.Value())
+
+	snapshot.Release()
+	collected, err = tree.CollectValueLog()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, collected)
+	got, _ = tree.Get("k")
+	assert.Equal(t, bigValue(2), got.Value())
+}
+
+func TestValueLog_RejectsCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	var number uint64
+	values, err := openValueLog(dir, ValueLogOptions{Threshold: 1}, func() uint64 { number++; return number })
+	assert.NoError(t, err)
+	defer values.Close()
+
+	entry, err := values.separate(NewDbEntry("k", "valor", false))
+	assert.NoError(t, err)
+	ptr, err := decodeValuePointer(entry.Value())
+	assert.NoError(t, err)
+	ptr.Length--
+	entry.SetValue(ptr.encode(), true)
+	_, err = values.resolve(entry)
+	assert.ErrorIs(t, err, ErrCorruptedValue)
+}
@@ -2,38 +2,82 @@ package lsm_tree
 
 import (
 	. "KVDB/internal/domain"
-	"KVDB/internal/platform/utils"
+	"cmp"
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	walPrefix    = "wal-"
+	walExtension = ".log"
+)
+
 type WAL struct {
 	mu sync.Mutex
 	//logger  log.Logger
-	fd      *os.File
-	dir     string
-	path    string
-	version string
+	fd        *os.File
+	dir       string
+	path      string
+	version   string
+	opts      WalOptions
+	written   uint64 // bytes appended so far, guarded by mu
+	createdAt time.Time
+
+	syncMu   sync.Mutex
+	syncCond *sync.Cond
+	synced   uint64 // bytes known to be on stable storage
+	syncing  bool
+	syncErr  error
+	syncs    atomic.Int64
+	stop     chan struct{}
+	stopWg   sync.WaitGroup
 }
 
-func NewWal(dir string) (*WAL, error) {
-	createdAt := time.Now()
-	version := fmt.Sprintf("%s-%d", createdAt.Format("20060102150405"), createdAt.Nanosecond())
-	name := path.Join(dir, fmt.Sprintf("wal-%s.log", version))
+// NewWal creates the segment with the given number in dir. Writes are made
+// durable following opts.SyncMode.
+func NewWal(dir string, number uint64, opts WalOptions) (*WAL, error) {
+	opts, err := validateWalOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	version := formatVersion(number)
+	name := path.Join(dir, walPrefix+version+walExtension)
 
-	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0755)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0755)
 	if err != nil {
 		return nil, err
 	}
-	return &WAL{
-		fd:      file,
+	header := encodeWalHeader()
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w := newWal(file, dir, name, version, opts)
+	w.written = uint64(len(header))
+	w.createdAt = time.Now()
+	if opts.SyncMode == SyncPeriodic {
+		w.startPeriodicSync()
+	}
+	return w, nil
+}
+
+func newWal(fd *os.File, dir, path, version string, opts WalOptions) *WAL {
+	w := &WAL{
+		fd:      fd,
 		dir:     dir,
-		path:    name,
+		path:    path,
 		version: version,
-	}, nil
+		opts:    opts,
+	}
+	w.syncCond = sync.NewCond(&w.syncMu)
+	return w
 }
 
 func FromFile(fileName string) (*WAL, error) {
@@ -45,40 +89,75 @@ func FromFile(fileName string) (*WAL, error) {
 	if err != nil {
 		return nil, err
 	}
-	createdAt := time.Now()
-	version := fmt.Sprintf("%s-%d", createdAt.Format("20060102150405"), createdAt.Nanosecond())
-	return &WAL{
-		fd:      fd,
-		dir:     path.Dir(fileName),
-		path:    fileName,
-		version: version,
-	}, nil
+	version, ok := walVersionFromName(path.Base(fileName))
+	if !ok {
+		version = path.Base(fileName)
+	}
+	return newWal(fd, path.Dir(fileName), fileName, version, WalOptions{}), nil
 }
 
+// Write appends the entries and returns once they are as durable as the
+// sync mode promises.
 func (w *WAL) Write(entries ...DbEntry) error {
+	seq, err := w.append(entries...)
+	if err != nil {
+		return err
+	}
+	return w.waitDurable(seq)
+}
+
+// append writes the entries to the file without waiting for them to reach
+// stable storage. It returns the offset right after them, to be passed to
+// waitDurable.
+func (w *WAL) append(entries ...DbEntry) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	var buf []byte
 	for _, entry := range entries {
-		err := utils.AppendDbEntry(w.fd, entry)
-		if err != nil {
-			return err
-		}
+		buf = append(buf, encodeWalRecord(entry)...)
 	}
-	return nil
+	n, err := w.fd.Write(buf)
+	w.written += uint64(n)
+	return w.written, err
 }
 
+// Read returns the entries stored in the segment up to the first record that
+// is torn or corrupted. In that case the entries read so far are returned
+// together with a *WalCorruptionError holding the offset of the bad record.
 func (w *WAL) Read() ([]DbEntry, error) {
-	res, err := utils.ReadAllEntries(w.fd)
-	if err != nil {
-		return nil, err
-	}
-	return res, nil
+	return readWalRecords(w.fd)
 }
 
+// Close syncs whatever is still pending and closes the segment.
 func (w *WAL) Close() error {
+	w.stopPeriodicSync()
+
+	w.syncMu.Lock()
+	for w.syncing {
+		w.syncCond.Wait()
+	}
+	w.syncing = true
+	w.syncMu.Unlock()
+
 	w.mu.Lock()
-	defer w.mu.Unlock()
-	return w.close()
+	written := w.written
+	var err error
+	if w.fd != nil {
+		err = w.fd.Sync()
+	}
+	if closeErr := w.close(); err == nil {
+		err = closeErr
+	}
+	w.mu.Unlock()
+
+	w.syncMu.Lock()
+	w.syncing = false
+	if err == nil {
+		w.synced = written
+	}
+	w.syncCond.Broadcast()
+	w.syncMu.Unlock()
+	return err
 }
 
 func (w *WAL) close() error {
@@ -97,3 +176,107 @@ func (w *WAL) Version() string {
 	defer w.mu.Unlock()
 	return w.version
 }
+
+func (w *WAL) Path() string {
+	return w.path
+}
+
+// shouldRotate tells whether the segment outgrew the size or age limits set
+// in its options.
+func (w *WAL) shouldRotate() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.MaxSegmentSize > 0 && int64(w.written) >= w.opts.MaxSegmentSize {
+		return true
+	}
+	return w.opts.MaxSegmentAge > 0 && time.Since(w.createdAt) >= w.opts.MaxSegmentAge
+}
+
+// formatVersion names WAL segments and SSTables after a file number, padded
+// so that names sort like the numbers.
+func formatVersion(number uint64) string {
+	return fmt.Sprintf("%010d", number)
+}
+
+// versionNumber parses a numbered version. Versions written before segments
+// were numbered are timestamps and are reported as not numbered.
+func versionNumber(version string) (uint64, bool) {
+	if strings.Contains(version, "-") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(version, 10, 64)
+	return n, err == nil
+}
+
+func walVersionFromName(name string) (string, bool) {
+	if !strings.HasPrefix(name, walPrefix) || !strings.HasSuffix(name, walExtension) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(name, walPrefix), walExtension), true
+}
+
+// compareWalVersions orders versions by creation. Numbered versions follow
+// every timestamp version, which is what files were named after before.
+// Between timestamps the nanosecond part is compared numerically because the
+// oldest segments were written without padding.
+func compareWalVersions(a, b string) int {
+	an, aNumbered := versionNumber(a)
+	bn, bNumbered := versionNumber(b)
+	switch {
+	case aNumbered && bNumbered:
+		return cmp.Compare(an, bn)
+	case aNumbered:
+		return 1
+	case bNumbered:
+		return -1
+	}
+
+	aTime, aNanos, _ := strings.Cut(a, "-")
+	bTime, bNanos, _ := strings.Cut(b, "-")
+	if c := strings.Compare(aTime, bTime); c != 0 {
+		return c
+	}
+	aN, _ := strconv.Atoi(aNanos)
+	bN, _ := strconv.Atoi(bNanos)
+	return aN - bN
+}
+
+// ListWalSegments returns the paths of the WAL segments stored in dir,
+// oldest first.
+func ListWalSegments(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if version, ok := walVersionFromName(f.Name()); ok {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareWalVersions(versions[i], versions[j]) < 0
+	})
+
+	segments := make([]string, len(versions))
+	for i, version := range versions {
+		segments[i] = path.Join(dir, walPrefix+version+walExtension)
+	}
+	return segments, nil
+}
+
+// retireSegment disposes of a segment whose entries are durable in an
+// SSTable, moving it to archiveDir when one is set.
+func retireSegment(segment, archiveDir string) error {
+	if archiveDir == "" {
+		return os.Remove(segment)
+	}
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(segment, path.Join(archiveDir, path.Base(segment)))
+}
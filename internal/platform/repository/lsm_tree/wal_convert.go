@@ -0,0 +1,70 @@
+package lsm_tree
+
+import (
+	"KVDB/internal/platform/utils"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// IsTextWal tells whether the segment was written in the old line based
+// format, before segments carried a file header.
+func IsTextWal(segment string) (bool, error) {
+	fd, err := os.Open(segment)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	// Only the magic number is compared, a binary segment may have been torn
+	// in the middle of its header.
+	magic := encodeWalHeader()[:4]
+	header := make([]byte, len(magic))
+	n, err := io.ReadFull(fd, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	return n > 0 && !bytes.Equal(header[:n], magic[:n]), nil
+}
+
+// ConvertTextWal rewrites a text segment in the binary record format, keeping
+// its name so the replay order does not change. A torn final line is dropped,
+// like the text reader always did. It returns the number of entries kept.
+func ConvertTextWal(segment string) (int, error) {
+	src, err := os.Open(segment)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := utils.ReadValidEntries(src)
+	src.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := encodeWalHeader()
+	for _, entry := range entries {
+		buf = append(buf, encodeWalRecord(entry)...)
+	}
+
+	tmp := segment + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := dst.Write(buf); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(entries), os.Rename(tmp, segment)
+}
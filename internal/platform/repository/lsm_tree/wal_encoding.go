@@ -0,0 +1,169 @@
+package lsm_tree
+
+import (
+	. "KVDB/internal/domain"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A WAL segment is laid out as [FileHeader][Record...]. Every record is
+// [CRC32C][Length][Payload], where the checksum covers the length and the
+// payload. The payload is [Namespace][Entry], the entry encoded like an
+// SSTable one and the namespace empty for the default one.
+const (
+	walMagicNumber   = 0x4b56574c // "KVWL"
+	WalFormatVersion = 4
+
+	// walUnstampedVersion records carry no sequence number
+	walUnstampedVersion = 1
+	// walDefaultNamespaceVersion records belong to the default namespace
+	walDefaultNamespaceVersion = 2
+	// walUnversionedVersion entries carry no replicated version, and are
+	// read like the current ones
+	walUnversionedVersion = 3
+
+	walHeaderSize       = 8
+	walRecordHeaderSize = 8
+)
+
+var (
+	ErrCorruptedWal = errors.New("corrupted wal record")
+	ErrUnknownWal   = errors.New("unknown wal format")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WalCorruptionError tells where the first unreadable record of a segment
+// starts. Every record before Offset was read successfully.
+type WalCorruptionError struct {
+	Offset int64
+	Reason string
+	// Tail tells the bad record is the last thing in the file, as a write
+	// cut short by a crash leaves it
+	Tail bool
+}
+
+func (e *WalCorruptionError) Error() string {
+	return fmt.Sprintf("%v at offset %d: %s", ErrCorruptedWal, e.Offset, e.Reason)
+}
+
+func (e *WalCorruptionError) Unwrap() error {
+	return ErrCorruptedWal
+}
+
+func encodeWalHeader() []byte {
+	buf := make([]byte, walHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:], walMagicNumber)
+	binary.LittleEndian.PutUint32(buf[4:], WalFormatVersion)
+	return buf
+}
+
+func decodeWalHeader(buf []byte) (uint32, error) {
+	if len(buf) != walHeaderSize || binary.LittleEndian.Uint32(buf[0:]) != walMagicNumber {
+		return 0, ErrUnknownWal
+	}
+	version := binary.LittleEndian.Uint32(buf[4:])
+	if version < walUnstampedVersion || version > WalFormatVersion {
+		return 0, fmt.Errorf("%w: version %d", ErrUnknownWal, version)
+	}
+	return version, nil
+}
+
+func encodeWalRecord(entry DbEntry) []byte {
+	namespace := storedNamespace(entry.Namespace())
+	payloadSize := 4 + len(namespace) + encodedEntrySize(entry)
+	buf := make([]byte, walRecordHeaderSize, walRecordHeaderSize+payloadSize)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(payloadSize))
+	buf = appendString(buf, namespace)
+	buf = appendEntry(buf, entry)
+	binary.LittleEndian.PutUint32(buf[0:], crc32.Checksum(buf[4:], castagnoli))
+	return buf
+}
+
+// readWalRecords decodes the records that follow the file header. It stops
+// at the first record that is torn or fails its checksum and returns the
+// entries read so far together with a *WalCorruptionError. Entries of
+// segments written before records carried a sequence number come back with
+// sequence number zero, and those written before namespaces existed in the
+// default one.
+func readWalRecords(r io.Reader) ([]DbEntry, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, walHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if n == 0 && errors.Is(err, io.EOF) {
+			// Created but never written to
+			return nil, nil
+		}
+		return nil, &WalCorruptionError{Offset: 0, Reason: "torn file header", Tail: true}
+	}
+	version, err := decodeWalHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DbEntry
+	offset := int64(walHeaderSize)
+	recordHeader := make([]byte, walRecordHeaderSize)
+	for {
+		n, err := io.ReadFull(reader, recordHeader)
+		if n == 0 && errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, &WalCorruptionError{Offset: offset, Reason: "torn record header", Tail: true}
+		}
+		checksum := binary.LittleEndian.Uint32(recordHeader[0:])
+		length := binary.LittleEndian.Uint32(recordHeader[4:])
+
+		payload, err := readPayload(reader, int(length))
+		if err != nil {
+			return entries, &WalCorruptionError{Offset: offset, Reason: "torn record payload", Tail: true}
+		}
+		crc := crc32.Update(crc32.Checksum(recordHeader[4:], castagnoli), castagnoli, payload)
+		if crc != checksum {
+			return entries, &WalCorruptionError{Offset: offset, Reason: "checksum mismatch", Tail: atEOF(reader)}
+		}
+		d := decoder{buf: payload}
+		var entry DbEntry
+		switch version {
+		case walUnstampedVersion:
+			entry = d.unstampedEntry()
+		case walDefaultNamespaceVersion:
+			entry = d.entry()
+		default:
+			namespace := d.string()
+			entry = d.entry()
+			entry.SetNamespace(namespace)
+		}
+		if d.err != nil || d.pos != len(payload) {
+			return entries, &WalCorruptionError{Offset: offset, Reason: "malformed payload", Tail: atEOF(reader)}
+		}
+		entries = append(entries, entry)
+		offset += int64(walRecordHeaderSize) + int64(length)
+	}
+}
+
+func atEOF(reader *bufio.Reader) bool {
+	_, err := reader.Peek(1)
+	return errors.Is(err, io.EOF)
+}
+
+// readPayload reads in bounded chunks so a corrupted length cannot force a
+// huge allocation before the checksum gets a chance to reject the record.
+func readPayload(r io.Reader, length int) ([]byte, error) {
+	const chunk = 64 * 1024
+	payload := make([]byte, 0, min(length, chunk))
+	for len(payload) < length {
+		next := min(length-len(payload), chunk)
+		start := len(payload)
+		payload = append(payload, make([]byte, next)...)
+		if _, err := io.ReadFull(r, payload[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,148 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// SyncAlways fsyncs before acknowledging every write. Writers arriving
+	// while an fsync is in flight are covered by the next one.
+	SyncAlways = "always"
+	// SyncGroup lets concurrent writers share one fsync. The writer leading
+	// a batch waits GroupCommitWindow for others to join before syncing.
+	SyncGroup = "group"
+	// SyncPeriodic acknowledges writes once they reach the OS and fsyncs in
+	// the background every SyncInterval. A power failure can lose up to one
+	// interval of acknowledged writes.
+	SyncPeriodic = "periodic"
+
+	defaultSyncInterval = 100 * time.Millisecond
+)
+
+type WalOptions struct {
+	SyncMode          string
+	SyncInterval      time.Duration
+	GroupCommitWindow time.Duration
+
+	// A segment is rotated once it reaches MaxSegmentSize bytes or is older
+	// than MaxSegmentAge. Zero disables the limit.
+	MaxSegmentSize int64
+	MaxSegmentAge  time.Duration
+	// ArchiveDir receives retired segments instead of deleting them. It must
+	// be on the same filesystem as the WAL directory.
+	ArchiveDir string
+}
+
+func validateWalOptions(opts WalOptions) (WalOptions, error) {
+	switch opts.SyncMode {
+	case "":
+		opts.SyncMode = SyncAlways
+	case SyncAlways, SyncGroup, SyncPeriodic:
+	default:
+		return opts, fmt.Errorf("unknown wal sync mode %q", opts.SyncMode)
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	return opts, nil
+}
+
+// waitDurable blocks until the bytes up to seq are as durable as the sync
+// mode promises.
+func (w *WAL) waitDurable(seq uint64) error {
+	switch w.opts.SyncMode {
+	case SyncPeriodic:
+		w.syncMu.Lock()
+		defer w.syncMu.Unlock()
+		return w.syncErr
+	case SyncGroup:
+		return w.sync(seq, w.opts.GroupCommitWindow)
+	default:
+		return w.sync(seq, 0)
+	}
+}
+
+// Sync makes everything appended so far durable, whatever the sync mode.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	written := w.written
+	w.mu.Unlock()
+	return w.sync(written, 0)
+}
+
+// sync returns once every byte up to seq is on stable storage. Only one
+// fsync is in flight at a time: writers arriving meanwhile wait for it and
+// the next one covers all of them. A failed fsync is sticky, since the
+// kernel may already have dropped the dirty pages it could not write.
+func (w *WAL) sync(seq uint64, window time.Duration) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	for w.synced < seq && w.syncErr == nil {
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+		w.syncing = true
+		w.syncMu.Unlock()
+		if window > 0 {
+			time.Sleep(window)
+		}
+		upto, err := w.fsync()
+		w.syncMu.Lock()
+
+		w.syncing = false
+		if err != nil {
+			w.syncErr = err
+		} else {
+			w.synced = max(w.synced, upto)
+		}
+		w.syncCond.Broadcast()
+	}
+	if w.synced >= seq {
+		return nil
+	}
+	return w.syncErr
+}
+
+// fsync flushes the file and returns how many bytes it covers. The file lock
+// is not held while syncing so writers keep appending the next batch. Callers
+// must own the syncing flag, which also keeps Close from racing with it.
+func (w *WAL) fsync() (uint64, error) {
+	w.mu.Lock()
+	fd, written := w.fd, w.written
+	w.mu.Unlock()
+	if fd == nil {
+		// Close already synced everything
+		return written, nil
+	}
+	w.syncs.Add(1)
+	return written, fd.Sync()
+}
+
+func (w *WAL) startPeriodicSync() {
+	w.stop = make(chan struct{})
+	w.stopWg.Add(1)
+	go func() {
+		defer w.stopWg.Done()
+		ticker := time.NewTicker(w.opts.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.Sync()
+			}
+		}
+	}()
+}
+
+func (w *WAL) stopPeriodicSync() {
+	if w.stop != nil {
+		close(w.stop)
+		w.stopWg.Wait()
+		w.stop = nil
+	}
+}
@@ -2,8 +2,13 @@ package lsm_tree
 
 import (
 	. "KVDB/internal/domain"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // helper para crear un WAL temporal
@@ -16,7 +21,7 @@ func createTempWal(t *testing.T) *WAL {
 		os.RemoveAll(tmpDir)
 	})
 
-	wal, err := NewWal(tmpDir)
+	wal, err := NewWal(tmpDir, 1, WalOptions{})
 	if err != nil {
 		t.Fatalf("error creando WAL: %v", err)
 	}
@@ -90,3 +95,164 @@ func TestWAL_Read(t *testing.T) {
 		}
 	}
 }
+
+// helper para releer el segmento desde el principio
+func reopen(t *testing.T, wal *WAL) []DbEntry {
+	wal.fd.Close()
+	fd, err := os.Open(wal.path)
+	if err != nil {
+		t.Fatalf("error reabriendo archivo WAL: %v", err)
+	}
+	wal.fd = fd
+
+	entries, err := wal.Read()
+	if err != nil {
+		t.Fatalf("fallo al leer WAL: %v", err)
+	}
+	return entries
+}
+
+func TestWAL_ReadBinarySafeValues(t *testing.T) {
+	wal := createTempWal(t)
+	entries := []DbEntry{
+		NewDbEntry("multi\nline", "a,b\nc\n", false),
+		NewDbEntry("big", strings.Repeat("x", 100*1024), false),
+	}
+	if err := wal.Write(entries...); err != nil {
+		t.Fatalf("fallo al escribir en WAL: %v", err)
+	}
+
+	readEntries := reopen(t, wal)
+	if len(readEntries) != len(entries) {
+		t.Fatalf("esperado %d entradas, obtenido %d", len(entries), len(readEntries))
+	}
+	for i := range entries {
+		if readEntries[i] != entries[i] {
+			t.Errorf("entrada %d no coincide", i)
+		}
+	}
+}
+
+func TestWAL_ReadKeepsSequenceNumbers(t *testing.T) {
+	wal := createTempWal(t)
+	entry := NewDbEntry("k", "v", false)
+	entry.SetSeq(42)
+	expiring := NewDbEntry("session", "v", false)
+	expiring.SetSeq(43)
+	expiring.SetExpiresAt(1_700_000_000_000_000_000)
+	if err := wal.Write(entry, expiring); err != nil {
+		t.Fatalf("fallo al escribir en WAL: %v", err)
+	}
+
+	readEntries := reopen(t, wal)
+	if len(readEntries) != 2 || readEntries[0] != entry || readEntries[1] != expiring {
+		t.Fatalf("se esperaban la secuencia y la expiración originales, obtenido %v", readEntries)
+	}
+}
+
+func TestWAL_ReadStopsAtCorruptedRecord(t *testing.T) {
+	wal := createTempWal(t)
+	wal.Write(NewDbEntry("k1", "v1", false))
+	wal.Write(NewDbEntry("k2", "v2", false))
+	wal.Write(NewDbEntry("k3", "v3", false))
+	wal.fd.Close()
+
+	// se corrompe un byte del valor del segundo registro
+	data, _ := os.ReadFile(wal.path)
+	second := int64(walHeaderSize + len(encodeWalRecord(NewDbEntry("k1", "v1", false))))
+	data[second+walRecordHeaderSize+6] ^= 0xff
+	os.WriteFile(wal.path, data, 0755)
+
+	fd, _ := os.Open(wal.path)
+	wal.fd = fd
+	entries, err := wal.Read()
+
+	var corruption *WalCorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("esperado WalCorruptionError, obtenido %v", err)
+	}
+	if corruption.Offset != second {
+		t.Errorf("offset esperado %d, obtenido %d", second, corruption.Offset)
+	}
+	if len(entries) != 1 || entries[0].Key() != "k1" {
+		t.Errorf("solo debe leerse el primer registro, obtenido %+v", entries)
+	}
+}
+
+func TestWAL_RejectsUnknownFormat(t *testing.T) {
+	wal := createTempWal(t)
+	wal.fd.Close()
+	os.WriteFile(wal.path, []byte("2,k1,2,v1,0\n"), 0755)
+
+	fd, _ := os.Open(wal.path)
+	wal.fd = fd
+	if _, err := wal.Read(); !errors.Is(err, ErrUnknownWal) {
+		t.Errorf("esperado ErrUnknownWal, obtenido %v", err)
+	}
+}
+
+func TestWAL_SyncModes(t *testing.T) {
+	for _, mode := range []string{SyncAlways, SyncGroup, SyncPeriodic} {
+		t.Run(mode, func(t *testing.T) {
+			wal, err := NewWal(t.TempDir(), 1, WalOptions{
+				SyncMode:          mode,
+				SyncInterval:      5 * time.Millisecond,
+				GroupCommitWindow: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("error creando WAL: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 25; j++ {
+						if err := wal.Write(NewDbEntry(fmt.Sprintf("k-%d-%d", i, j), "v", false)); err != nil {
+							t.Errorf("fallo al escribir en WAL: %v", err)
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			if err := wal.Close(); err != nil {
+				t.Fatalf("fallo al cerrar WAL: %v", err)
+			}
+			if wal.synced != wal.written {
+				t.Errorf("quedaron %d bytes sin sincronizar", wal.written-wal.synced)
+			}
+			if entries := reopen(t, wal); len(entries) != 200 {
+				t.Errorf("esperado 200 entradas, obtenido %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestWAL_GroupCommitSharesFsync(t *testing.T) {
+	wal, err := NewWal(t.TempDir(), 1, WalOptions{SyncMode: SyncGroup, GroupCommitWindow: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("error creando WAL: %v", err)
+	}
+	defer wal.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wal.Write(NewDbEntry(fmt.Sprintf("k-%d", i), "v", false))
+		}(i)
+	}
+	wg.Wait()
+
+	if syncs := wal.syncs.Load(); syncs >= 50 {
+		t.Errorf("las escrituras concurrentes deben compartir fsync, hubo %d", syncs)
+	}
+}
+
+func TestNewWal_RejectsUnknownSyncMode(t *testing.T) {
+	if _, err := NewWal(t.TempDir(), 1, WalOptions{SyncMode: "never"}); err == nil {
+		t.Error("se esperaba error para un modo desconocido")
+	}
+}
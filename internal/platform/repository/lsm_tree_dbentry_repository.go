@@ -3,29 +3,36 @@ package repository
 import (
 	"KVDB/internal/domain"
 	"KVDB/internal/platform/repository/lsm_tree"
+	"log"
 )
 
 type LSMTreeRepository struct {
-	mt *lsm_tree.Memtable
+	tree *lsm_tree.LsmTree
 }
 
-func NewLSMTreeRepository(mt *lsm_tree.Memtable) *LSMTreeRepository {
+func NewLSMTreeRepository(tree *lsm_tree.LsmTree) *LSMTreeRepository {
 	return &LSMTreeRepository{
-		mt: mt,
+		tree: tree,
 	}
 }
 
+// Save writes e to its namespace. Callers check the namespace exists first, so
+// a failure here means it was dropped in between and the write is lost.
 func (r *LSMTreeRepository) Save(e domain.DbEntry) domain.DbEntry {
-	r.mt.Set(e)
+	if err := r.tree.Set(e); err != nil {
+		log.Printf("Discarded write of %q: %v", e.Key(), err)
+	}
 	return e
 }
 
-func (r *LSMTreeRepository) Get(key string) (domain.DbEntry, bool) {
-	return r.mt.Get(key)
+// Get returns the entry with its value, read from the value log when the tree
+// only holds a pointer to it.
+func (r *LSMTreeRepository) Get(namespace string, key []byte) (domain.DbEntry, bool) {
+	return r.tree.GetIn(namespace, string(key))
 }
 
-func (r *LSMTreeRepository) Delete(key string) (*domain.DbEntry, bool) {
-	entry, found := r.mt.Get(key)
+func (r *LSMTreeRepository) Delete(namespace string, key []byte) (*domain.DbEntry, bool) {
+	entry, found := r.tree.GetIn(namespace, string(key))
 	if !found {
 		return nil, false
 	}
@@ -33,3 +40,36 @@ func (r *LSMTreeRepository) Delete(key string) (*domain.DbEntry, bool) {
 	r.Save(entry)
 	return &entry, true
 }
+
+func (r *LSMTreeRepository) Scan(namespace, start, end string, reverse bool) domain.DbEntryIterator {
+	return r.tree.ScanIn(namespace, start, end, reverse)
+}
+
+func (r *LSMTreeRepository) Prefix(namespace, prefix string, reverse bool) domain.DbEntryIterator {
+	return r.tree.PrefixIn(namespace, prefix, reverse)
+}
+
+// Snapshot pins the current state of the tree. The caller must release it.
+func (r *LSMTreeRepository) Snapshot() domain.DbEntrySnapshot {
+	return &lsmTreeSnapshot{snapshot: r.tree.Snapshot()}
+}
+
+type lsmTreeSnapshot struct {
+	snapshot *lsm_tree.Snapshot
+}
+
+func (s *lsmTreeSnapshot) Get(namespace, key string) (domain.DbEntry, bool) {
+	return s.snapshot.GetIn(namespace, key)
+}
+
+func (s *lsmTreeSnapshot) Scan(namespace, start, end string, reverse bool) domain.DbEntryIterator {
+	return s.snapshot.ScanIn(namespace, start, end, reverse)
+}
+
+func (s *lsmTreeSnapshot) Prefix(namespace, prefix string, reverse bool) domain.DbEntryIterator {
+	return s.snapshot.PrefixIn(namespace, prefix, reverse)
+}
+
+func (s *lsmTreeSnapshot) Release() {
+	s.snapshot.Release()
+}
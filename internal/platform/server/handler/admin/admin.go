@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"KVDB/internal/domain"
+	"KVDB/internal/platform/repository/lsm_tree"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	json "github.com/json-iterator/go"
+	"io"
+	"net/http"
+	"time"
+)
+
+type AdminHandler struct {
+	tree *lsm_tree.LsmTree
+}
+
+func NewAdminHandler(tree *lsm_tree.LsmTree) *AdminHandler {
+	return &AdminHandler{
+		tree: tree,
+	}
+}
+
+func (h *AdminHandler) GetCompactionStats(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.Marshal(h.tree.CompactionStats())
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+func (h *AdminHandler) GetBloomFilterStats(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.Marshal(h.tree.BloomFilterStats())
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+func (h *AdminHandler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.Marshal(h.tree.CacheStats())
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+type CheckpointRequest struct {
+	Target string `json:"target"`
+}
+
+// CreateCheckpoint flushes the tree and copies its files into the target
+// directory, e.g. POST /admin/checkpoint {"target": "/backups/node-1"}
+func (h *AdminHandler) CreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	var request CheckpointRequest
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil || request.Target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid request, target is required")
+		return
+	}
+	info, err := h.tree.Checkpoint(request.Target)
+	if errors.Is(err, lsm_tree.ErrDirectoryNotEmpty) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	output, _ := json.Marshal(info)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// NamespaceRequest carries the settings of a namespace, with its default TTL
+// in seconds. Settings left empty take the node defaults.
+type NamespaceRequest struct {
+	Name             string `json:"name"`
+	DefaultTTL       int64  `json:"default_ttl,omitempty"`
+	Compression      string `json:"compression,omitempty"`
+	ConflictResolver string `json:"conflict_resolver,omitempty"`
+}
+
+func mapToNamespaceResponse(namespace domain.Namespace) NamespaceRequest {
+	return NamespaceRequest{
+		Name:             namespace.Name,
+		DefaultTTL:       int64(namespace.DefaultTTL / time.Second),
+		Compression:      namespace.Compression,
+		ConflictResolver: namespace.ConflictResolver,
+	}
+}
+
+// CreateNamespace adds a namespace, e.g.
+// POST /admin/namespaces {"name": "sessions", "default_ttl": 3600}
+func (h *AdminHandler) CreateNamespace(w http.ResponseWriter, r *http.Request) {
+	var request NamespaceRequest
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid request")
+		return
+	}
+	namespace := domain.Namespace{
+		Name:             request.Name,
+		DefaultTTL:       time.Duration(request.DefaultTTL) * time.Second,
+		Compression:      request.Compression,
+		ConflictResolver: request.ConflictResolver,
+	}
+	if err := h.tree.CreateNamespace(namespace); err != nil {
+		writeNamespaceError(w, err)
+		return
+	}
+	output, _ := json.Marshal(mapToNamespaceResponse(namespace))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(output))
+}
+
+func (h *AdminHandler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces := h.tree.Namespaces()
+	response := make([]NamespaceRequest, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		response = append(response, mapToNamespaceResponse(namespace))
+	}
+	output, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// DropNamespace deletes a namespace and all of its entries, e.g.
+// DELETE /admin/namespaces/sessions
+func (h *AdminHandler) DropNamespace(w http.ResponseWriter, r *http.Request) {
+	if err := h.tree.DropNamespace(chi.URLParam(r, "name")); err != nil {
+		writeNamespaceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeNamespaceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidNamespace):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, domain.ErrNamespaceExists):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, domain.ErrNamespaceNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprint(w, err.Error())
+}
@@ -3,40 +3,61 @@ package dbentry
 import (
 	"KVDB/internal/application/service"
 	"KVDB/internal/domain"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	json "github.com/json-iterator/go"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const octetStream = "application/octet-stream"
+
 type DbEntryHandler struct {
 	saveService   *service.SaveEntryService
 	deleteService *service.DeleteEntryService
 	getService    *service.GetEntryService
+	scanService   *service.ScanEntriesService
 }
 
+// EntryResponse carries the key and value base64, so that any bytes survive
+// the JSON
 type EntryResponse struct {
-	Key       string `json:"key,omitempty"`
-	Value     string `json:"value,omitempty"`
-	Tombstone bool   `json:"tombstone"`
+	Namespace string     `json:"namespace,omitempty"`
+	Key       []byte     `json:"key,omitempty"`
+	Value     []byte     `json:"value,omitempty"`
+	Tombstone bool       `json:"tombstone"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Version is what conditional writes compare against, on any node
+	Version uint64 `json:"version,omitempty"`
 }
 
 func MapToEntryResponse(e domain.DbEntry) EntryResponse {
 	return EntryResponse{
-		Key:       e.Key(),
-		Value:     e.Value(),
+		Namespace: e.Namespace(),
+		Key:       e.KeyBytes(),
+		Value:     e.ValueBytes(),
 		Tombstone: e.Tombstone(),
+		ExpiresAt: e.Deadline(),
+		Version:   e.Version(),
 	}
 }
 
 func NewDbEntryHandler(saveService *service.SaveEntryService,
 	deleteService *service.DeleteEntryService,
-	getService *service.GetEntryService) *DbEntryHandler {
+	getService *service.GetEntryService,
+	scanService *service.ScanEntriesService) *DbEntryHandler {
 	return &DbEntryHandler{
 		saveService:   saveService,
 		deleteService: deleteService,
 		getService:    getService,
+		scanService:   scanService,
 	}
 }
 
@@ -47,33 +68,244 @@ func (h *DbEntryHandler) SaveEntry(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		fmt.Fprintf(w, err.Error())
 	}
-	result := h.saveService.Execute(service.SaveEntryCommand{
-		Key:   request.Key,
-		Value: request.Value,
-	})
+	command := service.SaveEntryCommand{
+		Namespace: namespace(r, request.Namespace),
+		Key:       []byte(request.Key),
+		Value:     []byte(request.Value),
+		TTL:       time.Duration(request.TTL) * time.Second,
+		Condition: request.Condition.ToCondition(),
+	}
+	if request.ExpiresAt != nil {
+		command.ExpiresAt = *request.ExpiresAt
+	}
+	result := h.saveService.Execute(command)
+	if result.Err != nil {
+		writeSaveError(w, result.Err)
+		return
+	}
 	output, _ := json.Marshal(MapToEntryResponse(result.Entry))
 	fmt.Fprintf(w, string(output))
 }
 
+// PutEntry writes the value of the key in the path. An
+// application/octet-stream body is stored as is, any other body is read as a
+// SaveEntryRequest whose key is ignored. The ttl query parameter, in seconds,
+// sets the expiry of raw values, e.g. PUT /api/db/session:42?ttl=60, and the
+// namespace one picks where the key is written.
+func (h *DbEntryHandler) PutEntry(w http.ResponseWriter, r *http.Request) {
+	key, err := KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	command := service.SaveEntryCommand{Namespace: namespace(r, ""), Key: key}
+	if isOctetStream(r.Header.Get("Content-Type")) {
+		command.Value = body
+		if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+			seconds, err := strconv.ParseInt(ttl, 10, 64)
+			if err != nil || seconds < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "Invalid ttl")
+				return
+			}
+			command.TTL = time.Duration(seconds) * time.Second
+		}
+	} else {
+		var request SaveEntryRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		command.Namespace = namespace(r, request.Namespace)
+		command.Value = []byte(request.Value)
+		command.TTL = time.Duration(request.TTL) * time.Second
+		command.Condition = request.Condition.ToCondition()
+		if request.ExpiresAt != nil {
+			command.ExpiresAt = *request.ExpiresAt
+		}
+	}
+	result := h.saveService.Execute(command)
+	if result.Err != nil {
+		writeSaveError(w, result.Err)
+		return
+	}
+	output, _ := json.Marshal(MapToEntryResponse(result.Entry))
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// GetEntry answers with the entry as JSON, or with the raw value when the
+// client accepts application/octet-stream; its expiry then goes in the
+// Expires-At header.
 func (h *DbEntryHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
+	key, err := KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
 	result := h.getService.Execute(service.GetEntryQuery{
-		Key: key,
+		Namespace: namespace(r, ""),
+		Key:       key,
 	})
 	if !result.Found {
 		w.WriteHeader(404)
 		fmt.Fprintf(w, "Not found")
 		return
 	}
+	if acceptsOctetStream(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", octetStream)
+		if deadline := result.Entry.Deadline(); deadline != nil {
+			w.Header().Set("Expires-At", deadline.Format(time.RFC3339Nano))
+		}
+		w.Write(result.Entry.ValueBytes())
+		return
+	}
 	output, _ := json.Marshal(MapToEntryResponse(result.Entry))
 	fmt.Fprintf(w, string(output))
 }
 
+// DeleteEntry deletes the key in the path. The if_version query parameter
+// only deletes it if its version still is the given one.
 func (h *DbEntryHandler) DeleteEntry(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
-	result := h.deleteService.Execute(service.DeleteEntryCommand{
-		Key: key,
-	})
+	key, err := KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
+	command := service.DeleteEntryCommand{
+		Namespace: namespace(r, ""),
+		Key:       key,
+	}
+	if version := r.URL.Query().Get("if_version"); version != "" {
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid if_version")
+			return
+		}
+		command.Condition = &domain.Condition{If: domain.IfVersionEquals, Version: v}
+	}
+	result := h.deleteService.Execute(command)
+	if command.Condition != nil && result.Err != nil {
+		writeSaveError(w, result.Err)
+		return
+	}
 	output, _ := json.Marshal(MapToEntryResponse(result.Entry))
 	fmt.Fprintf(w, string(output))
 }
+
+// ScanEntries lists entries in key order, e.g.
+// GET /api/db?namespace=users&prefix=user:&limit=50&cursor=...
+func (h *DbEntryHandler) ScanEntries(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := service.ScanEntriesQuery{
+		Namespace: namespace(r, ""),
+		Start:     params.Get("start"),
+		End:       params.Get("end"),
+		Prefix:    params.Get("prefix"),
+		Cursor:    params.Get("cursor"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid limit")
+			return
+		}
+		query.Limit = n
+	}
+	if reverse := params.Get("reverse"); reverse != "" {
+		b, err := strconv.ParseBool(reverse)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid reverse")
+			return
+		}
+		query.Reverse = b
+	}
+
+	result := h.scanService.Execute(query)
+	if result.Err != nil {
+		if errors.Is(result.Err, service.ErrInvalidCursor) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, result.Err.Error())
+		return
+	}
+
+	response := ScanEntriesResponse{
+		Entries: make([]EntryResponse, 0, len(result.Entries)),
+		Cursor:  result.Cursor,
+	}
+	for _, entry := range result.Entries {
+		response.Entries = append(response.Entries, MapToEntryResponse(entry))
+	}
+	output, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// namespace returns the namespace named in the request body, else the one in
+// the namespace query parameter. Empty means the default namespace.
+func namespace(r *http.Request, fromBody string) string {
+	if fromBody != "" {
+		return fromBody
+	}
+	return r.URL.Query().Get("namespace")
+}
+
+// writeSaveError answers 412 when the condition of a write did not hold
+// and 409 when conflict resolution aborted it
+func writeSaveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrNamespaceNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, domain.ErrInvalidCondition):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, domain.ErrConditionFailed):
+		w.WriteHeader(http.StatusPreconditionFailed)
+	case errors.Is(err, domain.ErrTransactionAborted), errors.Is(err, domain.ErrStaleRead):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprint(w, err.Error())
+}
+
+// KeyParam returns the key in the path. Keys holding bytes that must be
+// escaped in a URL arrive percent-encoded, and chi then matches the route on
+// the escaped path.
+func KeyParam(r *http.Request) ([]byte, error) {
+	key := chi.URLParam(r, "key")
+	if r.URL.RawPath == "" {
+		return []byte(key), nil
+	}
+	unescaped, err := url.PathUnescape(key)
+	return []byte(unescaped), err
+}
+
+func isOctetStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == octetStream
+}
+
+func acceptsOctetStream(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		if isOctetStream(strings.TrimSpace(part)) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,40 @@
 package dbentry
 
+import (
+	"KVDB/internal/domain"
+	"time"
+)
+
+// SaveEntryRequest may set when the entry expires, either as a deadline or
+// as a TTL in seconds. ExpiresAt wins when both are given. An empty
+// Namespace falls back to the namespace query parameter, then to the default
+// namespace. Condition makes the write conditional.
 type SaveEntryRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Namespace string            `json:"namespace,omitempty"`
+	Key       string            `json:"key"`
+	Value     string            `json:"value"`
+	TTL       int64             `json:"ttl,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	Condition *ConditionRequest `json:"condition,omitempty"`
+}
+
+// ConditionRequest is one of {"if": "absent"}, {"if": "value-equals",
+// "value": "..."} or {"if": "version-equals", "version": 42}, versions being
+// the ones this node returns with its entries.
+type ConditionRequest struct {
+	If      string `json:"if"`
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+func (c *ConditionRequest) ToCondition() *domain.Condition {
+	if c == nil {
+		return nil
+	}
+	return &domain.Condition{If: c.If, Value: []byte(c.Value), Version: c.Version}
+}
+
+type ScanEntriesResponse struct {
+	Entries []EntryResponse `json:"entries"`
+	Cursor  string          `json:"cursor,omitempty"`
 }
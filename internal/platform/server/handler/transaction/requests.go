@@ -0,0 +1,58 @@
+package transaction
+
+import "time"
+
+// ExecuteTransactionRequest lists the keys a transaction reads, puts and
+// deletes, all in one namespace. Puts expire like in SaveEntryRequest. Keys
+// and values, here and in the other bodies of this package, are base64 so
+// that any bytes survive the JSON.
+type ExecuteTransactionRequest struct {
+	Namespace string       `json:"namespace,omitempty"`
+	Reads     [][]byte     `json:"reads,omitempty"`
+	Puts      []PutRequest `json:"puts,omitempty"`
+	Deletes   [][]byte     `json:"deletes,omitempty"`
+}
+
+type PutRequest struct {
+	Key       []byte     `json:"key"`
+	Value     []byte     `json:"value"`
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExecuteTransactionResponse puts in Error why the transaction did not
+// commit: a failed condition or an abort
+type ExecuteTransactionResponse struct {
+	TransactionId string         `json:"transaction_id"`
+	Committed     bool           `json:"committed"`
+	Error         string         `json:"error,omitempty"`
+	Reads         []ReadResponse `json:"reads,omitempty"`
+}
+
+type ReadResponse struct {
+	Key       []byte     `json:"key"`
+	Value     []byte     `json:"value,omitempty"`
+	Found     bool       `json:"found"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type BeginTransactionRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type BeginTransactionResponse struct {
+	TransactionId string `json:"transaction_id"`
+}
+
+// PutValueRequest is the body of a write inside a transaction
+type PutValueRequest struct {
+	Value     []byte     `json:"value"`
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type CommitTransactionResponse struct {
+	TransactionId string `json:"transaction_id"`
+	Committed     bool   `json:"committed"`
+	Error         string `json:"error,omitempty"`
+}
@@ -0,0 +1,179 @@
+package transaction
+
+import (
+	"KVDB/internal/application/service"
+	"KVDB/internal/domain"
+	"KVDB/internal/platform/server/handler/dbentry"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	json "github.com/json-iterator/go"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+type SessionHandler struct {
+	sessionService *service.TransactionSessionService
+}
+
+func NewSessionHandler(sessionService *service.TransactionSessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+// BeginTransaction opens an interactive transaction, e.g.
+// POST /api/tx/begin {"namespace": "orders"}. The returned id scopes the
+// reads and writes that follow, e.g. GET /api/tx/{id}/db/{key}, until
+// POST /api/tx/{id}/commit or POST /api/tx/{id}/abort.
+func (h *SessionHandler) BeginTransaction(w http.ResponseWriter, r *http.Request) {
+	var request BeginTransactionRequest
+	body, err := io.ReadAll(r.Body)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid request")
+		return
+	}
+	if request.Namespace == "" {
+		request.Namespace = r.URL.Query().Get("namespace")
+	}
+	id, err := h.sessionService.Begin(client(r), request.Namespace)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	output, _ := json.Marshal(BeginTransactionResponse{TransactionId: id})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(output))
+}
+
+// GetEntry reads a key inside the transaction, seeing its own writes
+func (h *SessionHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
+	key, err := dbentry.KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
+	entry, found, err := h.sessionService.Get(chi.URLParam(r, "id"), key)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Not found")
+		return
+	}
+	output, _ := json.Marshal(dbentry.MapToEntryResponse(entry))
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// PutEntry buffers a write, applied only if the transaction commits
+func (h *SessionHandler) PutEntry(w http.ResponseWriter, r *http.Request) {
+	key, err := dbentry.KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
+	var request PutValueRequest
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid request")
+		return
+	}
+	put := service.TransactionPut{
+		Key:   key,
+		Value: request.Value,
+		TTL:   time.Duration(request.TTL) * time.Second,
+	}
+	if request.ExpiresAt != nil {
+		put.ExpiresAt = *request.ExpiresAt
+	}
+	entry, err := h.sessionService.Put(chi.URLParam(r, "id"), put)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	output, _ := json.Marshal(dbentry.MapToEntryResponse(entry))
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(output))
+}
+
+// DeleteEntry buffers a delete, applied only if the transaction commits
+func (h *SessionHandler) DeleteEntry(w http.ResponseWriter, r *http.Request) {
+	key, err := dbentry.KeyParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid key")
+		return
+	}
+	if err := h.sessionService.Delete(chi.URLParam(r, "id"), key); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CommitTransaction answers 412 when a condition failed and 409 when the
+// transaction was aborted by a conflict
+func (h *SessionHandler) CommitTransaction(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := h.sessionService.Commit(id)
+	if errors.Is(err, service.ErrSessionNotFound) {
+		writeSessionError(w, err)
+		return
+	}
+	response := CommitTransactionResponse{TransactionId: id, Committed: err == nil}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	output, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(rejectionStatus(err))
+	}
+	fmt.Fprint(w, string(output))
+}
+
+func (h *SessionHandler) AbortTransaction(w http.ResponseWriter, r *http.Request) {
+	if err := h.sessionService.Abort(chi.URLParam(r, "id")); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// client tells apart the owner of a session by its address, which unlike a
+// header it cannot pick to get around the per-client limit
+func client(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound), errors.Is(err, domain.ErrNamespaceNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, service.ErrTooManySessions):
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprint(w, err.Error())
+}
@@ -0,0 +1,117 @@
+package transaction
+
+import (
+	"KVDB/internal/application/service"
+	"KVDB/internal/domain"
+	"errors"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"io"
+	"net/http"
+	"time"
+)
+
+type TransactionHandler struct {
+	executeService *service.ExecuteTransactionService
+}
+
+func NewTransactionHandler(executeService *service.ExecuteTransactionService) *TransactionHandler {
+	return &TransactionHandler{
+		executeService: executeService,
+	}
+}
+
+// ExecuteTransaction runs the reads, puts and deletes of the body as one
+// transaction, e.g. POST /api/tx
+// {"reads": ["c3RvY2s6Nw=="], "puts": [{"key": "b3JkZXI6MQ==", "value": "Li4u"}], "deletes": ["Y2FydDoz"]}
+// reads stock:7, puts order:1 and deletes cart:3.
+// A transaction that did not commit answers with the values it read, and
+// 412 if a condition failed or 409 if it was aborted.
+func (h *TransactionHandler) ExecuteTransaction(w http.ResponseWriter, r *http.Request) {
+	var request ExecuteTransactionRequest
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid request")
+		return
+	}
+	if request.Namespace == "" {
+		request.Namespace = r.URL.Query().Get("namespace")
+	}
+
+	result := h.executeService.Execute(MapToCommand(request))
+	if result.Err != nil {
+		switch {
+		case errors.Is(result.Err, service.ErrInvalidTransaction):
+			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(result.Err, domain.ErrNamespaceNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, result.Err.Error())
+		return
+	}
+
+	output, _ := json.Marshal(MapToResponse(result))
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Committed {
+		w.WriteHeader(rejectionStatus(result.Reason))
+	}
+	fmt.Fprint(w, string(output))
+}
+
+func MapToCommand(request ExecuteTransactionRequest) service.ExecuteTransactionCommand {
+	command := service.ExecuteTransactionCommand{
+		Namespace: request.Namespace,
+		Reads:     request.Reads,
+		Deletes:   request.Deletes,
+	}
+	for _, put := range request.Puts {
+		p := service.TransactionPut{
+			Key:   put.Key,
+			Value: put.Value,
+			TTL:   time.Duration(put.TTL) * time.Second,
+		}
+		if put.ExpiresAt != nil {
+			p.ExpiresAt = *put.ExpiresAt
+		}
+		command.Puts = append(command.Puts, p)
+	}
+	return command
+}
+
+func MapToResponse(result service.ExecuteTransactionResult) ExecuteTransactionResponse {
+	response := ExecuteTransactionResponse{
+		TransactionId: result.TransactionId,
+		Committed:     result.Committed,
+	}
+	if result.Reason != nil {
+		response.Error = result.Reason.Error()
+	}
+	for _, read := range result.Reads {
+		r := ReadResponse{Key: read.Key, Found: read.Found}
+		if read.Found {
+			r.Value = read.Entry.ValueBytes()
+			r.ExpiresAt = read.Entry.Deadline()
+		}
+		response.Reads = append(response.Reads, r)
+	}
+	return response
+}
+
+// rejectionStatus maps why a transaction did not commit to the status a
+// single-key write answers with for the same reason
+func rejectionStatus(reason error) int {
+	switch {
+	case errors.Is(reason, domain.ErrInvalidCondition):
+		return http.StatusBadRequest
+	case errors.Is(reason, domain.ErrConditionFailed):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusConflict
+	}
+}
@@ -2,9 +2,11 @@ package server
 
 import (
 	"KVDB/internal/platform/config"
+	"KVDB/internal/platform/server/handler/admin"
 	"KVDB/internal/platform/server/handler/dbentry"
 	"KVDB/internal/platform/server/handler/dbinstance"
 	"KVDB/internal/platform/server/handler/health"
+	"KVDB/internal/platform/server/handler/transaction"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -23,11 +25,17 @@ type Server struct {
 	engine          *chi.Mux
 	entryHandler    *dbentry.DbEntryHandler
 	instanceHandler *dbinstance.DbInstanceHandler
+	adminHandler    *admin.AdminHandler
+	txHandler       *transaction.TransactionHandler
+	sessionHandler  *transaction.SessionHandler
 	config          config.Config
 }
 
 func NewServer(entryHandler *dbentry.DbEntryHandler,
 	instanceHandler *dbinstance.DbInstanceHandler,
+	adminHandler *admin.AdminHandler,
+	txHandler *transaction.TransactionHandler,
+	sessionHandler *transaction.SessionHandler,
 	config config.Config) Server {
 	url := fmt.Sprintf("%s:%d", host, config.ServerPort)
 	srv := Server{
@@ -35,6 +43,9 @@ func NewServer(entryHandler *dbentry.DbEntryHandler,
 		httpAddr:        url,
 		entryHandler:    entryHandler,
 		instanceHandler: instanceHandler,
+		adminHandler:    adminHandler,
+		txHandler:       txHandler,
+		sessionHandler:  sessionHandler,
 		config:          config,
 	}
 	if !strings.Contains(config.DeploymentMode, "performance") {
@@ -53,10 +64,28 @@ func (s *Server) Run() error {
 func (s *Server) registerRoutes() {
 	s.engine.Get("/health", health.CheckHandler)
 	s.engine.Route("/api", func(r chi.Router) {
+		r.Get("/db", s.entryHandler.ScanEntries)
 		r.Get("/db/{key}", s.entryHandler.GetEntry)
 		r.Post("/db", s.entryHandler.SaveEntry)
+		r.Put("/db/{key}", s.entryHandler.PutEntry)
 		r.Delete("/db/{key}", s.entryHandler.DeleteEntry)
+		r.Post("/tx", s.txHandler.ExecuteTransaction)
+		r.Post("/tx/begin", s.sessionHandler.BeginTransaction)
+		r.Get("/tx/{id}/db/{key}", s.sessionHandler.GetEntry)
+		r.Put("/tx/{id}/db/{key}", s.sessionHandler.PutEntry)
+		r.Delete("/tx/{id}/db/{key}", s.sessionHandler.DeleteEntry)
+		r.Post("/tx/{id}/commit", s.sessionHandler.CommitTransaction)
+		r.Post("/tx/{id}/abort", s.sessionHandler.AbortTransaction)
 
 		r.Post("/v1/instances", s.instanceHandler.UpdateDbInstances)
 	})
+	s.engine.Route("/admin", func(r chi.Router) {
+		r.Get("/stats/compaction", s.adminHandler.GetCompactionStats)
+		r.Get("/stats/bloom-filter", s.adminHandler.GetBloomFilterStats)
+		r.Get("/stats/cache", s.adminHandler.GetCacheStats)
+		r.Post("/checkpoint", s.adminHandler.CreateCheckpoint)
+		r.Get("/namespaces", s.adminHandler.ListNamespaces)
+		r.Post("/namespaces", s.adminHandler.CreateNamespace)
+		r.Delete("/namespaces/{name}", s.adminHandler.DropNamespace)
+	})
 }
@@ -11,7 +11,9 @@ import (
 	"strings"
 )
 
-// AppendDbEntry escribe una entrada completa en una sola línea
+// AppendDbEntry escribe una entrada completa en una sola línea.
+// Es el formato de texto antiguo del WAL; se conserva para convertir los
+// segmentos existentes al formato binario.
 func AppendDbEntry(f io.Writer, entry DbEntry) error {
 	keyBytes := []byte(entry.Key())
 	valueBytes := []byte(entry.Value())
@@ -54,94 +56,108 @@ func AppendDbEntry(f io.Writer, entry DbEntry) error {
 	return err
 }
 
-// ReadOneEntry lee una sola entrada desde una línea
-func ReadOneEntry(r *bufio.Scanner) (DbEntry, error) {
+// ErrTornEntry indica que la entrada termina antes de lo que dicen sus
+// longitudes: una escritura interrumpida por una caída.
+var ErrTornEntry = errors.New("entrada incompleta")
+
+// ReadOneEntry lee una sola entrada. Las longitudes de la key y del value
+// dicen cuántos bytes leer, así que pueden contener comas y saltos de línea.
+func ReadOneEntry(r *bufio.Reader) (DbEntry, error) {
 	var entry DbEntry
 
-	if !r.Scan() {
-		if err := r.Err(); err != nil {
+	// Las líneas vacías se saltan
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
 			return entry, err
 		}
-		return entry, io.EOF
-	}
-
-	line := r.Text()
-	if line == "" {
-		return entry, io.EOF
+		if b != '\n' {
+			r.UnreadByte()
+			break
+		}
 	}
 
-	// Parsear la línea: keyLen,key,valueLen,value,tombstone
-	parts := strings.Split(line, ",")
-	if len(parts) < 3 {
-		return entry, errors.New("formato de línea inválido")
+	keyLen, err := readLength(r)
+	if err != nil {
+		return entry, err
 	}
-
-	// Leer longitud de key
-	keyLen, err := strconv.ParseUint(parts[0], 10, 32)
+	key, err := readField(r, keyLen)
 	if err != nil {
-		return entry, fmt.Errorf("error parseando longitud de key: %v", err)
+		return entry, err
 	}
-
-	// Encontrar la key y value basándose en las longitudes
-	// Necesitamos reconstruir la línea porque la key/value pueden contener comas
-	remainingLine := strings.Join(parts[1:], ",")
-
-	if len(remainingLine) < int(keyLen) {
-		return entry, errors.New("longitud de key inválida")
+	valueLen, err := readLength(r)
+	if err != nil {
+		return entry, err
 	}
-
-	key := remainingLine[:keyLen]
-	remainingLine = remainingLine[keyLen:]
-
-	if !strings.HasPrefix(remainingLine, ",") {
-		return entry, errors.New("formato inválido después de key")
+	value, err := readField(r, valueLen)
+	if err != nil {
+		return entry, err
 	}
-	remainingLine = remainingLine[1:] // quitar la coma
 
-	// Leer longitud de value
-	commaIndex := strings.Index(remainingLine, ",")
-	if commaIndex == -1 {
-		return entry, errors.New("no se encontró separador después de longitud de value")
+	tombstoneStr, err := r.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		return entry, ErrTornEntry
 	}
-
-	valueLenStr := remainingLine[:commaIndex]
-	valueLen, err := strconv.ParseUint(valueLenStr, 10, 32)
 	if err != nil {
-		return entry, fmt.Errorf("error parseando longitud de value: %v", err)
+		return entry, err
 	}
-
-	remainingLine = remainingLine[commaIndex+1:]
-
-	if len(remainingLine) < int(valueLen) {
-		return entry, errors.New("longitud de value inválida")
+	tombstoneVal, err := strconv.ParseUint(strings.TrimSuffix(tombstoneStr, "\n"), 10, 8)
+	if err != nil {
+		return entry, fmt.Errorf("error parseando tombstone: %v", err)
 	}
 
-	value := remainingLine[:valueLen]
-	remainingLine = remainingLine[valueLen:]
+	entry = NewDbEntry(string(key), string(value), tombstoneVal != 0)
+	return entry, nil
+}
 
-	if !strings.HasPrefix(remainingLine, ",") {
-		return entry, errors.New("formato inválido después de value")
+// readLength lee una longitud terminada en coma
+func readLength(r *bufio.Reader) (uint64, error) {
+	var digits []byte
+	for {
+		b, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return 0, ErrTornEntry
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b == ',' {
+			break
+		}
+		if b < '0' || b > '9' || len(digits) == 10 {
+			return 0, errors.New("formato de línea inválido")
+		}
+		digits = append(digits, b)
 	}
-	remainingLine = remainingLine[1:] // quitar la coma
-
-	// Leer tombstone
-	tombstoneStr := remainingLine
-	tombstoneVal, err := strconv.ParseUint(tombstoneStr, 10, 8)
+	n, err := strconv.ParseUint(string(digits), 10, 32)
 	if err != nil {
-		return entry, fmt.Errorf("error parseando tombstone: %v", err)
+		return 0, fmt.Errorf("error parseando longitud: %v", err)
 	}
+	return n, nil
+}
 
-	entry = NewDbEntry(key, value, tombstoneVal != 0)
-	return entry, nil
+// readField lee n bytes seguidos de una coma
+func readField(r *bufio.Reader, n uint64) ([]byte, error) {
+	field := make([]byte, n+1)
+	if _, err := io.ReadFull(r, field); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrTornEntry
+		}
+		return nil, err
+	}
+	if field[n] != ',' {
+		return nil, errors.New("formato inválido después del campo")
+	}
+	return field[:n], nil
 }
 
 // ReadAllEntries lee todas las entradas de un archivo WAL
 func ReadAllEntries(f io.Reader) ([]DbEntry, error) {
 	var entries []DbEntry
-	scanner := bufio.NewScanner(f)
+	reader := bufio.NewReader(f)
 
 	for {
-		entry, err := ReadOneEntry(scanner)
+		entry, err := ReadOneEntry(reader)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break // fin de archivo
@@ -153,3 +169,22 @@ func ReadAllEntries(f io.Reader) ([]DbEntry, error) {
 
 	return entries, nil
 }
+
+// ReadValidEntries lee las entradas de un WAL descartando un último registro
+// incompleto (escritura interrumpida por una caída). Un registro completo que
+// no se puede parsear se considera corrupción y devuelve error.
+func ReadValidEntries(f io.Reader) ([]DbEntry, error) {
+	var entries []DbEntry
+	reader := bufio.NewReader(f)
+
+	for {
+		entry, err := ReadOneEntry(reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrTornEntry) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
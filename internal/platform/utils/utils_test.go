@@ -21,9 +21,9 @@ func TestAppendDbEntryAndReadOneEntry(t *testing.T) {
 		t.Fatalf("AppendDbEntry falló: %v", err)
 	}
 
-	// Leer desde el buffer usando scanner
-	scanner := bufio.NewScanner(&buf)
-	readEntry, err := ReadOneEntry(scanner)
+	// Leer desde el buffer usando reader
+	reader := bufio.NewReader(&buf)
+	readEntry, err := ReadOneEntry(reader)
 	if err != nil {
 		t.Fatalf("ReadOneEntry falló: %v", err)
 	}
@@ -80,9 +80,9 @@ func TestReadAllEntries(t *testing.T) {
 
 func TestReadOneEntry_EOF(t *testing.T) {
 	empty := bytes.NewReader(nil)
-	scanner := bufio.NewScanner(empty)
+	reader := bufio.NewReader(empty)
 
-	_, err := ReadOneEntry(scanner)
+	_, err := ReadOneEntry(reader)
 	if err == nil {
 		t.Fatal("esperado error EOF, pero no se recibió error")
 	}
@@ -116,8 +116,8 @@ func TestAppendDbEntryWithCommasInData(t *testing.T) {
 	}
 
 	// Leer la entrada de vuelta
-	scanner := bufio.NewScanner(&buf)
-	readEntry, err := ReadOneEntry(scanner)
+	reader := bufio.NewReader(&buf)
+	readEntry, err := ReadOneEntry(reader)
 	if err != nil {
 		t.Fatalf("ReadOneEntry falló: %v", err)
 	}
@@ -159,3 +159,43 @@ func TestMultipleEntriesInBuffer(t *testing.T) {
 		}
 	}
 }
+
+func TestReadValidEntries_DiscardsTornRecord(t *testing.T) {
+	var buf bytes.Buffer
+	AppendDbEntry(&buf, NewDbEntry("key1", "value1", false))
+	AppendDbEntry(&buf, NewDbEntry("key2", "value2", true))
+	buf.WriteString("4,key3,6,val")
+
+	entries, err := ReadValidEntries(&buf)
+	if err != nil {
+		t.Fatalf("ReadValidEntries falló: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("esperadas 2 entradas, obtenidas %d", len(entries))
+	}
+}
+
+func TestReadValidEntries_FailsOnCorruptedRecord(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("x,key1\n")
+	AppendDbEntry(&buf, NewDbEntry("key2", "value2", false))
+
+	if _, err := ReadValidEntries(&buf); err == nil {
+		t.Fatal("se esperaba error por registro corrupto")
+	}
+}
+
+func TestReadOneEntry_BinaryData(t *testing.T) {
+	entry := NewDbEntry("key\nwith,newline", "\x00\xff\n,1\n\n", false)
+	var buf bytes.Buffer
+	AppendDbEntry(&buf, entry)
+	AppendDbEntry(&buf, NewDbEntry("next", "value", true))
+
+	entries, err := ReadAllEntries(&buf)
+	if err != nil {
+		t.Fatalf("ReadAllEntries falló: %v", err)
+	}
+	if len(entries) != 2 || entries[0] != entry {
+		t.Fatalf("la entrada binaria no se leyó igual: %+v", entries)
+	}
+}
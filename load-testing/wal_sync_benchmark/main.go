@@ -0,0 +1,159 @@
+package main
+
+import (
+	. "KVDB/internal/domain"
+	"KVDB/internal/platform/repository/lsm_tree"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Métricas de un modo de sincronización
+type ModeResult struct {
+	Mode          string
+	Writes        int64
+	Errors        int64
+	ResponseTimes []time.Duration
+	StartTime     time.Time
+	EndTime       time.Time
+	mu            sync.Mutex
+}
+
+func (m *ModeResult) AddResult(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.Errors++
+		return
+	}
+	m.Writes++
+	m.ResponseTimes = append(m.ResponseTimes, duration)
+}
+
+func (m *ModeResult) CalculatePercentiles() map[string]time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.ResponseTimes) == 0 {
+		return make(map[string]time.Duration)
+	}
+
+	sort.Slice(m.ResponseTimes, func(i, j int) bool {
+		return m.ResponseTimes[i] < m.ResponseTimes[j]
+	})
+
+	return map[string]time.Duration{
+		"p50": m.ResponseTimes[int(float64(len(m.ResponseTimes))*0.50)],
+		"p90": m.ResponseTimes[int(float64(len(m.ResponseTimes))*0.90)],
+		"p99": m.ResponseTimes[int(float64(len(m.ResponseTimes))*0.99)],
+	}
+}
+
+func (m *ModeResult) GetWPS() float64 {
+	duration := m.EndTime.Sub(m.StartTime).Seconds()
+	if duration == 0 {
+		return 0
+	}
+	return float64(m.Writes) / duration
+}
+
+// Worker que escribe en el WAL hasta que se acaba el tiempo
+func worker(id int, wal *lsm_tree.WAL, value string, duration time.Duration,
+	result *ModeResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	endTime := time.Now().Add(duration)
+	for i := 0; time.Now().Before(endTime); i++ {
+		entry := NewDbEntry(fmt.Sprintf("key_%d_%d", id, i), value, false)
+
+		start := time.Now()
+		err := wal.Write(entry)
+		result.AddResult(time.Since(start), err)
+	}
+}
+
+func runMode(mode string, opts lsm_tree.WalOptions, workers int, duration time.Duration, valueSize int) (*ModeResult, error) {
+	dir, err := os.MkdirTemp("", "wal-bench-"+mode)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	opts.SyncMode = mode
+	wal, err := lsm_tree.NewWal(dir, 1, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	value := strings.Repeat("x", valueSize)
+	result := &ModeResult{Mode: mode, StartTime: time.Now()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker(i, wal, value, duration, result, &wg)
+	}
+	wg.Wait()
+	result.EndTime = time.Now()
+
+	return result, wal.Close()
+}
+
+func printResults(results []*ModeResult) {
+	fmt.Println("\n" + strings.Repeat("=", 60))
+	fmt.Println("WAL SYNC BENCHMARK RESULTS")
+	fmt.Println(strings.Repeat("=", 60))
+
+	fmt.Printf("%-10s %12s %10s %12s %12s %12s\n", "MODE", "WRITES/S", "ERRORS", "P50", "P90", "P99")
+	for _, result := range results {
+		percentiles := result.CalculatePercentiles()
+		fmt.Printf("%-10s %12.2f %10d %12v %12v %12v\n",
+			result.Mode,
+			result.GetWPS(),
+			result.Errors,
+			percentiles["p50"],
+			percentiles["p90"],
+			percentiles["p99"])
+	}
+
+	fmt.Println(strings.Repeat("=", 60))
+}
+
+func main() {
+	var (
+		modes     = flag.String("modes", "always,group,periodic", "Comma separated sync modes to benchmark")
+		workers   = flag.Int("workers", 16, "Number of concurrent writers")
+		duration  = flag.Duration("duration", 10*time.Second, "Duration of each mode")
+		valueSize = flag.Int("value-size", 128, "Size in bytes of every value")
+		window    = flag.Duration("group-window", time.Millisecond, "Group commit window")
+		interval  = flag.Duration("sync-interval", 100*time.Millisecond, "Periodic sync interval")
+	)
+	flag.Parse()
+
+	fmt.Printf("Starting WAL benchmark with %d writers for %v per mode\n", *workers, *duration)
+	fmt.Printf("Value size: %d bytes\n", *valueSize)
+	fmt.Printf("Group commit window: %v | Sync interval: %v\n", *window, *interval)
+
+	opts := lsm_tree.WalOptions{
+		SyncInterval:      *interval,
+		GroupCommitWindow: *window,
+	}
+
+	var results []*ModeResult
+	for _, mode := range strings.Split(*modes, ",") {
+		fmt.Printf("\nRunning mode %q...\n", mode)
+		result, err := runMode(strings.TrimSpace(mode), opts, *workers, *duration, *valueSize)
+		if err != nil {
+			log.Fatalf("Mode %s failed: %v", mode, err)
+		}
+		results = append(results, result)
+	}
+
+	printResults(results)
+}